@@ -0,0 +1,312 @@
+package main
+
+// audit.go — the service's audit policy.
+//
+// Without a policy, Audit hands every image to core's scanner, which fails on
+// any HIGH or CRITICAL finding. A redis.audit.yaml next to
+// service.codefly.yaml changes the verdict:
+//
+//	# Findings at or above this severity fail the audit.
+//	fail-on: CRITICAL
+//	accept:
+//	  - id: CVE-2025-12345
+//	    expires: 2026-12-31
+//	    reason: redis never calls the affected libc function
+//
+// An accepted finding is suppressed until its expiry date, then fails again
+// so every exception is revisited. The policy applies to the redis image and
+// to the sidecar and job images of every environment.
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"slices"
+	"sort"
+	"strings"
+	"time"
+
+	builderv0 "github.com/codefly-dev/core/generated/go/codefly/services/builder/v0"
+	"gopkg.in/yaml.v3"
+)
+
+const auditPolicyFile = "redis.audit.yaml"
+
+// severities in increasing order, as trivy reports them.
+var severities = []string{"UNKNOWN", "LOW", "MEDIUM", "HIGH", "CRITICAL"}
+
+// AuditPolicy is the content of redis.audit.yaml.
+type AuditPolicy struct {
+	// FailOn is the lowest severity that fails the audit. Defaults to HIGH.
+	FailOn string `yaml:"fail-on,omitempty" json:"fail-on"`
+	// Accept lists the vulnerabilities tolerated until their expiry.
+	Accept []AcceptedVulnerability `yaml:"accept,omitempty" json:"accept,omitempty"`
+}
+
+// AcceptedVulnerability is one exception of the policy.
+type AcceptedVulnerability struct {
+	ID string `yaml:"id" json:"id"`
+	// Expires is the last day the exception applies, as YYYY-MM-DD.
+	Expires string `yaml:"expires" json:"expires"`
+	Reason  string `yaml:"reason,omitempty" json:"reason,omitempty"`
+}
+
+// AuditReport is the outcome of auditing every image against the policy.
+type AuditReport struct {
+	FailOn string       `json:"fail-on"`
+	Images []ImageAudit `json:"images"`
+}
+
+// ImageAudit sorts the findings of one image.
+type ImageAudit struct {
+	Image string `json:"image"`
+	// Failing findings are at or above the failing severity and not accepted,
+	// or accepted past their expiry.
+	Failing []AuditFinding `json:"failing,omitempty"`
+	// Suppressed findings would fail but are accepted by the policy.
+	Suppressed []AuditFinding `json:"suppressed,omitempty"`
+	// BelowThreshold counts the findings under the failing severity.
+	BelowThreshold int `json:"below-threshold"`
+}
+
+// AuditFinding is a vulnerability and the exception covering it, if any.
+type AuditFinding struct {
+	ID               string                 `json:"id"`
+	Package          string                 `json:"package"`
+	InstalledVersion string                 `json:"installed-version"`
+	FixedVersion     string                 `json:"fixed-version,omitempty"`
+	Severity         string                 `json:"severity"`
+	Accepted         *AcceptedVulnerability `json:"accepted,omitempty"`
+}
+
+// Passed reports whether no image has a failing finding.
+func (r *AuditReport) Passed() bool {
+	for _, audit := range r.Images {
+		if len(audit.Failing) > 0 {
+			return false
+		}
+	}
+	return true
+}
+
+// introducedFailures lists the failing findings of a, with their severity,
+// whose IDs the audit of the image it replaces does not report.
+func (a ImageAudit) introducedFailures(replaced ImageAudit) []string {
+	known := map[string]bool{}
+	for _, finding := range append(slices.Clone(replaced.Failing), replaced.Suppressed...) {
+		known[finding.ID] = true
+	}
+	var introduced []string
+	for _, finding := range a.Failing {
+		if !known[finding.ID] {
+			introduced = append(introduced, finding.ID+" ("+finding.Severity+")")
+		}
+	}
+	return introduced
+}
+
+// summary lists the failing findings per image.
+func (r *AuditReport) summary() string {
+	var out strings.Builder
+	for _, audit := range r.Images {
+		if len(audit.Failing) == 0 {
+			continue
+		}
+		ids := make([]string, 0, len(audit.Failing))
+		for _, finding := range audit.Failing {
+			id := finding.ID + " (" + finding.Severity
+			if finding.Accepted != nil {
+				id += ", accepted until " + finding.Accepted.Expires
+			}
+			ids = append(ids, id+")")
+		}
+		fmt.Fprintf(&out, "%s: %s; ", audit.Image, strings.Join(ids, ", "))
+	}
+	return strings.TrimSuffix(out.String(), "; ")
+}
+
+// response reports the verdict to core: a failed policy is a FAILED state,
+// with the failing and the accepted findings of every image.
+func (r *AuditReport) response() *builderv0.AuditResponse {
+	response := &builderv0.AuditResponse{State: &builderv0.AuditStatus{State: builderv0.AuditStatus_PASSED}}
+	suppressed := 0
+	for _, audit := range r.Images {
+		for _, finding := range append(slices.Clone(audit.Failing), audit.Suppressed...) {
+			response.Findings = append(response.Findings, &builderv0.AuditFinding{
+				Image:            audit.Image,
+				Id:               finding.ID,
+				Package:          finding.Package,
+				InstalledVersion: finding.InstalledVersion,
+				FixedVersion:     finding.FixedVersion,
+				Severity:         finding.Severity,
+			})
+		}
+		suppressed += len(audit.Suppressed)
+	}
+	if !r.Passed() {
+		response.State.State = builderv0.AuditStatus_FAILED
+		response.State.Message = fmt.Sprintf("audit found vulnerabilities at or above %s: %s", r.FailOn, r.summary())
+		return response
+	}
+	response.State.Message = "no vulnerabilities at or above " + r.FailOn
+	if suppressed > 0 {
+		response.State.Message += fmt.Sprintf(", %d accepted by the policy", suppressed)
+	}
+	return response
+}
+
+// readAuditPolicy reads and validates the policy at path.
+func readAuditPolicy(path string) (*AuditPolicy, error) {
+	content, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	policy := &AuditPolicy{}
+	if err = yaml.Unmarshal(content, policy); err != nil {
+		return nil, fmt.Errorf("invalid %s: %w", path, err)
+	}
+	if err = policy.validate(); err != nil {
+		return nil, fmt.Errorf("invalid %s: %w", path, err)
+	}
+	return policy, nil
+}
+
+func (p *AuditPolicy) validate() error {
+	if p.FailOn == "" {
+		p.FailOn = "HIGH"
+	}
+	p.FailOn = strings.ToUpper(p.FailOn)
+	if !slices.Contains(severities, p.FailOn) {
+		return fmt.Errorf("unknown fail-on severity %q (want one of %s)", p.FailOn, strings.Join(severities, ", "))
+	}
+	seen := map[string]bool{}
+	for _, accepted := range p.Accept {
+		if accepted.ID == "" {
+			return fmt.Errorf("accepted vulnerability without an id")
+		}
+		if seen[accepted.ID] {
+			return fmt.Errorf("vulnerability %s is accepted twice", accepted.ID)
+		}
+		seen[accepted.ID] = true
+		if _, err := time.Parse(time.DateOnly, accepted.Expires); err != nil {
+			return fmt.Errorf("vulnerability %s needs an expires date as YYYY-MM-DD", accepted.ID)
+		}
+	}
+	return nil
+}
+
+// evaluate sorts the findings of img, as of today.
+func (p *AuditPolicy) evaluate(img string, findings []vulnerability, today time.Time) ImageAudit {
+	accepted := map[string]AcceptedVulnerability{}
+	for _, acceptance := range p.Accept {
+		accepted[acceptance.ID] = acceptance
+	}
+	threshold := slices.Index(severities, p.FailOn)
+	audit := ImageAudit{Image: img}
+	seen := map[vulnerability]bool{}
+	for _, v := range findings {
+		if seen[v] {
+			// The same package can show up in several scan targets.
+			continue
+		}
+		seen[v] = true
+		if slices.Index(severities, v.Severity) < threshold {
+			audit.BelowThreshold++
+			continue
+		}
+		finding := AuditFinding{
+			ID:               v.ID,
+			Package:          v.Package,
+			InstalledVersion: v.InstalledVersion,
+			FixedVersion:     v.FixedVersion,
+			Severity:         v.Severity,
+		}
+		acceptance, ok := accepted[v.ID]
+		if !ok {
+			audit.Failing = append(audit.Failing, finding)
+			continue
+		}
+		finding.Accepted = &acceptance
+		if expires, _ := time.Parse(time.DateOnly, acceptance.Expires); today.After(expires) {
+			audit.Failing = append(audit.Failing, finding)
+		} else {
+			audit.Suppressed = append(audit.Suppressed, finding)
+		}
+	}
+	sortFindings(audit.Failing)
+	sortFindings(audit.Suppressed)
+	return audit
+}
+
+func sortFindings(findings []AuditFinding) {
+	sort.Slice(findings, func(i, j int) bool {
+		if findings[i].ID != findings[j].ID {
+			return findings[i].ID < findings[j].ID
+		}
+		return findings[i].Package < findings[j].Package
+	})
+}
+
+// AuditReport audits the images the service runs and deploys against its
+// policy.
+func (s *Builder) AuditReport(ctx context.Context) (*AuditReport, error) {
+	policy, err := s.auditPolicy()
+	if err != nil {
+		return nil, err
+	}
+	images, err := s.deployedImages()
+	if err != nil {
+		return nil, err
+	}
+	return s.auditImages(ctx, policy, images)
+}
+
+// auditPolicy is the service's redis.audit.yaml, the default HIGH threshold
+// when it does not exist.
+func (s *Builder) auditPolicy() (*AuditPolicy, error) {
+	policy, err := readAuditPolicy(filepath.Join(s.Location, auditPolicyFile))
+	if errors.Is(err, fs.ErrNotExist) {
+		policy = &AuditPolicy{}
+		err = policy.validate()
+	}
+	return policy, err
+}
+
+func (s *Builder) auditImages(ctx context.Context, policy *AuditPolicy, images []string) (*AuditReport, error) {
+	// Expiry dates are days: an exception holds through its last day in UTC.
+	today := time.Now().UTC().Truncate(24 * time.Hour)
+	report := &AuditReport{FailOn: policy.FailOn}
+	for _, img := range images {
+		findings, err := scanImage(ctx, img)
+		if err != nil {
+			return nil, err
+		}
+		report.Images = append(report.Images, policy.evaluate(img, findings, today))
+	}
+	return report, nil
+}
+
+// combineAudits builds the response of the service from the audits of its
+// images, in the same order: every finding names its image, and the service
+// fails when one image does.
+func combineAudits(images []string, audits []*builderv0.AuditResponse) *builderv0.AuditResponse {
+	combined := &builderv0.AuditResponse{State: &builderv0.AuditStatus{State: builderv0.AuditStatus_PASSED}}
+	var failures []string
+	for i, audit := range audits {
+		for _, finding := range audit.GetFindings() {
+			if finding.Image == "" {
+				finding.Image = images[i]
+			}
+			combined.Findings = append(combined.Findings, finding)
+		}
+		if audit.GetState().GetState() == builderv0.AuditStatus_FAILED {
+			combined.State.State = builderv0.AuditStatus_FAILED
+			failures = append(failures, images[i]+": "+audit.GetState().GetMessage())
+		}
+	}
+	combined.State.Message = strings.Join(failures, "; ")
+	return combined
+}
@@ -0,0 +1,171 @@
+package main
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+	"testing"
+	"time"
+
+	builderv0 "github.com/codefly-dev/core/generated/go/codefly/services/builder/v0"
+)
+
+func TestAuditPolicyEvaluate(t *testing.T) {
+	policy := &AuditPolicy{
+		FailOn: "medium",
+		Accept: []AcceptedVulnerability{
+			{ID: "CVE-2026-0001", Expires: "2026-10-18", Reason: "not reachable"},
+			{ID: "CVE-2025-0002", Expires: "2026-10-17"},
+		},
+	}
+	if err := policy.validate(); err != nil {
+		t.Fatal(err)
+	}
+	findings := []vulnerability{
+		{ID: "CVE-2026-0001", Package: "musl", Severity: "CRITICAL"},
+		{ID: "CVE-2026-0001", Package: "musl", Severity: "CRITICAL"},
+		{ID: "CVE-2025-0002", Package: "openssl", Severity: "HIGH"},
+		{ID: "CVE-2026-0003", Package: "busybox", Severity: "MEDIUM"},
+		{ID: "CVE-2026-0004", Package: "zlib", Severity: "LOW"},
+		{ID: "CVE-2026-0005", Package: "zlib", Severity: "UNKNOWN"},
+	}
+	today := time.Date(2026, 10, 18, 0, 0, 0, 0, time.UTC)
+
+	audit := policy.evaluate("redis:8.8.0-alpine", findings, today)
+	if got := findingIDs(audit.Suppressed); got != "CVE-2026-0001" {
+		t.Errorf("suppressed = %s, want the exception on its last day", got)
+	}
+	if got := findingIDs(audit.Failing); got != "CVE-2025-0002 CVE-2026-0003" {
+		t.Errorf("failing = %s, want the expired exception and the unaccepted MEDIUM", got)
+	}
+	if audit.Failing[0].Accepted == nil || audit.Failing[0].Accepted.Expires != "2026-10-17" {
+		t.Errorf("expired finding does not carry its exception: %+v", audit.Failing[0])
+	}
+	if audit.BelowThreshold != 2 {
+		t.Errorf("below threshold = %d, want 2", audit.BelowThreshold)
+	}
+}
+
+func TestAuditPolicyValidate(t *testing.T) {
+	tests := []struct {
+		name    string
+		policy  AuditPolicy
+		message string
+	}{
+		{name: "unknown severity", policy: AuditPolicy{FailOn: "SEVERE"}, message: "unknown fail-on severity"},
+		{name: "missing id", policy: AuditPolicy{Accept: []AcceptedVulnerability{{Expires: "2026-12-31"}}}, message: "without an id"},
+		{name: "missing expiry", policy: AuditPolicy{Accept: []AcceptedVulnerability{{ID: "CVE-2026-0001"}}}, message: "needs an expires date"},
+		{
+			name:    "accepted twice",
+			policy:  AuditPolicy{Accept: []AcceptedVulnerability{{ID: "CVE-2026-0001", Expires: "2026-12-31"}, {ID: "CVE-2026-0001", Expires: "2027-01-31"}}},
+			message: "accepted twice",
+		},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			if err := test.policy.validate(); err == nil || !strings.Contains(err.Error(), test.message) {
+				t.Fatalf("validate error = %v, want %q", err, test.message)
+			}
+		})
+	}
+}
+
+func TestAuditAppliesPolicyToSidecarImages(t *testing.T) {
+	builder := NewBuilder()
+	builder.Location = t.TempDir()
+	builder.Deployment.Metrics.Enabled = true
+	policy := "fail-on: critical\naccept:\n  - id: CVE-2026-0001\n    expires: 2999-12-31\n"
+	if err := os.WriteFile(filepath.Join(builder.Location, auditPolicyFile), []byte(policy), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	redis := `{"Results":[{"Vulnerabilities":[
+		{"VulnerabilityID":"CVE-2026-0001","PkgName":"musl","Severity":"CRITICAL"},
+		{"VulnerabilityID":"CVE-2026-0002","PkgName":"musl","Severity":"HIGH"}]}]}`
+	exporter := `{"Results":[{"Vulnerabilities":[{"VulnerabilityID":"CVE-2026-0003","PkgName":"stdlib","Severity":"CRITICAL"}]}]}`
+	useFakeTrivy(t, exporterImage.Tag, redis, exporter)
+
+	report, err := builder.AuditReport(context.Background())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(report.Images) != 2 || report.Images[1].Image != exporterImage.FullName() {
+		t.Fatalf("audited images = %+v, want redis and the exporter", report.Images)
+	}
+	if redisAudit := report.Images[0]; len(redisAudit.Failing) != 0 || findingIDs(redisAudit.Suppressed) != "CVE-2026-0001" || redisAudit.BelowThreshold != 1 {
+		t.Errorf("redis audit = %+v", redisAudit)
+	}
+	if report.Passed() {
+		t.Error("report passed with a failing exporter finding")
+	}
+
+	response, err := builder.Audit(context.Background(), nil)
+	if err != nil {
+		t.Fatalf("Audit error = %v, want a failed audit instead", err)
+	}
+	state := response.GetState()
+	if state.GetState() != builderv0.AuditStatus_FAILED {
+		t.Errorf("Audit state = %v, want FAILED", state.GetState())
+	}
+	if message := state.GetMessage(); !strings.Contains(message, exporterImage.FullName()+": CVE-2026-0003 (CRITICAL)") || strings.Contains(message, "CVE-2026-0001") {
+		t.Errorf("Audit message = %q, want the exporter's unaccepted finding only", message)
+	}
+	var findings []string
+	for _, finding := range response.GetFindings() {
+		findings = append(findings, finding.Image+" "+finding.Id)
+	}
+	if want := []string{report.Images[0].Image + " CVE-2026-0001", exporterImage.FullName() + " CVE-2026-0003"}; !slices.Equal(findings, want) {
+		t.Errorf("Audit findings = %v, want %v", findings, want)
+	}
+
+	// Accepting the exporter's finding passes the audit.
+	policy += "  - id: CVE-2026-0003\n    expires: 2999-12-31\n"
+	if err = os.WriteFile(filepath.Join(builder.Location, auditPolicyFile), []byte(policy), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	if response, err = builder.Audit(context.Background(), nil); err != nil {
+		t.Fatal(err)
+	}
+	if state := response.GetState(); state.GetState() != builderv0.AuditStatus_PASSED || state.GetMessage() != "no vulnerabilities at or above CRITICAL, 2 accepted by the policy" {
+		t.Errorf("Audit state = %+v, want a pass with the accepted findings", state)
+	}
+}
+
+func findingIDs(findings []AuditFinding) string {
+	ids := make([]string, 0, len(findings))
+	for _, finding := range findings {
+		ids = append(ids, finding.ID)
+	}
+	return strings.Join(ids, " ")
+}
+
+func TestCombineAudits(t *testing.T) {
+	images := []string{"redis:8.8.0-alpine", exporterImage.FullName()}
+	audits := []*builderv0.AuditResponse{
+		{
+			State:    &builderv0.AuditStatus{State: builderv0.AuditStatus_PASSED},
+			Findings: []*builderv0.AuditFinding{{Id: "CVE-2026-0004", Package: "zlib", Severity: "LOW"}},
+		},
+		{
+			State:    &builderv0.AuditStatus{State: builderv0.AuditStatus_FAILED, Message: "1 CRITICAL"},
+			Findings: []*builderv0.AuditFinding{{Id: "CVE-2026-0003", Package: "busybox", Severity: "CRITICAL"}},
+		},
+	}
+	combined := combineAudits(images, audits)
+	if combined.GetState().GetState() != builderv0.AuditStatus_FAILED {
+		t.Errorf("state = %v, want FAILED when one image fails", combined.GetState().GetState())
+	}
+	if want := exporterImage.FullName() + ": 1 CRITICAL"; combined.GetState().GetMessage() != want {
+		t.Errorf("message = %q, want %q", combined.GetState().GetMessage(), want)
+	}
+	findings := combined.GetFindings()
+	if len(findings) != 2 || findings[0].Image != images[0] || findings[1].Image != images[1] {
+		t.Errorf("findings = %+v, want one per image, naming it", findings)
+	}
+
+	combined = combineAudits(images[:1], audits[:1])
+	if combined.GetState().GetState() != builderv0.AuditStatus_PASSED || combined.GetState().GetMessage() != "" {
+		t.Errorf("passing audit = %+v", combined.GetState())
+	}
+}
@@ -0,0 +1,144 @@
+package main
+
+// backup.go — scheduled RDB backups and restores for Kubernetes deployments.
+//
+// The backup CronJob cannot mount the redis data volume (ReadWriteOnce, held by
+// the redis pod), so it asks redis for the snapshot instead: `redis-cli --rdb`
+// makes the server BGSAVE and streams the resulting dump.rdb over the
+// connection. The file then lands on a dedicated backup PVC or is uploaded with
+// the MinIO client to any S3-compatible endpoint (AWS S3, MinIO, ...).
+//
+// A restore seeds the data volume of pod 0 from one of those backups: init
+// containers of the StatefulSet, which do nothing in the other pods, fetch the
+// file and copy it in before redis starts. Existing data is never
+// overwritten. Every pod mounts the backup source, so a restore from the
+// ReadWriteOnce backup PVC is limited to the single-pod standalone topology,
+// and the backup CronJob then runs on pod 0's node.
+
+import (
+	"fmt"
+	"path"
+	"strings"
+
+	"github.com/codefly-dev/core/resources"
+)
+
+// backupClientImage is the MinIO client used to reach S3-compatible storage.
+// The first deployment with an s3 target pins its digest in redis.lock.yaml.
+var backupClientImage = &resources.DockerImage{
+	Name: "minio/mc",
+	Tag:  "RELEASE.2024-11-21T17-21-54Z",
+}
+
+// Backup targets.
+const (
+	BackupTargetVolume = "pvc"
+	BackupTargetS3     = "s3"
+)
+
+// BackupSettings render a backup CronJob. Backups are off until a schedule is
+// set.
+type BackupSettings struct {
+	// Schedule is a cron expression, e.g. "0 3 * * *".
+	Schedule string `yaml:"schedule,omitempty"`
+	// RetentionDays is how long backups are kept.
+	RetentionDays int `yaml:"retention-days,omitempty"`
+	// Target is where backups are stored: pvc or s3.
+	Target string               `yaml:"target,omitempty"`
+	Volume BackupVolumeSettings `yaml:"volume,omitempty"`
+	S3     BackupS3Settings     `yaml:"s3,omitempty"`
+}
+
+// BackupVolumeSettings size the PersistentVolumeClaim backups are kept on.
+type BackupVolumeSettings struct {
+	Size         string `yaml:"size,omitempty"`
+	StorageClass string `yaml:"storage-class,omitempty"`
+}
+
+// BackupS3Settings locate an S3-compatible bucket.
+type BackupS3Settings struct {
+	// Endpoint is the URL of the S3 API, e.g. https://s3.amazonaws.com or
+	// http://minio.storage:9000.
+	Endpoint string `yaml:"endpoint,omitempty"`
+	Bucket   string `yaml:"bucket,omitempty"`
+	Prefix   string `yaml:"prefix,omitempty"`
+	// CredentialsSecret names a Secret holding AWS_ACCESS_KEY_ID and
+	// AWS_SECRET_ACCESS_KEY.
+	CredentialsSecret string `yaml:"credentials-secret,omitempty"`
+}
+
+// RestoreSettings seed the data volume from a backup on first start.
+type RestoreSettings struct {
+	// File is the backup to restore, as named on the backup target (e.g.
+	// redis-20260101T030000Z.rdb). Restores are off while it is empty.
+	File string `yaml:"file,omitempty"`
+}
+
+// BackupEnabled reports whether the backup CronJob renders.
+func (d *DeploymentSettings) BackupEnabled() bool {
+	return d.Backup.Schedule != ""
+}
+
+// RestoreEnabled reports whether pod 0 restores a backup on first start.
+func (d *DeploymentSettings) RestoreEnabled() bool {
+	return d.Restore.File != ""
+}
+
+// BackupVolumeEnabled reports whether backups are kept on a PVC.
+func (d *DeploymentSettings) BackupVolumeEnabled() bool {
+	return (d.BackupEnabled() || d.RestoreEnabled()) && d.Backup.Target == BackupTargetVolume
+}
+
+// BackupS3Enabled reports whether backups are kept in S3.
+func (d *DeploymentSettings) BackupS3Enabled() bool {
+	return (d.BackupEnabled() || d.RestoreEnabled()) && d.Backup.Target == BackupTargetS3
+}
+
+// BackupS3Path is the mc path of the backup directory: `backup/<bucket>/<prefix>/`.
+func (b BackupSettings) BackupS3Path() string {
+	return strings.TrimSuffix(path.Join("backup", b.S3.Bucket, b.S3.Prefix), "/") + "/"
+}
+
+var backupTargets = []string{BackupTargetVolume, BackupTargetS3}
+
+func (d *DeploymentSettings) validateBackup() error {
+	if !d.BackupEnabled() && !d.RestoreEnabled() {
+		return nil
+	}
+	// Each cluster node holds a different slice of the keyspace; a single
+	// snapshot cannot capture or restore it.
+	if d.Clustered() {
+		return fmt.Errorf("backup and restore are not supported for the cluster topology")
+	}
+	if d.BackupEnabled() {
+		if fields := strings.Fields(d.Backup.Schedule); len(fields) != 5 && !strings.HasPrefix(d.Backup.Schedule, "@") {
+			return fmt.Errorf("invalid backup schedule %q: want a cron expression", d.Backup.Schedule)
+		}
+		if d.Backup.RetentionDays < 1 {
+			return fmt.Errorf("backup retention-days must be at least 1, got %d", d.Backup.RetentionDays)
+		}
+	}
+	if d.RestoreEnabled() && (strings.ContainsAny(d.Restore.File, "/ ") || !strings.HasSuffix(d.Restore.File, ".rdb")) {
+		return fmt.Errorf("invalid restore file %q: want the name of an .rdb backup", d.Restore.File)
+	}
+	switch d.Backup.Target {
+	case BackupTargetVolume:
+		if d.RestoreEnabled() && d.RedisReplicas() > 1 {
+			return fmt.Errorf("restore from the %s backup target needs a single redis pod: use the %s target", BackupTargetVolume, BackupTargetS3)
+		}
+		if _, err := parseKubernetesMemory(d.Backup.Volume.Size); err != nil {
+			return fmt.Errorf("invalid backup volume size: %w", err)
+		}
+	case BackupTargetS3:
+		s3 := d.Backup.S3
+		if !strings.HasPrefix(s3.Endpoint, "http://") && !strings.HasPrefix(s3.Endpoint, "https://") {
+			return fmt.Errorf("invalid backup s3 endpoint %q: want an http(s) URL", s3.Endpoint)
+		}
+		if s3.Bucket == "" || s3.CredentialsSecret == "" {
+			return fmt.Errorf("backup s3 target needs a bucket and a credentials-secret")
+		}
+	default:
+		return fmt.Errorf("invalid backup target %q (want one of %s)", d.Backup.Target, strings.Join(backupTargets, ", "))
+	}
+	return nil
+}
@@ -2,8 +2,15 @@ package main
 
 import (
 	"context"
+	"crypto/sha256"
 	"embed"
+	"encoding/hex"
+	"errors"
 	"fmt"
+	"io/fs"
+	"path/filepath"
+	"slices"
+	"strings"
 
 	"github.com/codefly-dev/core/agents/communicate"
 	v0 "github.com/codefly-dev/core/generated/go/codefly/base/v0"
@@ -19,10 +26,82 @@ import (
 type Builder struct {
 	*services.DefaultBuilder
 	*Service
+
+	// resolveDigest is core's registry lookup of an image's digest, which
+	// Upgrade pins in the lockfile.
+	resolveDigest func(ctx context.Context, image string) (string, error)
 }
 
 type deploymentTemplateParameters struct {
 	PasswordReference *builderv0.KubernetesSecretKeyReference
+
+	// DeploymentSettings are the settings resolved for the target environment.
+	DeploymentSettings
+	// Directives are the non-secret redis.conf directives from Settings.
+	Directives []redisDirective
+	// ExporterImage is the metrics sidecar image.
+	ExporterImage string
+	// BackupClientImage uploads and downloads S3 backups.
+	BackupClientImage string
+	// KubectlImage labels replicated pods with their replication role.
+	KubectlImage string
+	// Scripts are the Lua scripts and libraries pods load after starting.
+	Scripts []scriptFile
+	// StreamProvisioning are the shell lines of the streams Job.
+	StreamProvisioning []string
+	// ReplicaCount replaces RedisReplicas in the StatefulSet when set; the
+	// Helm chart sets it to a value reference.
+	ReplicaCount string
+}
+
+func newDeploymentTemplateParameters() *deploymentTemplateParameters {
+	return &deploymentTemplateParameters{
+		DeploymentSettings: *defaultDeploymentSettings(),
+		ExporterImage:      imageReference(exporterImage),
+		BackupClientImage:  imageReference(backupClientImage),
+		KubectlImage:       imageReference(kubectlImage),
+	}
+}
+
+// RedisConfigLines are the lines of the redis.conf ConfigMap. The password is
+// never part of it: it reaches redis-server as a flag from the Secret.
+func (p *deploymentTemplateParameters) RedisConfigLines() []string {
+	lines := []string{
+		"dir /data",
+		// Pods are reached over the pod network; access is controlled by the
+		// password and the NetworkPolicy.
+		"protected-mode no",
+	}
+	switch {
+	case p.Replicated():
+		lines = append(lines, "include /run/redis/replication.conf")
+	case p.Clustered():
+		// nodes.conf lives on the data volume so a node keeps its identity and
+		// slots across restarts.
+		lines = append(lines,
+			"cluster-enabled yes",
+			"cluster-config-file /data/nodes.conf",
+			"cluster-preferred-endpoint-type hostname",
+		)
+	}
+	return append(lines, redisConfigLines(p.Directives)...)
+}
+
+// ConfigChecksum identifies the rendered redis.conf; it annotates the pod
+// template so a configuration change rolls the pods.
+func (p *deploymentTemplateParameters) ConfigChecksum() string {
+	hash := sha256.Sum256([]byte(strings.Join(p.RedisConfigLines(), "\n")))
+	return hex.EncodeToString(hash[:])
+}
+
+// ScriptsChecksum identifies the scripts ConfigMap: pods only load scripts
+// when they start, so a change rolls them too.
+func (p *deploymentTemplateParameters) ScriptsChecksum() string {
+	hash := sha256.New()
+	for _, script := range p.Scripts {
+		hash.Write([]byte(script.File + "\n" + script.Content + "\n"))
+	}
+	return hex.EncodeToString(hash.Sum(nil))
 }
 
 func NewBuilder() *Builder {
@@ -30,6 +109,7 @@ func NewBuilder() *Builder {
 	return &Builder{
 		DefaultBuilder: services.NewDefaultBuilder(service.Builder),
 		Service:        service,
+		resolveDigest:  upgrade.DockerDigest,
 	}
 }
 
@@ -52,38 +132,175 @@ func (s *Builder) Load(ctx context.Context, req *builderv0.LoadRequest) (*builde
 	})
 }
 
-// Audit scans the redis docker image for HIGH/CRITICAL CVEs via trivy.
+// Audit scans the redis docker image for HIGH/CRITICAL CVEs via trivy, plus
+// the auxiliary images any environment deploys. A redis.audit.yaml policy
+// replaces that verdict with its own threshold and accepted CVEs.
 func (s *Builder) Audit(ctx context.Context, req *builderv0.AuditRequest) (*builderv0.AuditResponse, error) {
 	defer s.Wool.Catch()
 	ctx = s.Wool.Inject(ctx)
-	return s.Builder.AuditContainer(ctx, req, image.FullName())
+	policy, err := readAuditPolicy(filepath.Join(s.Location, auditPolicyFile))
+	if err == nil {
+		return s.auditWithPolicy(ctx, policy)
+	}
+	if !errors.Is(err, fs.ErrNotExist) {
+		return nil, err
+	}
+	images, err := s.deployedImages()
+	if err != nil {
+		return nil, err
+	}
+	audits := make([]*builderv0.AuditResponse, 0, len(images))
+	for _, img := range images {
+		audit, err := s.Builder.AuditContainer(ctx, req, img)
+		if err != nil {
+			return nil, s.Wool.Wrapf(err, "cannot audit %s", img)
+		}
+		audits = append(audits, audit)
+	}
+	return combineAudits(images, audits), nil
+}
+
+// auditWithPolicy reports the findings the policy does not accept as a
+// failed audit.
+func (s *Builder) auditWithPolicy(ctx context.Context, policy *AuditPolicy) (*builderv0.AuditResponse, error) {
+	images, err := s.deployedImages()
+	if err != nil {
+		return nil, err
+	}
+	report, err := s.auditImages(ctx, policy, images)
+	if err != nil {
+		return nil, err
+	}
+	for _, audit := range report.Images {
+		for _, finding := range audit.Suppressed {
+			s.Wool.Info("accepted vulnerability", wool.Field("image", audit.Image), wool.Field("id", finding.ID),
+				wool.Field("severity", finding.Severity), wool.Field("expires", finding.Accepted.Expires))
+		}
+		s.Wool.Debug("audited image", wool.Field("image", audit.Image), wool.Field("failing", len(audit.Failing)),
+			wool.Field("suppressed", len(audit.Suppressed)), wool.Field("below-threshold", audit.BelowThreshold))
+	}
+	return report.response(), nil
 }
 
+// SBOM lists the components of the redis image, plus those of the auxiliary
+// images any environment deploys.
 func (s *Builder) SBOM(ctx context.Context, _ *builderv0.SBOMRequest) (*builderv0.SBOMResponse, error) {
 	defer s.Wool.Catch()
 	ctx = s.Wool.Inject(ctx)
-	return s.Builder.SBOMContainer(ctx, image.FullName())
+	images, err := s.deployedImages()
+	if err != nil {
+		return nil, err
+	}
+	sboms := make([]*builderv0.SBOMResponse, 0, len(images))
+	for _, img := range images {
+		sbom, err := s.Builder.SBOMContainer(ctx, img)
+		if err != nil {
+			return nil, s.Wool.Wrapf(err, "cannot generate the SBOM of %s", img)
+		}
+		sboms = append(sboms, sbom)
+	}
+	return combineSBOMs(sboms), nil
+}
+
+// deployedImages lists the redis image first, then the redis images
+// environments override it with, then the sidecar and job images enabled in
+// at least one environment.
+func (s *Builder) deployedImages() ([]string, error) {
+	environments, err := s.Deployment.allEnvironments()
+	if err != nil {
+		return nil, err
+	}
+	img, err := s.redisImage()
+	if err != nil {
+		return nil, err
+	}
+	images := []string{img.FullName()}
+	for _, settings := range environments {
+		deployed, err := settings.Image.apply(img)
+		if err != nil {
+			return nil, err
+		}
+		if !slices.Contains(images, deployed.FullName()) {
+			images = append(images, deployed.FullName())
+		}
+	}
+	for _, deployed := range sidecars {
+		if !slices.ContainsFunc(environments, deployed.Deployed) {
+			continue
+		}
+		sidecarImg, err := s.sidecarImage(deployed.Key)
+		if err != nil {
+			return nil, err
+		}
+		images = append(images, sidecarImg.FullName())
+	}
+	return images, nil
 }
 
-// Upgrade reports a newer redis tag (within current major unless --major).
+// Upgrade reports the newer redis tag core proposes (within current major
+// unless --major). Without --dry-run it pins that tag and its digest in
+// redis.lock.yaml, unless the new image introduces findings failing the
+// audit policy or the image settings pin the tag themselves.
 func (s *Builder) Upgrade(ctx context.Context, req *builderv0.UpgradeRequest) (*builderv0.UpgradeResponse, error) {
 	defer s.Wool.Catch()
 	ctx = s.Wool.Inject(ctx)
-	res, err := upgrade.Docker(ctx, image.FullName(), upgrade.Options{
+	img, err := s.redisImage()
+	if err != nil {
+		return s.Builder.UpgradeError(err)
+	}
+	// Core only proposes Docker upgrades; the agent owns the lockfile.
+	res, err := upgrade.Docker(ctx, img.FullName(), upgrade.Options{
 		IncludeMajor: req.IncludeMajor,
-		DryRun:       req.DryRun,
+		DryRun:       true,
 	})
 	if err != nil {
 		return s.Builder.UpgradeError(err)
 	}
-	return s.Builder.UpgradeResponse(res.Changes, res.LockfileDiff)
+	if len(res.Changes) == 0 {
+		return s.Builder.UpgradeResponse(res.Changes, res.LockfileDiff)
+	}
+	candidate, err := s.upgradeTarget(ctx, img, res.Changes)
+	if err != nil {
+		return s.Builder.UpgradeError(err)
+	}
+	s.logPackageChanges(ctx, img, candidate)
+	if req.DryRun {
+		return s.Builder.UpgradeResponse(res.Changes, res.LockfileDiff)
+	}
+	diff, err := s.applyImageUpgrade(ctx, candidate)
+	if err != nil {
+		return s.Builder.UpgradeError(err)
+	}
+	return s.Builder.UpgradeResponse(res.Changes, diff)
+}
+
+// logPackageChanges logs what moving to candidate changes in the image's
+// packages. The comparison only informs: it never blocks an upgrade.
+func (s *Builder) logPackageChanges(ctx context.Context, current, candidate *resources.DockerImage) {
+	if candidate == nil {
+		return
+	}
+	diff, err := s.sbomDiff(ctx, current, candidate)
+	if err != nil {
+		s.Wool.Warn("cannot compare the packages of the upgrade", wool.Field("candidate", candidate.FullName()), wool.ErrField(err))
+		return
+	}
+	s.Wool.Info("upgrade changes packages", wool.Field("candidate", diff.Candidate), wool.Field("changes", diff.summary()))
 }
 
 func (s *Builder) Deploy(ctx context.Context, req *builderv0.DeploymentRequest) (*builderv0.DeploymentResponse, error) {
 	defer s.Wool.Catch()
-	s.Base.SetDockerImage(image)
+	settings, err := s.Deployment.forEnvironment(req.GetEnvironment().GetName())
+	if err != nil {
+		return s.Builder.DeployError(err)
+	}
+	img, err := s.deployedImage(settings)
+	if err != nil {
+		return s.Builder.DeployError(err)
+	}
+	s.Base.SetDockerImage(img)
 
-	parameters := &deploymentTemplateParameters{}
+	parameters := newDeploymentTemplateParameters()
 	var restrictedConfiguration *v0.Configuration
 	response, err := s.Builder.DeployKustomize(ctx, req, services.KustomizeDeployment{
 		EnvironmentVariables: s.EnvironmentVariables,
@@ -102,11 +319,32 @@ func (s *Builder) Deploy(ctx context.Context, req *builderv0.DeploymentRequest)
 			return deployment.ExportConfiguration(ctx, configuration)
 		},
 	})
-	if err != nil ||
-		response.GetState().GetState() != builderv0.DeploymentStatus_SUCCESS ||
-		restrictedConfiguration == nil {
+	if err != nil || response.GetState().GetState() != builderv0.DeploymentStatus_SUCCESS {
 		return response, err
 	}
+	if parameters.Output == DeploymentOutputHelm {
+		chart := filepath.Join(req.GetDeployment().GetKubernetes().GetDestination(), "helm", s.Identity.Name)
+		if err = s.writeHelmChart(chart, img, parameters); err != nil {
+			return s.Builder.DeployErrorf(err, "cannot write helm chart")
+		}
+		s.Wool.Debug("wrote helm chart", wool.Field("chart", chart))
+	}
+	if parameters.Output == DeploymentOutputCompose {
+		compose := filepath.Join(req.GetDeployment().GetKubernetes().GetDestination(), "compose", s.Identity.Name)
+		if err = s.ExportCompose(ctx, ComposeExport{
+			Destination:   compose,
+			Environment:   req.GetEnvironment().GetName(),
+			Image:         img,
+			Restricted:    restrictedConfiguration != nil,
+			Authenticated: parameters.PasswordReference != nil,
+		}); err != nil {
+			return s.Builder.DeployErrorf(err, "cannot write compose file")
+		}
+		s.Wool.Debug("wrote compose file", wool.Field("destination", compose))
+	}
+	if restrictedConfiguration == nil {
+		return response, nil
+	}
 	response.Configuration = restrictedConfiguration
 	return response, nil
 }
@@ -117,10 +355,57 @@ func (s *Builder) prepareDeployment(
 	parameters *deploymentTemplateParameters,
 ) (*v0.Configuration, error) {
 	req := deployment.Request
+	settings, err := s.Deployment.forEnvironment(req.GetEnvironment().GetName())
+	if err != nil {
+		return nil, err
+	}
+	if err = settings.validate(s.MaxMemory); err != nil {
+		return nil, err
+	}
+	parameters.DeploymentSettings = *settings
+	if err = s.validateRedisConfig(); err != nil {
+		return nil, err
+	}
+	parameters.Directives = s.redisDirectives()
+	pinned, err := s.pinSidecarImages(ctx, settings)
+	if err != nil {
+		return nil, err
+	}
+	if exporter, ok := pinned[exporterSidecar]; ok {
+		parameters.ExporterImage = imageReference(exporter)
+	}
+	if client, ok := pinned[backupClientSidecar]; ok {
+		parameters.BackupClientImage = imageReference(client)
+	}
+	if kubectl, ok := pinned[kubectlSidecar]; ok {
+		parameters.KubectlImage = imageReference(kubectl)
+	}
+	scripts, err := readScripts(s.Location, s.Scripts)
+	if err != nil {
+		return nil, err
+	}
+	parameters.Scripts = scriptFiles(scripts)
+	if err = validateStreams(s.Streams); err != nil {
+		return nil, err
+	}
+	parameters.StreamProvisioning = streamProvisioningLines(s.Streams)
+
 	instance, err := resources.FindNetworkInstanceInNetworkMappings(ctx, req.GetNetworkMappings(), s.TcpEndpoint, resources.NewContainerNetworkAccess())
 	if err != nil {
 		return nil, err
 	}
+	topologyValues, err := topologyConfigurationValues(instance, settings)
+	if err != nil {
+		return nil, err
+	}
+	if settings.Replicated() {
+		// Writes must reach the primary, whichever pod it is.
+		if instance, err = primaryInstance(instance); err != nil {
+			return nil, err
+		}
+	}
+	values := append(topologyValues, scriptConfigurationValues(scripts)...)
+	values = append(values, s.Notifications.configurationValues()...)
 	if services.IsRestrictedOutputProfile(deployment.Profile) {
 		passwordKey := resources.ServiceSecretConfigurationKeyFromUnique(s.Unique(), "redis", "REDIS_PASSWORD")
 		passwordReference := deployment.Kubernetes.GetSecretReferences()[passwordKey]
@@ -134,13 +419,13 @@ func (s *Builder) prepareDeployment(
 			}
 			parameters.PasswordReference = passwordReference
 		}
-		return s.restrictedConnectionConfiguration(instance), nil
+		return withConfigurationValues(s.restrictedConnectionConfiguration(instance), values...), nil
 	}
 	configuration, err := s.CreateConnectionConfiguration(ctx, req.GetConfiguration(), instance)
 	if err != nil {
 		return nil, err
 	}
-	return configuration, nil
+	return withConfigurationValues(configuration, values...), nil
 }
 
 func (s *Builder) Create(ctx context.Context, req *builderv0.CreateRequest) (*builderv0.CreateResponse, error) {
@@ -0,0 +1,225 @@
+package main
+
+// compose.go — a docker-compose definition for running this redis without
+// codefly.
+//
+// The service mirrors what Runtime starts with Docker: the same pinned image,
+// the same redis-server directives and the password kept out of the command
+// line. The password is read from the service's redis.secret.env and written
+// to an env file next to the compose file, never into the compose file itself.
+//
+// With `deployment.output: compose`, Deploy writes the definition next to the
+// kustomize bundle, for the deployed image. A restricted-portable deployment
+// never carries the password: the compose file then expects the operator to
+// provide the env file.
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+
+	"github.com/codefly-dev/core/resources"
+	"gopkg.in/yaml.v3"
+)
+
+const (
+	composeFile    = "docker-compose.yaml"
+	composeEnvFile = "redis.env"
+)
+
+// ComposeExport locates the compose definition written by ExportCompose.
+type ComposeExport struct {
+	// Destination is the directory the compose and env files are written to.
+	Destination string
+	// Environment selects the configurations/<environment>/redis.secret.env
+	// the password is read from. Defaults to local.
+	Environment string
+	// Port is the host port redis is published on. Defaults to 6379.
+	Port int
+	// Image is the redis image to run. Defaults to the service's image.
+	Image *resources.DockerImage
+	// Restricted keeps the password out of the export: no env file is read
+	// or written, and Authenticated tells whether redis requires one.
+	Restricted    bool
+	Authenticated bool
+}
+
+type composeProject struct {
+	Services map[string]composeService `yaml:"services"`
+	Volumes  map[string]map[string]any `yaml:"volumes"`
+}
+
+type composeService struct {
+	Image       string             `yaml:"image"`
+	Command     []string           `yaml:"command,omitempty"`
+	EnvFile     []string           `yaml:"env_file,omitempty"`
+	Ports       []composePort      `yaml:"ports"`
+	Volumes     []string           `yaml:"volumes"`
+	Healthcheck composeHealthcheck `yaml:"healthcheck"`
+	Restart     string             `yaml:"restart"`
+}
+
+// composePort is a HOST:CONTAINER mapping. It is always quoted: YAML 1.1
+// parsers read unquoted xx:yy as a base-60 number.
+type composePort string
+
+func (p composePort) MarshalYAML() (any, error) {
+	return &yaml.Node{Kind: yaml.ScalarNode, Style: yaml.DoubleQuotedStyle, Value: string(p)}, nil
+}
+
+type composeHealthcheck struct {
+	Test     []string `yaml:"test"`
+	Interval string   `yaml:"interval"`
+	Timeout  string   `yaml:"timeout"`
+	Retries  int      `yaml:"retries"`
+}
+
+// ExportCompose writes a docker-compose service for this redis, plus the env
+// file holding its password when one is configured.
+func (s *Builder) ExportCompose(_ context.Context, export ComposeExport) error {
+	defer s.Wool.Catch()
+
+	if export.Destination == "" {
+		return s.Wool.NewError("compose export needs a destination")
+	}
+	if export.Environment == "" {
+		export.Environment = "local"
+	}
+	if export.Port == 0 {
+		export.Port = 6379
+	}
+	if err := s.validateRedisConfig(); err != nil {
+		return err
+	}
+	img, err := s.redisImage()
+	if export.Image != nil {
+		img, err = export.Image, nil
+	}
+	if err != nil {
+		return err
+	}
+	authenticated := export.Authenticated
+	var password string
+	if !export.Restricted {
+		if password, err = s.composePassword(export.Environment); err != nil {
+			return err
+		}
+		authenticated = password != ""
+	}
+	project := composeDefinition(s.Identity.Name, img, export.Port, s.redisDirectives(), authenticated)
+	var content bytes.Buffer
+	encoder := yaml.NewEncoder(&content)
+	encoder.SetIndent(2)
+	if err = encoder.Encode(project); err != nil {
+		return s.Wool.Wrapf(err, "cannot encode compose file")
+	}
+	if err = os.MkdirAll(export.Destination, 0o755); err != nil {
+		return err
+	}
+	envPath := filepath.Join(export.Destination, composeEnvFile)
+	switch {
+	case export.Restricted:
+		// The operator provides the env file.
+	case password != "":
+		// Single quotes keep compose from interpolating the password.
+		// REDISCLI_AUTH lets the healthcheck's redis-cli authenticate.
+		env := fmt.Sprintf("REDIS_PASSWORD='%s'\nREDISCLI_AUTH='%s'\n", password, password)
+		if err = os.WriteFile(envPath, []byte(env), 0o600); err != nil {
+			return err
+		}
+	default:
+		if err = os.Remove(envPath); err != nil && !errors.Is(err, fs.ErrNotExist) {
+			return err
+		}
+	}
+	return os.WriteFile(filepath.Join(export.Destination, composeFile), content.Bytes(), 0o644)
+}
+
+// composePassword reads REDIS_PASSWORD from the environment's
+// redis.secret.env, falling back to the password in the settings like the
+// runtime does.
+func (s *Builder) composePassword(environment string) (string, error) {
+	path := filepath.Join(s.Location, "configurations", environment, "redis.secret.env")
+	password, err := readEnvFileValue(path, "REDIS_PASSWORD")
+	if err != nil && !errors.Is(err, fs.ErrNotExist) {
+		return "", fmt.Errorf("cannot read %s: %w", path, err)
+	}
+	if password == "" {
+		password = s.Password
+	}
+	if s.RequirePass && password == "" {
+		return "", fmt.Errorf("redis require-pass is enabled but %s sets no REDIS_PASSWORD", path)
+	}
+	if strings.ContainsAny(password, "'\n") {
+		return "", fmt.Errorf("the redis password cannot be written to an env file: it contains a quote or a newline")
+	}
+	return password, nil
+}
+
+// composeDefinition is the compose project running img as name.
+func composeDefinition(name string, img *resources.DockerImage, port int, directives []redisDirective, authenticated bool) *composeProject {
+	service := composeService{
+		Image:   imageReference(img),
+		Ports:   []composePort{composePort(strconv.Itoa(port) + ":6379")},
+		Volumes: []string{name + "-data:/data"},
+		Healthcheck: composeHealthcheck{
+			Test:     []string{"CMD-SHELL", "redis-cli ping | grep -q PONG"},
+			Interval: "5s",
+			Timeout:  "3s",
+			Retries:  10,
+		},
+		Restart: "unless-stopped",
+	}
+	var command []string
+	if authenticated {
+		service.EnvFile = []string{composeEnvFile}
+		command = redisDockerCommand(redisServerFlags(directives)...)
+	} else if flags := redisServerFlags(directives); len(flags) > 0 {
+		command = append([]string{"redis-server"}, flags...)
+	}
+	for _, arg := range command {
+		// Compose interpolates $VAR itself; the shell in the container must
+		// see the variable references.
+		service.Command = append(service.Command, strings.ReplaceAll(arg, "$", "$$"))
+	}
+	return &composeProject{
+		Services: map[string]composeService{name: service},
+		Volumes:  map[string]map[string]any{name + "-data": {}},
+	}
+}
+
+// readEnvFileValue returns the value of key in a KEY=VALUE env file.
+func readEnvFileValue(path, key string) (string, error) {
+	file, err := os.Open(path)
+	if err != nil {
+		return "", err
+	}
+	defer file.Close()
+	scanner := bufio.NewScanner(file)
+	for scanner.Scan() {
+		line := strings.TrimSpace(scanner.Text())
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+		name, value, ok := strings.Cut(strings.TrimPrefix(line, "export "), "=")
+		if !ok || strings.TrimSpace(name) != key {
+			continue
+		}
+		value = strings.TrimSpace(value)
+		if len(value) >= 2 && value[0] == '\'' && value[len(value)-1] == '\'' {
+			return value[1 : len(value)-1], nil
+		}
+		if unquoted, err := strconv.Unquote(value); err == nil {
+			value = unquoted
+		}
+		return value, nil
+	}
+	return "", scanner.Err()
+}
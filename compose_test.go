@@ -0,0 +1,163 @@
+package main
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+	"testing"
+
+	builderv0 "github.com/codefly-dev/core/generated/go/codefly/services/builder/v0"
+	"github.com/codefly-dev/core/resources"
+	"gopkg.in/yaml.v3"
+)
+
+func TestExportComposeMatchesDockerRuntime(t *testing.T) {
+	builder, _ := newDeploymentTestBuilder(t)
+	builder.Location = t.TempDir()
+	builder.RequirePass = true
+	builder.MaxMemory = "200mb"
+	secrets := filepath.Join(builder.Location, "configurations", "local")
+	if err := os.MkdirAll(secrets, 0o755); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(filepath.Join(secrets, "redis.secret.env"), []byte("# local\nREDIS_PASSWORD=pa$$word\n"), 0o600); err != nil {
+		t.Fatal(err)
+	}
+	destination := t.TempDir()
+
+	if err := builder.ExportCompose(context.Background(), ComposeExport{Destination: destination, Port: 6380}); err != nil {
+		t.Fatal(err)
+	}
+
+	content := readDeploymentFile(t, destination, composeFile)
+	if !strings.Contains(content, `- "6380:6379"`) {
+		t.Errorf("compose file does not quote the port mapping:\n%s", content)
+	}
+	if strings.Contains(content, "pa$$word") {
+		t.Errorf("compose file contains the password:\n%s", content)
+	}
+	var project composeProject
+	if err := yaml.Unmarshal([]byte(content), &project); err != nil {
+		t.Fatal(err)
+	}
+	service, ok := project.Services["redis"]
+	if !ok {
+		t.Fatalf("compose file has no redis service:\n%s", content)
+	}
+	if service.Image != "redis@"+image.Digest {
+		t.Errorf("image = %q", service.Image)
+	}
+	if !slices.Equal(service.Ports, []composePort{"6380:6379"}) || !slices.Equal(service.Volumes, []string{"redis-data:/data"}) {
+		t.Errorf("ports = %v, volumes = %v", service.Ports, service.Volumes)
+	}
+	if _, ok = project.Volumes["redis-data"]; !ok {
+		t.Errorf("compose file does not declare the redis-data volume:\n%s", content)
+	}
+	want := []string{"sh", "-c", `exec redis-server --requirepass "$$REDIS_PASSWORD" "$$@"`, "redis-server", "--maxmemory", "200mb"}
+	if !slices.Equal(service.Command, want) {
+		t.Errorf("command = %q, want %q", service.Command, want)
+	}
+	if !slices.Equal(service.EnvFile, []string{composeEnvFile}) || !strings.Contains(strings.Join(service.Healthcheck.Test, " "), "redis-cli ping") {
+		t.Errorf("env_file = %v, healthcheck = %v", service.EnvFile, service.Healthcheck.Test)
+	}
+
+	env := readDeploymentFile(t, destination, composeEnvFile)
+	if env != "REDIS_PASSWORD='pa$$word'\nREDISCLI_AUTH='pa$$word'\n" {
+		t.Errorf("env file = %q", env)
+	}
+	info, err := os.Stat(filepath.Join(destination, composeEnvFile))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if info.Mode().Perm() != 0o600 {
+		t.Errorf("env file mode = %v, want 0600", info.Mode().Perm())
+	}
+}
+
+func TestExportComposeWithoutPassword(t *testing.T) {
+	builder, _ := newDeploymentTestBuilder(t)
+	builder.Location = t.TempDir()
+	destination := t.TempDir()
+	if err := os.WriteFile(filepath.Join(destination, composeEnvFile), []byte("REDIS_PASSWORD='stale'\n"), 0o600); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := builder.ExportCompose(context.Background(), ComposeExport{Destination: destination}); err != nil {
+		t.Fatal(err)
+	}
+	var project composeProject
+	if err := yaml.Unmarshal([]byte(readDeploymentFile(t, destination, composeFile)), &project); err != nil {
+		t.Fatal(err)
+	}
+	service := project.Services["redis"]
+	if len(service.Command) != 0 || len(service.EnvFile) != 0 || !slices.Equal(service.Ports, []composePort{"6379:6379"}) {
+		t.Errorf("service = %+v", service)
+	}
+	if _, err := os.Stat(filepath.Join(destination, composeEnvFile)); !os.IsNotExist(err) {
+		t.Errorf("stale env file kept: %v", err)
+	}
+
+	builder.RequirePass = true
+	if err := builder.ExportCompose(context.Background(), ComposeExport{Destination: destination}); err == nil {
+		t.Fatal("require-pass export without a password succeeded")
+	}
+}
+
+func TestRestrictedDeploymentWritesComposeWithoutPassword(t *testing.T) {
+	useSuccessfulKubectl(t)
+	builder, networkMappings := newDeploymentTestBuilder(t)
+	builder.Location = t.TempDir()
+	builder.RequirePass = true
+	builder.Deployment = DeploymentSettings{Output: DeploymentOutputCompose}
+	passwordKey := resources.ServiceSecretConfigurationKeyFromUnique(builder.Unique(), "redis", "REDIS_PASSWORD")
+	destination := t.TempDir()
+
+	response, err := builder.Deploy(context.Background(), restrictedDeploymentRequest(
+		destination,
+		networkMappings,
+		map[string]*builderv0.KubernetesSecretKeyReference{
+			passwordKey: {Name: "redis-credentials", Key: "password"},
+		},
+		false,
+	))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if response.GetState().GetState() != builderv0.DeploymentStatus_SUCCESS {
+		t.Fatalf("deployment failed: %s", response.GetState().GetMessage())
+	}
+
+	compose := filepath.Join(destination, "compose", "redis")
+	var project composeProject
+	if err = yaml.Unmarshal([]byte(readDeploymentFile(t, compose, composeFile)), &project); err != nil {
+		t.Fatal(err)
+	}
+	service := project.Services["redis"]
+	if service.Image != "redis@"+image.Digest || !slices.Equal(service.EnvFile, []string{composeEnvFile}) {
+		t.Errorf("service = %+v, want the deployed image and the operator's env file", service)
+	}
+	if _, err = os.Stat(filepath.Join(compose, composeEnvFile)); !os.IsNotExist(err) {
+		t.Errorf("restricted deployment wrote the env file: %v", err)
+	}
+}
+
+func TestExportComposeRestricted(t *testing.T) {
+	builder, _ := newDeploymentTestBuilder(t)
+	builder.Location = t.TempDir()
+	builder.Password = "secret"
+	destination := t.TempDir()
+
+	export := ComposeExport{Destination: destination, Restricted: true, Authenticated: true}
+	if err := builder.ExportCompose(context.Background(), export); err != nil {
+		t.Fatal(err)
+	}
+	content := readDeploymentFile(t, destination, composeFile)
+	if strings.Contains(content, "secret") || !strings.Contains(content, "$$REDIS_PASSWORD") {
+		t.Errorf("restricted compose file does not reference the operator's password:\n%s", content)
+	}
+	if _, err := os.Stat(filepath.Join(destination, composeEnvFile)); !os.IsNotExist(err) {
+		t.Errorf("restricted export wrote the env file: %v", err)
+	}
+}
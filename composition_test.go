@@ -77,6 +77,17 @@ func TestRedisDockerCommandKeepsPasswordOutOfArgv(t *testing.T) {
 	}
 }
 
+func TestRedisDockerCommandPassesServerFlags(t *testing.T) {
+	settings := &Settings{MaxMemory: "200mb"}
+	args := redisDockerCommand(redisServerFlags(settings.redisDirectives())...)
+	if got := strings.Join(args[len(args)-2:], " "); got != "--maxmemory 200mb" {
+		t.Fatalf("Docker command flags = %q, want --maxmemory 200mb", got)
+	}
+	if !strings.Contains(args[2], `"$@"`) {
+		t.Fatalf("Docker command script does not forward flags: %q", args[2])
+	}
+}
+
 func TestResolveServingTCPEndpointReadWriteReplicas(t *testing.T) {
 	endpoints := []*basev0.Endpoint{
 		{Name: "read", Api: "tcp"},
@@ -126,6 +137,7 @@ func TestSettings_YAMLRoundTrip(t *testing.T) {
 	src := []byte(`
 password: "hunter2"
 require-pass: true
+maxmemory: 200mb
 `)
 	var s Settings
 	if err := yaml.Unmarshal(src, &s); err != nil {
@@ -137,4 +149,43 @@ require-pass: true
 	if !s.RequirePass {
 		t.Error("RequirePass not populated")
 	}
+	if s.MaxMemory != "200mb" {
+		t.Errorf("MaxMemory: got %q", s.MaxMemory)
+	}
+}
+
+func TestSettingsRedisDirectives(t *testing.T) {
+	settings := &Settings{
+		MaxMemory:       "200mb",
+		MaxMemoryPolicy: "allkeys-lru",
+		Persistence:     PersistenceSettings{Save: "3600  1 300 100", AppendOnly: true, AppendFsync: "everysec"},
+	}
+	if err := settings.validateRedisConfig(); err != nil {
+		t.Fatal(err)
+	}
+	got := strings.Join(redisConfigLines(settings.redisDirectives()), "\n")
+	want := strings.Join([]string{
+		`maxmemory "200mb"`,
+		`maxmemory-policy "allkeys-lru"`,
+		`save "3600 1 300 100"`,
+		`appendonly "yes"`,
+		`appendfsync "everysec"`,
+	}, "\n")
+	if got != want {
+		t.Fatalf("redis.conf lines:\n%s\nwant:\n%s", got, want)
+	}
+	if lines := redisConfigLines((&Settings{Persistence: PersistenceSettings{Save: "off"}}).redisDirectives()); len(lines) != 1 || lines[0] != `save ""` {
+		t.Fatalf("disabled snapshots = %q, want save \"\"", lines)
+	}
+
+	for _, invalid := range []Settings{
+		{MaxMemoryPolicy: "lru"},
+		{Persistence: PersistenceSettings{Save: "3600"}},
+		{Persistence: PersistenceSettings{Save: "hourly 1"}},
+		{Persistence: PersistenceSettings{AppendFsync: "sometimes"}},
+	} {
+		if err := invalid.validateRedisConfig(); err == nil {
+			t.Errorf("validateRedisConfig accepted %+v", invalid)
+		}
+	}
 }
@@ -0,0 +1,412 @@
+package main
+
+// control.go — operations on the local redis while it runs.
+//
+// Runtime.Start serves them over HTTP on control.sock under the runtime root;
+// only the user running the agent can connect. Run with one of these
+// commands, the agent binary is their command line, from the service
+// directory or with --service:
+//
+//	redis keys 'session:*' --type hash --values
+//	redis flush 0 2
+//	redis reset
+//	redis monitor on --client '127.0.0.1:*' --show-values
+//	redis monitor off
+//
+// The operations act on the running redis and never restart it.
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"io/fs"
+	"net"
+	"net/http"
+	"net/url"
+	"os"
+	"path/filepath"
+	"slices"
+	"strconv"
+	"strings"
+	"sync"
+	"text/tabwriter"
+	"time"
+)
+
+const controlSocketFile = "control.sock"
+
+const controlUsage = `usage: redis [--service <dir>] <command>
+
+commands:
+  keys [<pattern>] [--type <type>] [--db <n>] [--limit <n>] [--values] [--elements <n>]
+                   list the matching keys with their type, TTL and memory
+  flush [<db>]...  delete the keys of the databases, or of all of them
+  reset            flush, restore the configuration, replay the seed
+  monitor on [--client <glob>]... [--show-values] [--file]
+                   stream the commands redis runs to the agent log
+  monitor off      stop streaming them`
+
+// controlServer serves the operations of one runtime.
+type controlServer struct {
+	runtime *Runtime
+	server  *http.Server
+	// mu serializes the operations.
+	mu sync.Mutex
+}
+
+func controlSocket(location string) (string, error) {
+	root, err := redisRuntimeRoot(location)
+	if err != nil {
+		return "", err
+	}
+	return filepath.Join(root, controlSocketFile), nil
+}
+
+// startControl serves the operations until stopControl.
+func (s *Runtime) startControl() error {
+	if s.control != nil {
+		return nil
+	}
+	socket, err := controlSocket(s.Location)
+	if err != nil {
+		return err
+	}
+	if err = os.MkdirAll(filepath.Dir(socket), 0o700); err != nil {
+		return err
+	}
+	// A previous agent may have left its socket behind.
+	if err = os.Remove(socket); err != nil && !errors.Is(err, fs.ErrNotExist) {
+		return err
+	}
+	listener, err := net.Listen("unix", socket)
+	if err != nil {
+		return fmt.Errorf("cannot serve the redis operations on %s: %w", socket, err)
+	}
+	if err = os.Chmod(socket, 0o600); err != nil {
+		_ = listener.Close()
+		return err
+	}
+	control := &controlServer{runtime: s}
+	mux := http.NewServeMux()
+	mux.HandleFunc("GET /keys", control.inspectKeyspace)
+	mux.HandleFunc("POST /flush", control.flush)
+	mux.HandleFunc("POST /reset", control.reset)
+	mux.HandleFunc("PUT /monitor", control.startMonitor)
+	mux.HandleFunc("DELETE /monitor", control.stopMonitor)
+	control.server = &http.Server{Handler: mux, ReadHeaderTimeout: 5 * time.Second}
+	go func() { _ = control.server.Serve(listener) }()
+	s.control = control
+	return nil
+}
+
+// stopControl waits for the operations in flight and removes the socket.
+func (s *Runtime) stopControl(ctx context.Context) error {
+	if s.control == nil {
+		return nil
+	}
+	err := s.control.server.Shutdown(ctx)
+	s.control = nil
+	return err
+}
+
+func (c *controlServer) inspectKeyspace(w http.ResponseWriter, r *http.Request) {
+	query, err := keyspaceQueryFromURL(r.URL.Query())
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	keys, err := c.runtime.InspectKeyspace(r.Context(), query)
+	if keys == nil {
+		keys = []KeyInfo{}
+	}
+	c.reply(w, keys, err)
+}
+
+func (c *controlServer) flush(w http.ResponseWriter, r *http.Request) {
+	dbs, err := parseDatabases(r.URL.Query()["db"])
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.reply(w, nil, c.runtime.Flush(r.Context(), dbs...))
+}
+
+func (c *controlServer) reset(w http.ResponseWriter, r *http.Request) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.reply(w, nil, c.runtime.Reset(r.Context()))
+}
+
+func (c *controlServer) startMonitor(w http.ResponseWriter, r *http.Request) {
+	var settings RuntimeMonitorSettings
+	if err := json.NewDecoder(r.Body).Decode(&settings); err != nil {
+		http.Error(w, fmt.Sprintf("invalid monitor settings: %v", err), http.StatusBadRequest)
+		return
+	}
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.reply(w, nil, c.runtime.StartMonitor(r.Context(), settings))
+}
+
+func (c *controlServer) stopMonitor(w http.ResponseWriter, _ *http.Request) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.reply(w, nil, c.runtime.StopMonitor())
+}
+
+// reply writes result as JSON, or err as the error message.
+func (c *controlServer) reply(w http.ResponseWriter, result any, err error) {
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	if result == nil {
+		w.WriteHeader(http.StatusNoContent)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	_ = json.NewEncoder(w).Encode(result)
+}
+
+func (q KeyspaceQuery) urlValues() url.Values {
+	values := url.Values{}
+	values.Set("pattern", q.Pattern)
+	values.Set("type", q.Type)
+	values.Set("db", strconv.Itoa(q.DB))
+	values.Set("limit", strconv.Itoa(q.Limit))
+	values.Set("values", strconv.FormatBool(q.Values))
+	values.Set("elements", strconv.Itoa(q.Elements))
+	return values
+}
+
+func keyspaceQueryFromURL(values url.Values) (KeyspaceQuery, error) {
+	query := KeyspaceQuery{Pattern: values.Get("pattern"), Type: values.Get("type")}
+	var err error
+	for name, field := range map[string]*int{"db": &query.DB, "limit": &query.Limit, "elements": &query.Elements} {
+		if value := values.Get(name); value != "" {
+			if *field, err = strconv.Atoi(value); err != nil || *field < 0 {
+				return query, fmt.Errorf("invalid %s %q", name, value)
+			}
+		}
+	}
+	if value := values.Get("values"); value != "" {
+		if query.Values, err = strconv.ParseBool(value); err != nil {
+			return query, fmt.Errorf("invalid values %q", value)
+		}
+	}
+	return query, nil
+}
+
+func parseDatabases(values []string) ([]int, error) {
+	dbs := make([]int, 0, len(values))
+	for _, value := range values {
+		db, err := strconv.Atoi(value)
+		if err != nil || db < 0 {
+			return nil, fmt.Errorf("invalid database %q", value)
+		}
+		dbs = append(dbs, db)
+	}
+	return dbs, nil
+}
+
+// controlClient calls the operations of the runtime of one service.
+type controlClient struct {
+	location string
+	client   *http.Client
+}
+
+func newControlClient(location string) (*controlClient, error) {
+	socket, err := controlSocket(location)
+	if err != nil {
+		return nil, err
+	}
+	var dialer net.Dialer
+	return &controlClient{
+		location: location,
+		client: &http.Client{Transport: &http.Transport{
+			DialContext: func(ctx context.Context, _, _ string) (net.Conn, error) {
+				return dialer.DialContext(ctx, "unix", socket)
+			},
+		}},
+	}, nil
+}
+
+// call sends one operation and decodes its JSON result into result.
+func (c *controlClient) call(ctx context.Context, method, path string, query url.Values, body, result any) error {
+	var content io.Reader
+	if body != nil {
+		encoded, err := json.Marshal(body)
+		if err != nil {
+			return err
+		}
+		content = strings.NewReader(string(encoded))
+	}
+	endpoint := (&url.URL{Scheme: "http", Host: "redis", Path: path, RawQuery: query.Encode()}).String()
+	request, err := http.NewRequestWithContext(ctx, method, endpoint, content)
+	if err != nil {
+		return err
+	}
+	response, err := c.client.Do(request)
+	if err != nil {
+		return fmt.Errorf("no redis running for %s: start the service first", c.location)
+	}
+	defer response.Body.Close()
+	if response.StatusCode >= http.StatusBadRequest {
+		message, _ := io.ReadAll(io.LimitReader(response.Body, 64<<10))
+		return errors.New(strings.TrimSpace(string(message)))
+	}
+	if result == nil {
+		return nil
+	}
+	return json.NewDecoder(response.Body).Decode(result)
+}
+
+// runControlCommand runs the command line given to the agent binary.
+// controlCommands are the commands runControlCommand runs.
+var controlCommands = []string{"keys", "flush", "reset", "monitor"}
+
+// isControlCommand reports whether args, the agent binary's arguments, name
+// a control command. Any other arguments are left to the agent plugin.
+func isControlCommand(args []string) bool {
+	flags := flag.NewFlagSet("redis", flag.ContinueOnError)
+	flags.SetOutput(io.Discard)
+	flags.String("service", ".", "")
+	if err := flags.Parse(args); err != nil || flags.NArg() == 0 {
+		return false
+	}
+	return slices.Contains(controlCommands, flags.Arg(0))
+}
+
+func runControlCommand(ctx context.Context, args []string, out io.Writer) error {
+	flags := flag.NewFlagSet("redis", flag.ContinueOnError)
+	flags.SetOutput(io.Discard)
+	service := flags.String("service", ".", "")
+	if err := flags.Parse(args); err != nil || flags.NArg() == 0 {
+		return errors.New(controlUsage)
+	}
+	location, err := filepath.Abs(*service)
+	if err != nil {
+		return err
+	}
+	client, err := newControlClient(location)
+	if err != nil {
+		return err
+	}
+	args = flags.Args()
+	switch args[0] {
+	case "keys":
+		return client.keys(ctx, args[1:], out)
+	case "flush":
+		return client.flush(ctx, args[1:], out)
+	case "reset":
+		if len(args) > 1 {
+			return errors.New(controlUsage)
+		}
+		if err = client.call(ctx, http.MethodPost, "/reset", nil, nil, nil); err != nil {
+			return err
+		}
+		_, err = fmt.Fprintln(out, "redis reset")
+		return err
+	case "monitor":
+		return client.monitor(ctx, args[1:], out)
+	}
+	return fmt.Errorf("unknown command %q\n%s", args[0], controlUsage)
+}
+
+func (c *controlClient) keys(ctx context.Context, args []string, out io.Writer) error {
+	var query KeyspaceQuery
+	flags := flag.NewFlagSet("keys", flag.ContinueOnError)
+	flags.SetOutput(io.Discard)
+	flags.StringVar(&query.Type, "type", "", "")
+	flags.IntVar(&query.DB, "db", 0, "")
+	flags.IntVar(&query.Limit, "limit", 0, "")
+	flags.BoolVar(&query.Values, "values", false, "")
+	flags.IntVar(&query.Elements, "elements", 0, "")
+	// The pattern may come before or after the flags.
+	if err := flags.Parse(args); err != nil {
+		return errors.New(controlUsage)
+	}
+	if flags.NArg() > 0 {
+		query.Pattern = flags.Arg(0)
+		if err := flags.Parse(flags.Args()[1:]); err != nil || flags.NArg() > 0 {
+			return errors.New(controlUsage)
+		}
+	}
+	var keys []KeyInfo
+	if err := c.call(ctx, http.MethodGet, "/keys", query.urlValues(), nil, &keys); err != nil {
+		return err
+	}
+	table := tabwriter.NewWriter(out, 0, 4, 2, ' ', 0)
+	header := "KEY\tTYPE\tTTL\tMEMORY"
+	if query.Values {
+		header += "\tVALUE"
+	}
+	fmt.Fprintln(table, header)
+	for _, key := range keys {
+		ttl := "-"
+		if key.TTL >= 0 {
+			ttl = key.TTL.Round(time.Millisecond).String()
+		}
+		line := fmt.Sprintf("%s\t%s\t%s\t%d", key.Key, key.Type, ttl, key.MemoryBytes)
+		if query.Values {
+			line += "\t" + key.Value
+		}
+		fmt.Fprintln(table, line)
+	}
+	return table.Flush()
+}
+
+func (c *controlClient) flush(ctx context.Context, args []string, out io.Writer) error {
+	if _, err := parseDatabases(args); err != nil {
+		return err
+	}
+	if err := c.call(ctx, http.MethodPost, "/flush", url.Values{"db": args}, nil, nil); err != nil {
+		return err
+	}
+	flushed := "every database"
+	if len(args) > 0 {
+		flushed = "database " + strings.Join(args, ", ")
+	}
+	_, err := fmt.Fprintf(out, "redis flushed %s\n", flushed)
+	return err
+}
+
+func (c *controlClient) monitor(ctx context.Context, args []string, out io.Writer) error {
+	if len(args) == 0 {
+		return errors.New(controlUsage)
+	}
+	switch args[0] {
+	case "off":
+		if err := c.call(ctx, http.MethodDelete, "/monitor", nil, nil, nil); err != nil {
+			return err
+		}
+		_, err := fmt.Fprintln(out, "redis monitor off")
+		return err
+	case "on":
+		var settings RuntimeMonitorSettings
+		flags := flag.NewFlagSet("monitor on", flag.ContinueOnError)
+		flags.SetOutput(io.Discard)
+		flags.Func("client", "", func(pattern string) error {
+			settings.Clients = append(settings.Clients, pattern)
+			return nil
+		})
+		flags.BoolVar(&settings.ShowValues, "show-values", false, "")
+		flags.BoolVar(&settings.File, "file", false, "")
+		if err := flags.Parse(args[1:]); err != nil || flags.NArg() > 0 {
+			return errors.New(controlUsage)
+		}
+		if err := c.call(ctx, http.MethodPut, "/monitor", nil, settings, nil); err != nil {
+			return err
+		}
+		_, err := fmt.Fprintln(out, "redis monitor on")
+		return err
+	}
+	return errors.New(controlUsage)
+}
@@ -0,0 +1,213 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"testing"
+)
+
+// startTestControl serves the operations of runtime, in a cache directory of
+// the test, for the service at runtime.Location.
+func startTestControl(t *testing.T, runtime *Runtime) {
+	t.Helper()
+	t.Setenv("XDG_CACHE_HOME", t.TempDir())
+	runtime.Location = t.TempDir()
+	if err := runtime.startControl(); err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { _ = runtime.stopControl(context.Background()) })
+}
+
+func runTestControlCommand(t *testing.T, runtime *Runtime, args ...string) (string, error) {
+	t.Helper()
+	var out bytes.Buffer
+	err := runControlCommand(context.Background(), append([]string{"--service", runtime.Location}, args...), &out)
+	return out.String(), err
+}
+
+func TestIsControlCommand(t *testing.T) {
+	for _, args := range [][]string{
+		{"keys"},
+		{"flush", "0"},
+		{"--service", "services/redis", "reset"},
+		{"-service=services/redis", "monitor", "off"},
+	} {
+		if !isControlCommand(args) {
+			t.Errorf("isControlCommand(%q) = false", args)
+		}
+	}
+	for _, args := range [][]string{
+		nil,
+		{"--service", "services/redis"},
+		{"serve"},
+		{"-plugin-flag"},
+	} {
+		if isControlCommand(args) {
+			t.Errorf("isControlCommand(%q) = true, want the agent plugin to serve", args)
+		}
+	}
+}
+
+func TestControlTogglesMonitorWhileRedisRuns(t *testing.T) {
+	var monitors atomic.Int32
+	server := newFakeRedis(t, func(args []string) string {
+		switch strings.ToUpper(args[0]) {
+		case "MONITOR":
+			monitors.Add(1)
+			return "+OK\r\n"
+		case "PING":
+			return "+PONG\r\n"
+		}
+		return "-ERR unknown command\r\n"
+	})
+	runtime := NewRuntime()
+	runtime.redisAddress = server.address
+	startTestControl(t, runtime)
+
+	monitoring := func() *commandMonitor {
+		runtime.control.mu.Lock()
+		defer runtime.control.mu.Unlock()
+		return runtime.monitor
+	}
+	for round := 1; round <= 2; round++ {
+		out, err := runTestControlCommand(t, runtime, "monitor", "on", "--client", "127.0.0.1:*", "--show-values")
+		if err != nil {
+			t.Fatal(err)
+		}
+		if out != "redis monitor on\n" {
+			t.Errorf("monitor on printed %q", out)
+		}
+		monitor := monitoring()
+		if monitor == nil || !monitor.settings.ShowValues || len(monitor.settings.Clients) != 1 {
+			t.Fatalf("monitor after monitor on = %+v", monitor)
+		}
+		if int(monitors.Load()) != round {
+			t.Errorf("redis got %d MONITOR commands, want %d", monitors.Load(), round)
+		}
+
+		if _, err = runTestControlCommand(t, runtime, "monitor", "off"); err != nil {
+			t.Fatal(err)
+		}
+		if monitoring() != nil {
+			t.Fatal("monitor still set after monitor off")
+		}
+		// Redis itself keeps serving.
+		conn, err := runtime.dialRunning(context.Background())
+		if err != nil {
+			t.Fatal(err)
+		}
+		if reply, err := conn.Do("PING"); err != nil || reply != "PONG" {
+			t.Errorf("PING after monitor off = %v, %v", reply, err)
+		}
+		_ = conn.Close()
+	}
+
+	if _, err := runTestControlCommand(t, runtime, "monitor", "on", "--client", "[bad"); err == nil || !strings.Contains(err.Error(), "invalid monitor client pattern") {
+		t.Errorf("monitor on with a bad pattern = %v", err)
+	}
+	if _, err := runTestControlCommand(t, runtime, "monitor", "sideways"); err == nil || !strings.Contains(err.Error(), "usage:") {
+		t.Errorf("unknown monitor argument = %v, want the usage", err)
+	}
+}
+
+func TestControlCommandNeedsRunningRedis(t *testing.T) {
+	t.Setenv("XDG_CACHE_HOME", t.TempDir())
+	var out bytes.Buffer
+	err := runControlCommand(context.Background(), []string{"--service", t.TempDir(), "monitor", "off"}, &out)
+	if err == nil || !strings.Contains(err.Error(), "no redis running") {
+		t.Errorf("monitor off without a runtime = %v", err)
+	}
+}
+
+func TestControlListsKeys(t *testing.T) {
+	server := newFakeRedis(t, func(args []string) string {
+		switch strings.ToUpper(args[0]) {
+		case "SELECT":
+			if args[1] != "3" {
+				return "-ERR unexpected database\r\n"
+			}
+			return "+OK\r\n"
+		case "SCAN":
+			if args[3] != "session:*" || len(args) < 8 || args[7] != "string" {
+				return "-ERR unexpected scan " + strings.Join(args, " ") + "\r\n"
+			}
+			return "*2\r\n" + bulkReply("0") + "*2\r\n" + bulkReply("session:1") + bulkReply("session:2")
+		case "TYPE":
+			return "+string\r\n"
+		case "PTTL":
+			if args[1] == "session:1" {
+				return ":90000\r\n"
+			}
+			return ":-1\r\n"
+		case "MEMORY":
+			return ":56\r\n"
+		case "GET":
+			return bulkReply("token-" + args[1])
+		}
+		return "-ERR unknown command\r\n"
+	})
+	runtime := NewRuntime()
+	runtime.redisAddress = server.address
+	startTestControl(t, runtime)
+
+	out, err := runTestControlCommand(t, runtime, "keys", "--db", "3", "session:*", "--type", "string", "--values")
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := "KEY        TYPE    TTL    MEMORY  VALUE\n" +
+		"session:1  string  1m30s  56      token-session:1\n" +
+		"session:2  string  -      56      token-session:2\n"
+	if out != want {
+		t.Errorf("keys printed\n%s\nwant\n%s", out, want)
+	}
+	if _, err = runTestControlCommand(t, runtime, "keys", "--db", "-1"); err == nil || !strings.Contains(err.Error(), "invalid db") {
+		t.Errorf("keys --db -1 = %v", err)
+	}
+}
+
+func TestControlFlushesAndResets(t *testing.T) {
+	var mu sync.Mutex
+	var commands []string
+	server := newFakeRedis(t, func(args []string) string {
+		mu.Lock()
+		defer mu.Unlock()
+		commands = append(commands, strings.Join(args, " "))
+		return "+OK\r\n"
+	})
+	runtime := NewRuntime()
+	runtime.redisAddress = server.address
+	startTestControl(t, runtime)
+	ran := func() string {
+		mu.Lock()
+		defer mu.Unlock()
+		defer func() { commands = nil }()
+		return strings.Join(commands, ", ")
+	}
+
+	out, err := runTestControlCommand(t, runtime, "flush", "0", "2")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got := ran(); out != "redis flushed database 0, 2\n" || got != "SELECT 0, FLUSHDB, SELECT 2, FLUSHDB" {
+		t.Errorf("flush 0 2 printed %q and ran %s", out, got)
+	}
+	if _, err = runTestControlCommand(t, runtime, "flush"); err != nil {
+		t.Fatal(err)
+	}
+	if got := ran(); got != "FLUSHALL" {
+		t.Errorf("flush ran %s", got)
+	}
+	if _, err = runTestControlCommand(t, runtime, "flush", "two"); err == nil || !strings.Contains(err.Error(), `invalid database "two"`) {
+		t.Errorf("flush two = %v", err)
+	}
+
+	if out, err = runTestControlCommand(t, runtime, "reset"); err != nil {
+		t.Fatal(err)
+	}
+	if got := ran(); out != "redis reset\n" || !strings.HasPrefix(got, "FLUSHALL, CONFIG SET maxmemory 0 ") || !strings.HasSuffix(got, "CONFIG RESETSTAT") {
+		t.Errorf("reset printed %q and ran %s", out, got)
+	}
+}
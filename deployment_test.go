@@ -2,21 +2,26 @@ package main
 
 import (
 	"context"
+	"crypto/sha256"
+	"fmt"
 	"io/fs"
 	"os"
 	"path/filepath"
+	"reflect"
 	"strings"
 	"testing"
+	"text/template"
 
 	"github.com/codefly-dev/core/agents/services"
 	agenttesting "github.com/codefly-dev/core/agents/testing"
 	basev0 "github.com/codefly-dev/core/generated/go/codefly/base/v0"
 	builderv0 "github.com/codefly-dev/core/generated/go/codefly/services/builder/v0"
 	"github.com/codefly-dev/core/resources"
+	"gopkg.in/yaml.v3"
 )
 
 func TestDeploymentTemplates(t *testing.T) {
-	destination := agenttesting.AssertKustomizeTemplates(t, deploymentFS, &deploymentTemplateParameters{})
+	destination := agenttesting.AssertKustomizeTemplates(t, deploymentFS, newDeploymentTemplateParameters())
 
 	secret, err := os.ReadFile(filepath.Join(destination, "overlays", "test", "secret.yaml"))
 	if err != nil {
@@ -96,42 +101,72 @@ func TestRestrictedPortableDeploymentConfiguresAuthenticationAndReturnsConnectio
 	}
 
 	statefulSet := readDeploymentFile(t, destination, "base", "stateful-set.yaml")
+	for _, expected := range []string{
+		`test -n "$REDIS_PASSWORD"`,
+		`--requirepass "$REDIS_PASSWORD"`,
+		`exec redis-server /etc/redis/redis.conf "$@"`,
+		"checksum/config: ",
+	} {
+		if !strings.Contains(statefulSet, expected) {
+			t.Errorf("restricted StatefulSet missing %q:\n%s", expected, statefulSet)
+		}
+	}
+	assertRestrictedManifests(t, restrictedManifests{
+		StatefulSet: statefulSet,
+		ConfigMap:   readDeploymentFile(t, destination, "base", "config-map.yaml"),
+		Tree:        readManifestTree(t, destination),
+	}, passwordEnvironmentKey, "UNRELATED_SECRET", "unrelated-credentials")
+}
+
+// restrictedManifests are the rendered manifests the restricted-portable
+// profile constrains, whichever output they come from.
+type restrictedManifests struct {
+	StatefulSet string
+	ConfigMap   string
+	// Tree is every manifest of the output.
+	Tree string
+}
+
+// assertRestrictedManifests checks that the password only reaches redis
+// through the redis-credentials Secret reference and that the manifests
+// create neither a Namespace nor a Secret. unexpected are strings the
+// StatefulSet must not mention, such as the password's configuration key.
+func assertRestrictedManifests(t *testing.T, manifests restrictedManifests, unexpected ...string) {
+	t.Helper()
 	for _, expected := range []string{
 		"automountServiceAccountToken: false",
 		"image: redis@" + image.Digest,
-		`test -n "$REDIS_PASSWORD"`,
-		`exec redis-server --requirepass "$REDIS_PASSWORD"`,
 		"name: REDIS_PASSWORD",
 		"name: REDISCLI_AUTH",
 		"name: redis-credentials",
 		"key: password",
 	} {
-		if !strings.Contains(statefulSet, expected) {
-			t.Errorf("restricted StatefulSet missing %q:\n%s", expected, statefulSet)
+		if !strings.Contains(manifests.StatefulSet, expected) {
+			t.Errorf("restricted StatefulSet missing %q:\n%s", expected, manifests.StatefulSet)
 		}
 	}
-	for _, unexpected := range []string{
-		"envFrom:",
-		passwordEnvironmentKey,
-		"UNRELATED_SECRET",
-		"unrelated-credentials",
-	} {
-		if strings.Contains(statefulSet, unexpected) {
-			t.Errorf("restricted StatefulSet contains %q:\n%s", unexpected, statefulSet)
+	for _, unexpected := range append([]string{"envFrom:"}, unexpected...) {
+		if strings.Contains(manifests.StatefulSet, unexpected) {
+			t.Errorf("restricted StatefulSet contains %q:\n%s", unexpected, manifests.StatefulSet)
 		}
 	}
-
-	tree := readManifestTree(t, destination)
 	for _, unexpected := range []string{
 		"kind: Namespace",
 		"kind: Secret",
-		"\ndata:",
 		"\nstringData:",
 	} {
-		if strings.Contains(tree, unexpected) {
-			t.Errorf("restricted manifest tree contains %q:\n%s", unexpected, tree)
+		if strings.Contains(manifests.Tree, unexpected) {
+			t.Errorf("restricted manifest tree contains %q:\n%s", unexpected, manifests.Tree)
 		}
 	}
+	// The redis.conf ConfigMap is the only manifest carrying data, and never
+	// the password.
+	if strings.Count(manifests.Tree, "\ndata:") != 1 {
+		t.Errorf("restricted manifest tree carries data outside the redis.conf ConfigMap:\n%s", manifests.Tree)
+	}
+	if strings.Contains(manifests.ConfigMap, "requirepass") {
+		t.Errorf("redis.conf ConfigMap contains the password:\n%s", manifests.ConfigMap)
+	}
 }
 
 func TestRestrictedPortableRequirePassRejectsUnusablePasswordReference(t *testing.T) {
@@ -191,6 +226,929 @@ func TestRestrictedPortableRequirePassRejectsUnusablePasswordReference(t *testin
 	}
 }
 
+func TestDeploymentAppliesEnvironmentResourcesAndStorage(t *testing.T) {
+	useSuccessfulKubectl(t)
+	builder, networkMappings := newDeploymentTestBuilder(t)
+	builder.MaxMemory = "1gb"
+	builder.Deployment = DeploymentSettings{
+		Resources: ResourceSettings{Limits: ResourceQuantities{Memory: "512Mi"}},
+		Environments: map[string]yaml.Node{
+			"test": yamlNode(t, `
+resources:
+  limits:
+    cpu: "2"
+    memory: 2Gi
+storage:
+  size: 10Gi
+  storage-class: fast-ssd
+`),
+		},
+	}
+	destination := t.TempDir()
+
+	response, err := builder.Deploy(context.Background(), restrictedDeploymentRequest(destination, networkMappings, nil, false))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if response.GetState().GetState() != builderv0.DeploymentStatus_SUCCESS {
+		t.Fatalf("deployment failed: %s", response.GetState().GetMessage())
+	}
+
+	statefulSet := readDeploymentFile(t, destination, "base", "stateful-set.yaml")
+	for _, expected := range []string{
+		"cpu: 50m",
+		"memory: 64Mi",
+		"cpu: 2\n",
+		"memory: 2Gi",
+		`accessModes: ["ReadWriteOnce"]`,
+		"storageClassName: fast-ssd",
+		"storage: 10Gi",
+	} {
+		if !strings.Contains(statefulSet, expected) {
+			t.Errorf("StatefulSet missing %q:\n%s", expected, statefulSet)
+		}
+	}
+	if config := readDeploymentFile(t, destination, "base", "config-map.yaml"); !strings.Contains(config, `maxmemory "1gb"`) {
+		t.Errorf("redis.conf ConfigMap missing maxmemory:\n%s", config)
+	}
+}
+
+func TestDeploymentPullsEnvironmentImage(t *testing.T) {
+	useSuccessfulKubectl(t)
+	builder, networkMappings := newDeploymentTestBuilder(t)
+	builder.Image = ImageSettings{Registry: "mirror.example.com"}
+	builder.Deployment = DeploymentSettings{
+		Environments: map[string]yaml.Node{
+			"test": yamlNode(t, `
+image:
+  registry: registry.prod.example.com:5000
+`),
+		},
+	}
+	destination := t.TempDir()
+
+	response, err := builder.Deploy(context.Background(), restrictedDeploymentRequest(destination, networkMappings, nil, false))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if response.GetState().GetState() != builderv0.DeploymentStatus_SUCCESS {
+		t.Fatalf("deployment failed: %s", response.GetState().GetMessage())
+	}
+	statefulSet := readDeploymentFile(t, destination, "base", "stateful-set.yaml")
+	if expected := "image: registry.prod.example.com:5000/library/redis@" + image.Digest; !strings.Contains(statefulSet, expected) {
+		t.Errorf("StatefulSet does not pull %q:\n%s", expected, statefulSet)
+	}
+}
+
+func TestDeploymentRejectsMemoryLimitBelowMaxMemory(t *testing.T) {
+	builder, networkMappings := newDeploymentTestBuilder(t)
+	builder.MaxMemory = "1gb"
+
+	response, err := builder.Deploy(context.Background(), restrictedDeploymentRequest(t.TempDir(), networkMappings, nil, false))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if response.GetState().GetState() != builderv0.DeploymentStatus_ERROR {
+		t.Fatalf("deployment status = %s, want ERROR", response.GetState().GetState())
+	}
+	if !strings.Contains(response.GetState().GetMessage(), "must exceed redis maxmemory") {
+		t.Fatalf("deployment error = %q", response.GetState().GetMessage())
+	}
+}
+
+func TestDeploymentReportsImageErrors(t *testing.T) {
+	builder, networkMappings := newDeploymentTestBuilder(t)
+	builder.Image = ImageSettings{Tag: "8.10.1-alpine"}
+
+	response, err := builder.Deploy(context.Background(), restrictedDeploymentRequest(t.TempDir(), networkMappings, nil, false))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if response.GetState().GetState() != builderv0.DeploymentStatus_ERROR {
+		t.Fatalf("deployment status = %s, want ERROR", response.GetState().GetState())
+	}
+	if !strings.Contains(response.GetState().GetMessage(), "needs its digest") {
+		t.Fatalf("deployment error = %q", response.GetState().GetMessage())
+	}
+}
+
+func TestRestrictedPortableReplicatedDeploymentRendersSentinel(t *testing.T) {
+	useSuccessfulKubectl(t)
+	builder, networkMappings := newDeploymentTestBuilder(t)
+	builder.RequirePass = true
+	builder.Deployment = DeploymentSettings{
+		Topology: TopologyReplicated,
+		Sentinel: SentinelSettings{MasterName: "cache"},
+	}
+	passwordKey := resources.ServiceSecretConfigurationKeyFromUnique(builder.Unique(), "redis", "REDIS_PASSWORD")
+	destination := t.TempDir()
+
+	response, err := builder.Deploy(context.Background(), restrictedDeploymentRequest(
+		destination,
+		networkMappings,
+		map[string]*builderv0.KubernetesSecretKeyReference{
+			passwordKey: {Name: "redis-credentials", Key: "password"},
+		},
+		true,
+	))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if response.GetState().GetState() != builderv0.DeploymentStatus_SUCCESS {
+		t.Fatalf("deployment failed: %s", response.GetState().GetMessage())
+	}
+	output := response.GetDeployment().GetKubernetes()
+	if output.GetValidation().GetStaticValidation() != builderv0.KubernetesManifestValidation_STATUS_PASSED {
+		t.Fatalf("static validation failed: %v", output.GetValidation().GetViolations())
+	}
+	assertRestrictedManifestBundle(t, output, passwordKey)
+
+	statefulSet := readDeploymentFile(t, destination, "base", "stateful-set.yaml")
+	for _, expected := range []string{
+		"replicas: 3",
+		"initContainers:",
+		"get-master-addr-by-name cache",
+		`echo "replicaof $primary 6379"`,
+		`--masterauth "$REDIS_PASSWORD"`,
+	} {
+		if !strings.Contains(statefulSet, expected) {
+			t.Errorf("replicated StatefulSet missing %q:\n%s", expected, statefulSet)
+		}
+	}
+	sentinel := readDeploymentFile(t, destination, "base", "sentinel-stateful-set.yaml")
+	for _, expected := range []string{
+		"kind: StatefulSet",
+		"automountServiceAccountToken: false",
+		"sentinel monitor cache $primary 6379 2",
+		"name: redis-credentials",
+	} {
+		if !strings.Contains(sentinel, expected) {
+			t.Errorf("sentinel StatefulSet missing %q:\n%s", expected, sentinel)
+		}
+	}
+	kustomization := readDeploymentFile(t, destination, "base", "kustomization.yaml")
+	for _, expected := range []string{"sentinel-stateful-set.yaml", "sentinel-service.yaml"} {
+		if !strings.Contains(kustomization, expected) {
+			t.Errorf("kustomization missing %q:\n%s", expected, kustomization)
+		}
+	}
+
+	values := map[string]*basev0.ConfigurationValue{}
+	for _, value := range response.GetConfiguration().GetInfos()[0].GetConfigurationValues() {
+		values[value.GetKey()] = value
+	}
+	if values["connection"].GetValue() != "" || !values["connection"].GetSecret() {
+		t.Errorf("connection = %+v, want a value-free secret reference", values["connection"])
+	}
+	if got := values["master-name"].GetValue(); got != "cache" {
+		t.Errorf("master-name = %q", got)
+	}
+	wantSentinels := "redis-sentinel-0.redis-sentinel.example.com:26379," +
+		"redis-sentinel-1.redis-sentinel.example.com:26379," +
+		"redis-sentinel-2.redis-sentinel.example.com:26379"
+	if got := values["sentinels"].GetValue(); got != wantSentinels {
+		t.Errorf("sentinels = %q, want %q", got, wantSentinels)
+	}
+	if values["sentinels"].GetSecret() {
+		t.Error("sentinel endpoints must not be marked secret")
+	}
+}
+
+func TestRestrictedPortableClusterDeploymentRendersBootstrap(t *testing.T) {
+	useSuccessfulKubectl(t)
+	builder, networkMappings := newDeploymentTestBuilder(t)
+	builder.RequirePass = true
+	builder.Deployment = DeploymentSettings{Topology: TopologyCluster}
+	passwordKey := resources.ServiceSecretConfigurationKeyFromUnique(builder.Unique(), "redis", "REDIS_PASSWORD")
+	destination := t.TempDir()
+
+	response, err := builder.Deploy(context.Background(), restrictedDeploymentRequest(
+		destination,
+		networkMappings,
+		map[string]*builderv0.KubernetesSecretKeyReference{
+			passwordKey: {Name: "redis-credentials", Key: "password"},
+		},
+		true,
+	))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if response.GetState().GetState() != builderv0.DeploymentStatus_SUCCESS {
+		t.Fatalf("deployment failed: %s", response.GetState().GetMessage())
+	}
+	output := response.GetDeployment().GetKubernetes()
+	if output.GetValidation().GetStaticValidation() != builderv0.KubernetesManifestValidation_STATUS_PASSED {
+		t.Fatalf("static validation failed: %v", output.GetValidation().GetViolations())
+	}
+	if output.GetValidation().GetServerSideValidation() != builderv0.KubernetesManifestValidation_STATUS_PASSED {
+		t.Fatalf("server-side validation failed: %v", output.GetValidation().GetViolations())
+	}
+	assertRestrictedManifestBundle(t, output, passwordKey)
+
+	statefulSet := readDeploymentFile(t, destination, "base", "stateful-set.yaml")
+	for _, expected := range []string{
+		"serviceName: redis-nodes",
+		"replicas: 6",
+		"--cluster-announce-hostname",
+		"containerPort: 16379",
+	} {
+		if !strings.Contains(statefulSet, expected) {
+			t.Errorf("cluster StatefulSet missing %q:\n%s", expected, statefulSet)
+		}
+	}
+	if config := readDeploymentFile(t, destination, "base", "config-map.yaml"); !strings.Contains(config, "cluster-enabled yes") {
+		t.Errorf("cluster redis.conf missing cluster-enabled:\n%s", config)
+	}
+	job := readDeploymentFile(t, destination, "base", "cluster-bootstrap-job.yaml")
+	for _, expected := range []string{
+		"kind: Job",
+		"automountServiceAccountToken: false",
+		"--cluster-replicas 1",
+		"name: REDISCLI_AUTH",
+		"name: redis-credentials",
+	} {
+		if !strings.Contains(job, expected) {
+			t.Errorf("bootstrap Job missing %q:\n%s", expected, job)
+		}
+	}
+	service := readDeploymentFile(t, destination, "base", "cluster-service.yaml")
+	if !strings.Contains(service, "clusterIP: None") || !strings.Contains(service, "port: 16379") {
+		t.Errorf("cluster Service is not a headless node-discovery service:\n%s", service)
+	}
+	tree := readManifestTree(t, destination)
+	for _, unexpected := range []string{"kind: Secret", "\nstringData:", "sentinel"} {
+		if strings.Contains(tree, unexpected) {
+			t.Errorf("cluster manifest tree contains %q", unexpected)
+		}
+	}
+
+	values := response.GetConfiguration().GetInfos()[0].GetConfigurationValues()
+	var seeds string
+	for _, value := range values {
+		if value.GetKey() == "seed-nodes" {
+			seeds = value.GetValue()
+		}
+	}
+	if got := strings.Count(seeds, ","); got != 5 || !strings.HasPrefix(seeds, "redis-0.redis-nodes.example.com:6379,") {
+		t.Errorf("seed-nodes = %q, want the six cluster pods", seeds)
+	}
+}
+
+func TestReplicatedDeploymentRoutesWritesToPrimary(t *testing.T) {
+	useSuccessfulKubectl(t)
+	builder, networkMappings := newDeploymentTestBuilder(t)
+	builder.Deployment = DeploymentSettings{Topology: TopologyReplicated}
+	destination := t.TempDir()
+
+	response, err := builder.Deploy(context.Background(), deploymentRequest(destination, networkMappings))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if response.GetState().GetState() != builderv0.DeploymentStatus_SUCCESS {
+		t.Fatalf("deployment failed: %s", response.GetState().GetMessage())
+	}
+
+	values := map[string]*basev0.ConfigurationValue{}
+	for _, value := range response.GetConfiguration().GetInfos()[0].GetConfigurationValues() {
+		values[value.GetKey()] = value
+	}
+	if got := values["connection"].GetValue(); got != "redis://redis-primary.example.com:6379" {
+		t.Errorf("connection = %q, want the primary Service", got)
+	}
+
+	kustomization := readDeploymentFile(t, destination, "base", "kustomization.yaml")
+	for _, expected := range []string{"primary-service.yaml", "service-account.yaml", "role.yaml", "role-binding.yaml"} {
+		if !strings.Contains(kustomization, expected) {
+			t.Errorf("kustomization missing %q:\n%s", expected, kustomization)
+		}
+	}
+	primary := readDeploymentFile(t, destination, "base", "primary-service.yaml")
+	for _, expected := range []string{"name: redis-primary", "redis.codefly.dev/role: primary"} {
+		if !strings.Contains(primary, expected) {
+			t.Errorf("primary Service missing %q:\n%s", expected, primary)
+		}
+	}
+	statefulSet := readDeploymentFile(t, destination, "base", "stateful-set.yaml")
+	for _, expected := range []string{
+		"serviceAccountName: redis",
+		"- name: role",
+		"image: " + kubectlImage.Name + "@sha256:",
+		"redis.codefly.dev/role=$current",
+		"name: redis-secret",
+		"serviceAccountToken:",
+	} {
+		if !strings.Contains(statefulSet, expected) {
+			t.Errorf("replicated StatefulSet missing %q:\n%s", expected, statefulSet)
+		}
+	}
+	if kubectl, err := builder.sidecarImage(kubectlSidecar); err != nil || !strings.Contains(statefulSet, "image: "+imageReference(kubectl)) {
+		t.Errorf("role sidecar does not run the kubectl image pinned in the lockfile: %+v, %v", kubectl, err)
+	}
+}
+
+func TestReplicatedTemplatesLabelThePrimary(t *testing.T) {
+	parameters := newDeploymentTemplateParameters()
+	parameters.Topology = TopologyReplicated
+	data := helmTemplateData{Name: "redis", Namespace: "codefly-test", Image: imageReference(image), Deployment: helmTemplateDeployment{Parameters: parameters}}
+
+	service, err := renderBaseTemplate("service.yaml", data)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !strings.Contains(service, "clusterIP: None") || strings.Contains(service, "redis.codefly.dev/role") {
+		t.Errorf("headless Service must keep selecting every pod:\n%s", service)
+	}
+	primary, err := renderBaseTemplate("primary-service.yaml", data)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !strings.Contains(primary, "name: redis-primary") || !strings.Contains(primary, "redis.codefly.dev/role: primary") {
+		t.Errorf("primary Service does not select the primary:\n%s", primary)
+	}
+	role, err := renderBaseTemplate("role.yaml", data)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !strings.Contains(role, `verbs: ["get", "patch"]`) {
+		t.Errorf("Role does not let the sidecar label its pod:\n%s", role)
+	}
+	statefulSet, err := renderBaseTemplate("stateful-set.yaml", data)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if strings.Count(statefulSet, "kube-api-access") != 2 {
+		t.Errorf("the API token must only be mounted in the role sidecar:\n%s", statefulSet)
+	}
+
+	parameters.Topology = TopologyStandalone
+	for _, file := range []string{"primary-service.yaml", "service-account.yaml", "role.yaml", "role-binding.yaml"} {
+		if content, err := renderBaseTemplate(file, data); err != nil || strings.TrimSpace(content) != "" {
+			t.Errorf("standalone renders %s: %q, %v", file, content, err)
+		}
+	}
+	if statefulSet, err = renderBaseTemplate("stateful-set.yaml", data); err != nil || strings.Contains(statefulSet, "serviceAccountName") {
+		t.Errorf("standalone StatefulSet uses a service account: %v", err)
+	}
+}
+
+func TestMultiPodDeploymentTemplatesProtectAvailability(t *testing.T) {
+	tests := []struct {
+		name       string
+		settings   string
+		expected   []string
+		unexpected []string
+	}{
+		{
+			name:       "standalone",
+			settings:   `topology: standalone`,
+			unexpected: []string{"pod-disruption-budget.yaml", "podAntiAffinity", "topologySpreadConstraints"},
+		},
+		{
+			name:     "replicated",
+			settings: `topology: replicated`,
+			expected: []string{
+				"pod-disruption-budget.yaml",
+				"sentinel-pod-disruption-budget.yaml",
+				"kind: PodDisruptionBudget",
+				"maxUnavailable: 1",
+				"preferredDuringSchedulingIgnoredDuringExecution",
+				"topologyKey: topology.kubernetes.io/zone",
+				"whenUnsatisfiable: ScheduleAnyway",
+			},
+		},
+		{
+			name: "cluster with required anti-affinity",
+			settings: `
+topology: cluster
+availability:
+  anti-affinity: required
+  topology-spread:
+    disabled: true`,
+			expected:   []string{"pod-disruption-budget.yaml", "requiredDuringSchedulingIgnoredDuringExecution"},
+			unexpected: []string{"sentinel-pod-disruption-budget.yaml", "topologySpreadConstraints"},
+		},
+		{
+			name: "replicated without budget",
+			settings: `
+topology: replicated
+availability:
+  pod-disruption-budget:
+    disabled: true
+  anti-affinity: none`,
+			expected:   []string{"topologySpreadConstraints"},
+			unexpected: []string{"pod-disruption-budget.yaml", "podAntiAffinity"},
+		},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			var settings DeploymentSettings
+			if err := yaml.Unmarshal([]byte(test.settings), &settings); err != nil {
+				t.Fatal(err)
+			}
+			resolved, err := settings.forEnvironment("test")
+			if err != nil {
+				t.Fatal(err)
+			}
+			if err := resolved.validate(""); err != nil {
+				t.Fatal(err)
+			}
+			parameters := newDeploymentTemplateParameters()
+			parameters.DeploymentSettings = *resolved
+			tree := readManifestTree(t, agenttesting.AssertKustomizeTemplates(t, deploymentFS, parameters))
+			for _, expected := range test.expected {
+				if !strings.Contains(tree, expected) {
+					t.Errorf("manifests missing %q", expected)
+				}
+			}
+			for _, unexpected := range test.unexpected {
+				if strings.Contains(tree, unexpected) {
+					t.Errorf("manifests contain %q", unexpected)
+				}
+			}
+		})
+	}
+}
+
+func TestDeploymentTemplatesRestrictIngress(t *testing.T) {
+	render := func(t *testing.T, policy NetworkPolicySettings) (string, string) {
+		t.Helper()
+		parameters := newDeploymentTemplateParameters()
+		parameters.NetworkPolicy = policy
+		destination := agenttesting.AssertKustomizeTemplates(t, deploymentFS, parameters)
+		return readDeploymentFile(t, destination, "base", "kustomization.yaml"),
+			readDeploymentFile(t, destination, "base", "network-policy.yaml")
+	}
+
+	t.Run("clients by default", func(t *testing.T) {
+		kustomization, policy := render(t, defaultDeploymentSettings().NetworkPolicy)
+		if !strings.Contains(kustomization, "network-policy.yaml") {
+			t.Fatalf("kustomization does not include the NetworkPolicy:\n%s", kustomization)
+		}
+		for _, expected := range []string{"kind: NetworkPolicy", `redis-client: "true"`, "port: 6379"} {
+			if !strings.Contains(policy, expected) {
+				t.Errorf("NetworkPolicy missing %q:\n%s", expected, policy)
+			}
+		}
+		if strings.Contains(policy, "podSelector: {}") || strings.Contains(policy, "namespaceSelector: {}") {
+			t.Errorf("default NetworkPolicy admits unlabelled pods:\n%s", policy)
+		}
+	})
+	t.Run("namespace fallback", func(t *testing.T) {
+		_, policy := render(t, NetworkPolicySettings{Fallback: NetworkPolicyFallbackNamespace})
+		if !strings.Contains(policy, "podSelector: {}") {
+			t.Errorf("NetworkPolicy does not admit the namespace:\n%s", policy)
+		}
+	})
+	t.Run("declared dependents", func(t *testing.T) {
+		_, policy := render(t, NetworkPolicySettings{Dependents: []NetworkPolicyPeer{{App: "api"}, {App: "worker", Namespace: "jobs"}}})
+		if strings.Contains(policy, "podSelector: {}") || strings.Contains(policy, "namespaceSelector: {}") {
+			t.Errorf("NetworkPolicy still admits every pod:\n%s", policy)
+		}
+		for _, expected := range []string{
+			"kubernetes.io/metadata.name: jobs",
+			"app: api",
+			"app: worker",
+		} {
+			if !strings.Contains(policy, expected) {
+				t.Errorf("NetworkPolicy missing %q:\n%s", expected, policy)
+			}
+		}
+		if strings.Count(policy, "namespaceSelector:") != 2 {
+			t.Errorf("every dependent must select its namespace:\n%s", policy)
+		}
+	})
+	t.Run("cluster fallback", func(t *testing.T) {
+		_, policy := render(t, NetworkPolicySettings{Fallback: NetworkPolicyFallbackCluster})
+		if !strings.Contains(policy, "namespaceSelector: {}") {
+			t.Errorf("NetworkPolicy does not admit other namespaces:\n%s", policy)
+		}
+	})
+	t.Run("no fallback", func(t *testing.T) {
+		_, policy := render(t, NetworkPolicySettings{Fallback: NetworkPolicyFallbackNone})
+		if strings.Contains(policy, "port: 6379") {
+			t.Errorf("NetworkPolicy still admits clients:\n%s", policy)
+		}
+	})
+	t.Run("disabled", func(t *testing.T) {
+		kustomization, policy := render(t, NetworkPolicySettings{Disabled: true})
+		if strings.Contains(kustomization, "network-policy.yaml") || strings.Contains(policy, "kind:") {
+			t.Errorf("disabled NetworkPolicy still rendered:\n%s\n%s", kustomization, policy)
+		}
+	})
+}
+
+func TestDeploymentTemplatesExportMetrics(t *testing.T) {
+	render := func(t *testing.T, metrics MetricsSettings) string {
+		t.Helper()
+		parameters := newDeploymentTemplateParameters()
+		parameters.Metrics = metrics
+		return agenttesting.AssertKustomizeTemplates(t, deploymentFS, parameters)
+	}
+
+	t.Run("disabled by default", func(t *testing.T) {
+		tree := readManifestTree(t, render(t, defaultDeploymentSettings().Metrics))
+		for _, unexpected := range []string{"redis_exporter", "9121", "ServiceMonitor"} {
+			if strings.Contains(tree, unexpected) {
+				t.Errorf("manifests contain %q", unexpected)
+			}
+		}
+	})
+	t.Run("sidecar", func(t *testing.T) {
+		destination := render(t, MetricsSettings{Enabled: true, ServiceMonitor: ServiceMonitorSettings{Interval: "30s"}})
+		statefulSet := readDeploymentFile(t, destination, "base", "stateful-set.yaml")
+		for _, expected := range []string{"name: metrics", imageReference(exporterImage), "redis://localhost:6379", "containerPort: 9121"} {
+			if !strings.Contains(statefulSet, expected) {
+				t.Errorf("StatefulSet missing %q:\n%s", expected, statefulSet)
+			}
+		}
+		service := readDeploymentFile(t, destination, "base", "service.yaml")
+		if !strings.Contains(service, "port: 9121") {
+			t.Errorf("Service does not expose metrics:\n%s", service)
+		}
+		policy := readDeploymentFile(t, destination, "base", "network-policy.yaml")
+		if !strings.Contains(policy, "port: 9121") {
+			t.Errorf("NetworkPolicy does not admit scrapes:\n%s", policy)
+		}
+		if strings.Contains(readDeploymentFile(t, destination, "base", "kustomization.yaml"), "service-monitor.yaml") {
+			t.Error("ServiceMonitor rendered without being enabled")
+		}
+	})
+	t.Run("service monitor", func(t *testing.T) {
+		destination := render(t, MetricsSettings{
+			Enabled: true,
+			ServiceMonitor: ServiceMonitorSettings{
+				Enabled:  true,
+				Interval: "15s",
+				Labels:   map[string]string{"release": "prometheus"},
+			},
+		})
+		if !strings.Contains(readDeploymentFile(t, destination, "base", "kustomization.yaml"), "service-monitor.yaml") {
+			t.Fatal("kustomization does not include the ServiceMonitor")
+		}
+		monitor := readDeploymentFile(t, destination, "base", "service-monitor.yaml")
+		for _, expected := range []string{"kind: ServiceMonitor", "port: metrics", "interval: 15s", `release: "prometheus"`} {
+			if !strings.Contains(monitor, expected) {
+				t.Errorf("ServiceMonitor missing %q:\n%s", expected, monitor)
+			}
+		}
+	})
+}
+
+func TestDeploymentTemplatesRenderBackupAndRestore(t *testing.T) {
+	render := func(t *testing.T, backup BackupSettings, restore RestoreSettings) string {
+		t.Helper()
+		parameters := newDeploymentTemplateParameters()
+		parameters.Backup = backup
+		parameters.Restore = restore
+		if err := parameters.validate(""); err != nil {
+			t.Fatal(err)
+		}
+		return agenttesting.AssertKustomizeTemplates(t, deploymentFS, parameters)
+	}
+	defaults := defaultDeploymentSettings().Backup
+
+	t.Run("off by default", func(t *testing.T) {
+		destination := render(t, defaults, RestoreSettings{})
+		kustomization := readDeploymentFile(t, destination, "base", "kustomization.yaml")
+		for _, unexpected := range []string{"backup", "restore"} {
+			if strings.Contains(kustomization, unexpected) {
+				t.Errorf("kustomization references %q:\n%s", unexpected, kustomization)
+			}
+		}
+	})
+	t.Run("volume", func(t *testing.T) {
+		backup := defaults
+		backup.Schedule = "0 3 * * *"
+		backup.RetentionDays = 14
+		destination := render(t, backup, RestoreSettings{File: "redis-20260101T030000Z.rdb"})
+		kustomization := readDeploymentFile(t, destination, "base", "kustomization.yaml")
+		for _, expected := range []string{"backup-volume.yaml", "backup-cron-job.yaml"} {
+			if !strings.Contains(kustomization, expected) {
+				t.Errorf("kustomization missing %q", expected)
+			}
+		}
+		if strings.Contains(kustomization, "restore") {
+			t.Errorf("restore rendered outside the StatefulSet:\n%s", kustomization)
+		}
+		cronJob := readDeploymentFile(t, destination, "base", "backup-cron-job.yaml")
+		for _, expected := range []string{
+			"kind: CronJob",
+			`schedule: "0 3 * * *"`,
+			"--rdb",
+			"-mtime +14",
+			"claimName: redis-backups",
+			"statefulset.kubernetes.io/pod-name: redis-0",
+		} {
+			if !strings.Contains(cronJob, expected) {
+				t.Errorf("CronJob missing %q:\n%s", expected, cronJob)
+			}
+		}
+		statefulSet := readDeploymentFile(t, destination, "base", "stateful-set.yaml")
+		for _, expected := range []string{
+			"- name: restore",
+			`[ "$(hostname)" = "redis-0" ] || exit 0`,
+			"/backup/redis-20260101T030000Z.rdb",
+			".restore-complete",
+			"claimName: redis-backups",
+		} {
+			if !strings.Contains(statefulSet, expected) {
+				t.Errorf("StatefulSet missing %q:\n%s", expected, statefulSet)
+			}
+		}
+		if strings.Contains(statefulSet, "fetch-backup") {
+			t.Errorf("StatefulSet fetches a backup kept on the volume:\n%s", statefulSet)
+		}
+	})
+	t.Run("s3", func(t *testing.T) {
+		backup := defaults
+		backup.Schedule = "@daily"
+		backup.Target = BackupTargetS3
+		backup.S3 = BackupS3Settings{
+			Endpoint:          "http://minio.storage:9000",
+			Bucket:            "backups",
+			Prefix:            "redis",
+			CredentialsSecret: "minio-credentials",
+		}
+		destination := render(t, backup, RestoreSettings{File: "redis-20260101T030000Z.rdb"})
+		if strings.Contains(readDeploymentFile(t, destination, "base", "kustomization.yaml"), "backup-volume.yaml") {
+			t.Error("S3 backups rendered a backup volume")
+		}
+		cronJob := readDeploymentFile(t, destination, "base", "backup-cron-job.yaml")
+		for _, expected := range []string{
+			backupClientImage.FullName(),
+			`"backup/backups/redis/"`,
+			"--older-than 7d",
+			"name: minio-credentials",
+			`value: "http://minio.storage:9000"`,
+			`mc alias set backup "$BACKUP_ENDPOINT" "$AWS_ACCESS_KEY_ID" "$AWS_SECRET_ACCESS_KEY"`,
+		} {
+			if !strings.Contains(cronJob, expected) {
+				t.Errorf("CronJob missing %q:\n%s", expected, cronJob)
+			}
+		}
+		if strings.Contains(cronJob, "MC_HOST") || strings.Contains(cronJob, "podAffinity") {
+			t.Errorf("CronJob passes the credentials in an mc host URL or follows pod 0:\n%s", cronJob)
+		}
+		statefulSet := readDeploymentFile(t, destination, "base", "stateful-set.yaml")
+		for _, expected := range []string{
+			"- name: fetch-backup",
+			`mc cp "${BACKUP_PATH}redis-20260101T030000Z.rdb"`,
+			"- name: restore",
+		} {
+			if !strings.Contains(statefulSet, expected) {
+				t.Errorf("StatefulSet missing %q:\n%s", expected, statefulSet)
+			}
+		}
+	})
+}
+
+func TestDeploymentConfigChecksumTracksRedisConfig(t *testing.T) {
+	parameters := newDeploymentTemplateParameters()
+	before := parameters.ConfigChecksum()
+	parameters.Directives = (&Settings{MaxMemoryPolicy: "allkeys-lru"}).redisDirectives()
+	after := parameters.ConfigChecksum()
+	if before == after {
+		t.Fatal("config checksum did not change with the configuration")
+	}
+
+	destination := agenttesting.AssertKustomizeTemplates(t, deploymentFS, parameters)
+	config := readDeploymentFile(t, destination, "base", "config-map.yaml")
+	if !strings.Contains(config, "kind: ConfigMap") || !strings.Contains(config, `maxmemory-policy "allkeys-lru"`) {
+		t.Errorf("redis.conf ConfigMap:\n%s", config)
+	}
+	statefulSet := readDeploymentFile(t, destination, "base", "stateful-set.yaml")
+	for _, expected := range []string{"checksum/config: " + after, "mountPath: /etc/redis", "name: redis-config"} {
+		if !strings.Contains(statefulSet, expected) {
+			t.Errorf("StatefulSet missing %q:\n%s", expected, statefulSet)
+		}
+	}
+}
+
+func TestDeploymentTemplatesLoadScripts(t *testing.T) {
+	parameters := newDeploymentTemplateParameters()
+	if strings.Contains(readDeploymentFile(t, agenttesting.AssertKustomizeTemplates(t, deploymentFS, parameters), "base", "stateful-set.yaml"), "checksum/scripts") {
+		t.Error("scripts checksum annotated without scripts")
+	}
+	before := parameters.ScriptsChecksum()
+	parameters.Scripts = scriptFiles([]redisScript{
+		{Name: "get", Body: "return redis.call(\"GET\", KEYS[1])\n"},
+		{Name: "counter", Body: "#!lua name=counter\n", Library: "counter"},
+	})
+	checksum := parameters.ScriptsChecksum()
+	if checksum == before {
+		t.Error("scripts checksum did not change with the scripts")
+	}
+
+	destination := agenttesting.AssertKustomizeTemplates(t, deploymentFS, parameters)
+	if !strings.Contains(readDeploymentFile(t, destination, "base", "kustomization.yaml"), "scripts-config-map.yaml") {
+		t.Fatal("kustomization does not include the scripts ConfigMap")
+	}
+	var configMap struct {
+		Data map[string]string `yaml:"data"`
+	}
+	if err := yaml.Unmarshal([]byte(readDeploymentFile(t, destination, "base", "scripts-config-map.yaml")), &configMap); err != nil {
+		t.Fatal(err)
+	}
+	if configMap.Data["get.lua"] != "return redis.call(\"GET\", KEYS[1])\n" || configMap.Data["counter.lua"] != "#!lua name=counter\n" {
+		t.Errorf("scripts ConfigMap data = %q", configMap.Data)
+	}
+	statefulSet := readDeploymentFile(t, destination, "base", "stateful-set.yaml")
+	for _, expected := range []string{
+		"checksum/scripts: " + checksum,
+		"postStart:",
+		"redis-cli -x script load < /etc/redis-scripts/get.lua",
+		"redis-cli -x function load replace < /etc/redis-scripts/counter.lua",
+		"mountPath: /etc/redis-scripts",
+		"name: redis-scripts",
+	} {
+		if !strings.Contains(statefulSet, expected) {
+			t.Errorf("StatefulSet missing %q:\n%s", expected, statefulSet)
+		}
+	}
+	// A failing postStart hook kills the container: a slow AOF load must not
+	// crash-loop the pod.
+	_, hook, _ := strings.Cut(statefulSet, "postStart:")
+	hook, _, _ = strings.Cut(hook, "volumeMounts:")
+	for _, unexpected := range []string{"set -e", "exit 1"} {
+		if strings.Contains(hook, unexpected) {
+			t.Errorf("postStart hook can fail with %q:\n%s", unexpected, hook)
+		}
+	}
+}
+
+func TestDeploymentTemplatesProvisionStreams(t *testing.T) {
+	parameters := newDeploymentTemplateParameters()
+	if strings.Contains(readManifestTree(t, agenttesting.AssertKustomizeTemplates(t, deploymentFS, parameters)), "streams-job") {
+		t.Error("streams Job rendered without streams")
+	}
+	parameters.StreamProvisioning = streamProvisioningLines([]StreamSettings{{Name: "orders", Groups: []string{"billing"}, MaxLen: 1000}})
+	destination := agenttesting.AssertKustomizeTemplates(t, deploymentFS, parameters)
+	if !strings.Contains(readDeploymentFile(t, destination, "base", "kustomization.yaml"), "streams-job.yaml") {
+		t.Fatal("kustomization does not include the streams Job")
+	}
+	job := readDeploymentFile(t, destination, "base", "streams-job.yaml")
+	for _, expected := range []string{"kind: Job", "app: redis-streams", "group 'orders' 'billing' '$'", "cli xtrim 'orders' MAXLEN '~' 1000"} {
+		if !strings.Contains(job, expected) {
+			t.Errorf("streams Job missing %q:\n%s", expected, job)
+		}
+	}
+	if !strings.Contains(readDeploymentFile(t, destination, "base", "network-policy.yaml"), "redis-streams") {
+		t.Error("NetworkPolicy does not admit the streams Job")
+	}
+}
+
+func TestRestrictedPortableHelmChartMatchesKustomizeBase(t *testing.T) {
+	useSuccessfulKubectl(t)
+	builder, networkMappings := newDeploymentTestBuilder(t)
+	builder.RequirePass = true
+	builder.Deployment = DeploymentSettings{
+		Topology: TopologyReplicated,
+		Storage:  StorageSettings{StorageClass: "fast-ssd"},
+		Output:   DeploymentOutputHelm,
+	}
+	passwordKey := resources.ServiceSecretConfigurationKeyFromUnique(builder.Unique(), "redis", "REDIS_PASSWORD")
+	destination := t.TempDir()
+
+	response, err := builder.Deploy(context.Background(), restrictedDeploymentRequest(
+		destination,
+		networkMappings,
+		map[string]*builderv0.KubernetesSecretKeyReference{
+			passwordKey:        {Name: "redis-credentials", Key: "password"},
+			"UNRELATED_SECRET": {Name: "unrelated-credentials", Key: "token"},
+		},
+		true,
+	))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if response.GetState().GetState() != builderv0.DeploymentStatus_SUCCESS {
+		t.Fatalf("deployment failed: %s", response.GetState().GetMessage())
+	}
+
+	chart := filepath.Join(destination, "helm", "redis")
+	chartYAML := readDeploymentFile(t, chart, "Chart.yaml")
+	for _, expected := range []string{"apiVersion: v2", "name: redis", "version: 1.2.3", "appVersion: " + image.Tag} {
+		if !strings.Contains(chartYAML, expected) {
+			t.Errorf("Chart.yaml missing %q:\n%s", expected, chartYAML)
+		}
+	}
+	var values map[string]any
+	if err = yaml.Unmarshal([]byte(readDeploymentFile(t, chart, "values.yaml")), &values); err != nil {
+		t.Fatal(err)
+	}
+
+	templates, err := os.ReadDir(filepath.Join(chart, "templates"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	manifests := map[string]string{}
+	var rendered strings.Builder
+	for _, entry := range templates {
+		manifests[entry.Name()] = renderHelmChartTemplate(t, chart, entry.Name(), values)
+		rendered.WriteString(manifests[entry.Name()])
+	}
+	assertRestrictedManifests(t, restrictedManifests{
+		StatefulSet: manifests["stateful-set.yaml"],
+		ConfigMap:   manifests["config-map.yaml"],
+		Tree:        rendered.String(),
+	}, passwordKey, "UNRELATED_SECRET", "unrelated-credentials")
+	assertHelmValueReferences(t, chart, values)
+	assertHelmTemplate(t, chart, manifests)
+	base, err := os.ReadDir(filepath.Join(destination, "base"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, entry := range base {
+		if entry.Name() == "kustomization.yaml" {
+			continue
+		}
+		manifest, ok := manifests[entry.Name()]
+		if !ok {
+			t.Errorf("chart has no template for %s", entry.Name())
+			continue
+		}
+		delete(manifests, entry.Name())
+		if !reflect.DeepEqual(parseManifest(t, manifest), parseManifest(t, readDeploymentFile(t, destination, "base", entry.Name()))) {
+			t.Errorf("chart %s differs from the kustomize base:\n%s", entry.Name(), manifest)
+		}
+	}
+	for name := range manifests {
+		t.Errorf("chart template %s has no kustomize counterpart", name)
+	}
+
+	tree := readManifestTree(t, chart)
+	for _, unexpected := range []string{"kind: Namespace", "kind: Secret", passwordKey, "unrelated-credentials"} {
+		if strings.Contains(tree, unexpected) {
+			t.Errorf("helm chart contains %q:\n%s", unexpected, tree)
+		}
+	}
+
+	values["replicas"] = 5
+	values["storage"].(map[string]any)["storageClass"] = ""
+	statefulSet := renderHelmChartTemplate(t, chart, "stateful-set.yaml", values)
+	if !strings.Contains(statefulSet, "replicas: 5") || strings.Contains(statefulSet, "storageClassName:") {
+		t.Errorf("chart StatefulSet ignores replicas and storageClass values:\n%s", statefulSet)
+	}
+	values["topology"] = TopologyStandalone
+	if _, err = executeHelmChartTemplate(chart, "stateful-set.yaml", values); err == nil {
+		t.Error("chart accepted a topology it was not generated for")
+	}
+}
+
+func renderHelmChartTemplate(t *testing.T, chart, name string, values map[string]any) string {
+	t.Helper()
+	manifest, err := executeHelmChartTemplate(chart, name, values)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return manifest
+}
+
+// executeHelmChartTemplate renders a chart template the way helm does for a
+// release installed in the test namespace.
+func executeHelmChartTemplate(chart, name string, values map[string]any) (string, error) {
+	source, err := os.ReadFile(filepath.Join(chart, "templates", name))
+	if err != nil {
+		return "", err
+	}
+	tmpl, err := template.New(name).Option("missingkey=error").Funcs(helmChartFuncs).Parse(string(source))
+	if err != nil {
+		return "", err
+	}
+	var out strings.Builder
+	err = tmpl.Execute(&out, map[string]any{
+		"Values":  values,
+		"Release": map[string]any{"Namespace": "codefly-test"},
+	})
+	return out.String(), err
+}
+
+func parseManifest(t *testing.T, manifest string) any {
+	t.Helper()
+	var document any
+	if err := yaml.Unmarshal([]byte(manifest), &document); err != nil {
+		t.Fatalf("invalid manifest: %v\n%s", err, manifest)
+	}
+	return document
+}
+
+func TestStandaloneDeploymentOmitsSentinel(t *testing.T) {
+	destination := agenttesting.AssertKustomizeTemplates(t, deploymentFS, newDeploymentTemplateParameters())
+	kustomization := readDeploymentFile(t, destination, "base", "kustomization.yaml")
+	if strings.Contains(kustomization, "sentinel") {
+		t.Fatalf("standalone kustomization references sentinel:\n%s", kustomization)
+	}
+}
+
+func yamlNode(t *testing.T, src string) yaml.Node {
+	t.Helper()
+	var node yaml.Node
+	if err := yaml.Unmarshal([]byte(src), &node); err != nil {
+		t.Fatal(err)
+	}
+	return *node.Content[0]
+}
+
 func newDeploymentTestBuilder(t *testing.T) (*Builder, []*basev0.NetworkMapping) {
 	t.Helper()
 	ctx := context.Background()
@@ -209,6 +1167,12 @@ func newDeploymentTestBuilder(t *testing.T) (*Builder, []*basev0.NetworkMapping)
 		Module:  resources.ToModuleWithCase(builder.Identity),
 	}
 	builder.EnvironmentVariables.SetIdentity(identity)
+	builder.Location = t.TempDir()
+	// Deployments pin their sidecar images: resolve a digest per image
+	// instead of asking the registry.
+	builder.resolveDigest = func(_ context.Context, img string) (string, error) {
+		return fmt.Sprintf("sha256:%x", sha256.Sum256([]byte(img))), nil
+	}
 	builder.TcpEndpoint = &basev0.Endpoint{
 		Name:    "tcp",
 		Module:  identity.Module,
@@ -229,18 +1193,26 @@ func restrictedDeploymentRequest(
 	secretReferences map[string]*builderv0.KubernetesSecretKeyReference,
 	validateServerSide bool,
 ) *builderv0.DeploymentRequest {
+	req := deploymentRequest(destination, networkMappings)
+	kubernetes := req.GetDeployment().GetKubernetes()
+	kubernetes.Profile = builderv0.KubernetesOutputProfile_KUBERNETES_OUTPUT_PROFILE_RESTRICTED_PORTABLE_V1
+	kubernetes.SecretReferences = secretReferences
+	kubernetes.ValidateServerSide = validateServerSide
+	kubernetes.ValidationKubeconfig = "/tmp/codefly-test-kubeconfig"
+	kubernetes.ValidationContext = "k3d-codefly-test"
+	return req
+}
+
+// deploymentRequest is a Kubernetes deployment with the default output
+// profile.
+func deploymentRequest(destination string, networkMappings []*basev0.NetworkMapping) *builderv0.DeploymentRequest {
 	return &builderv0.DeploymentRequest{
 		Environment:     &basev0.Environment{Name: "test"},
 		NetworkMappings: networkMappings,
 		Deployment: &builderv0.Deployment{Kind: &builderv0.Deployment_Kubernetes{
 			Kubernetes: &builderv0.KubernetesDeployment{
-				Namespace:            "codefly-test",
-				Destination:          destination,
-				Profile:              builderv0.KubernetesOutputProfile_KUBERNETES_OUTPUT_PROFILE_RESTRICTED_PORTABLE_V1,
-				SecretReferences:     secretReferences,
-				ValidateServerSide:   validateServerSide,
-				ValidationKubeconfig: "/tmp/codefly-test-kubeconfig",
-				ValidationContext:    "k3d-codefly-test",
+				Namespace:   "codefly-test",
+				Destination: destination,
 			},
 		}},
 	}
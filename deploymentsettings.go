@@ -0,0 +1,571 @@
+package main
+
+// deploymentsettings.go — per-environment tuning of the Kubernetes manifests.
+//
+// The `deployment` block of service.codefly.yaml holds shared defaults and an
+// `environments` map of overrides keyed by environment name:
+//
+//	deployment:
+//	  resources:
+//	    limits:
+//	      memory: 512Mi
+//	  environments:
+//	    production:
+//	      resources:
+//	        limits:
+//	          memory: 2Gi
+//	      storage:
+//	        size: 20Gi
+//	        storage-class: fast-ssd
+//
+// Resolution layers the built-in defaults, then the shared block, then the
+// environment override. Overrides are kept as raw YAML and decoded over the
+// shared values, so an environment only has to spell out what it changes.
+
+import (
+	"fmt"
+	"regexp"
+	"slices"
+	"strconv"
+	"strings"
+
+	"gopkg.in/yaml.v3"
+)
+
+// Deployment topologies.
+const (
+	// TopologyStandalone is a single redis pod.
+	TopologyStandalone = "standalone"
+	// TopologyReplicated is a primary with replicas, monitored by Sentinel for
+	// automatic failover.
+	TopologyReplicated = "replicated"
+	// TopologyCluster is a sharded Redis Cluster.
+	TopologyCluster = "cluster"
+)
+
+// DeploymentSettings tunes the manifests rendered by Builder.Deploy.
+type DeploymentSettings struct {
+	Resources ResourceSettings `yaml:"resources,omitempty"`
+	Storage   StorageSettings  `yaml:"storage,omitempty"`
+
+	Topology string `yaml:"topology,omitempty"`
+	// Replicas is the number of redis pods, primary included, of a replicated
+	// topology.
+	Replicas int              `yaml:"replicas,omitempty"`
+	Sentinel SentinelSettings `yaml:"sentinel,omitempty"`
+	Cluster  ClusterSettings  `yaml:"cluster,omitempty"`
+
+	Availability  AvailabilitySettings  `yaml:"availability,omitempty"`
+	NetworkPolicy NetworkPolicySettings `yaml:"network-policy,omitempty"`
+	Metrics       MetricsSettings       `yaml:"metrics,omitempty"`
+
+	Backup  BackupSettings  `yaml:"backup,omitempty"`
+	Restore RestoreSettings `yaml:"restore,omitempty"`
+
+	// Image overrides the redis image the deployment pulls, on top of the
+	// service's image settings.
+	Image ImageSettings `yaml:"image,omitempty"`
+
+	// Output is the manifest format handed to the platform: kustomize, helm
+	// to also write a chart next to the kustomize bundle, or compose to also
+	// write a docker-compose definition next to it.
+	Output string `yaml:"output,omitempty"`
+
+	// Environments overrides any of the settings above for one environment.
+	Environments map[string]yaml.Node `yaml:"environments,omitempty"`
+}
+
+// ResourceSettings are the redis container's requests and limits.
+type ResourceSettings struct {
+	Requests ResourceQuantities `yaml:"requests,omitempty"`
+	Limits   ResourceQuantities `yaml:"limits,omitempty"`
+}
+
+// ResourceQuantities are Kubernetes quantities (e.g. cpu: 500m, memory: 256Mi).
+type ResourceQuantities struct {
+	CPU    string `yaml:"cpu,omitempty"`
+	Memory string `yaml:"memory,omitempty"`
+}
+
+// StorageSettings shape the data volume claim template.
+type StorageSettings struct {
+	Size string `yaml:"size,omitempty"`
+	// StorageClass is left out of the claim when empty so the cluster default
+	// storage class applies.
+	StorageClass string `yaml:"storage-class,omitempty"`
+	AccessMode   string `yaml:"access-mode,omitempty"`
+}
+
+// SentinelSettings shape the Sentinel quorum of a replicated topology.
+type SentinelSettings struct {
+	Replicas   int    `yaml:"replicas,omitempty"`
+	Quorum     int    `yaml:"quorum,omitempty"`
+	MasterName string `yaml:"master-name,omitempty"`
+}
+
+// ClusterSettings size a Redis Cluster topology.
+type ClusterSettings struct {
+	Shards int `yaml:"shards,omitempty"`
+	// ReplicasPerShard is a pointer so an explicit 0 survives layering over
+	// the default of 1.
+	ReplicasPerShard *int `yaml:"replicas-per-shard,omitempty"`
+}
+
+// ShardReplicas is the number of replicas behind each shard's primary.
+func (c ClusterSettings) ShardReplicas() int {
+	if c.ReplicasPerShard == nil {
+		return 0
+	}
+	return *c.ReplicasPerShard
+}
+
+// AvailabilitySettings keep a multi-pod topology spread out and protected
+// from voluntary disruptions such as node drains. They only render when redis
+// runs more than one pod.
+type AvailabilitySettings struct {
+	PodDisruptionBudget PodDisruptionBudgetSettings `yaml:"pod-disruption-budget,omitempty"`
+	// AntiAffinity keeps redis pods off a shared node: preferred (default),
+	// required, or none.
+	AntiAffinity   string                 `yaml:"anti-affinity,omitempty"`
+	TopologySpread TopologySpreadSettings `yaml:"topology-spread,omitempty"`
+}
+
+// PodDisruptionBudgetSettings bound how many pods a drain may evict at once.
+type PodDisruptionBudgetSettings struct {
+	Disabled       bool `yaml:"disabled,omitempty"`
+	MaxUnavailable int  `yaml:"max-unavailable,omitempty"`
+}
+
+// TopologySpreadSettings spread pods evenly across a topology domain.
+type TopologySpreadSettings struct {
+	Disabled          bool   `yaml:"disabled,omitempty"`
+	TopologyKey       string `yaml:"topology-key,omitempty"`
+	MaxSkew           int    `yaml:"max-skew,omitempty"`
+	WhenUnsatisfiable string `yaml:"when-unsatisfiable,omitempty"`
+}
+
+// NetworkPolicySettings restrict which pods may connect to redis. The policy
+// renders unless disabled; redis' own pods (replicas, sentinels, cluster
+// bootstrap) can always reach each other.
+type NetworkPolicySettings struct {
+	Disabled bool `yaml:"disabled,omitempty"`
+	// Dependents are the services allowed to connect. A dependent without a
+	// namespace runs in the namespace the deployment request targets:
+	//
+	//	dependents:
+	//	  - api
+	//	  - app: worker
+	//	    namespace: jobs
+	Dependents []NetworkPolicyPeer `yaml:"dependents,omitempty"`
+	// Fallback is who may connect when no dependents are declared: clients
+	// (default) admits the pods of the deployment's namespace labelled
+	// <name>-client: "true"; namespace, cluster or none widen or close it.
+	Fallback string `yaml:"fallback,omitempty"`
+}
+
+// NetworkPolicyPeer is a dependent's app label and namespace.
+type NetworkPolicyPeer struct {
+	App       string `yaml:"app"`
+	Namespace string `yaml:"namespace,omitempty"`
+}
+
+// UnmarshalYAML also accepts a bare app label.
+func (p *NetworkPolicyPeer) UnmarshalYAML(node *yaml.Node) error {
+	if node.Kind == yaml.ScalarNode {
+		*p = NetworkPolicyPeer{App: node.Value}
+		return nil
+	}
+	type plain NetworkPolicyPeer
+	return node.Decode((*plain)(p))
+}
+
+// Network policy fallbacks.
+const (
+	NetworkPolicyFallbackClients   = "clients"
+	NetworkPolicyFallbackNamespace = "namespace"
+	NetworkPolicyFallbackCluster   = "cluster"
+	NetworkPolicyFallbackNone      = "none"
+)
+
+var networkPolicyFallbacks = []string{NetworkPolicyFallbackClients, NetworkPolicyFallbackNamespace, NetworkPolicyFallbackCluster, NetworkPolicyFallbackNone}
+
+func (n NetworkPolicySettings) validate() error {
+	for _, peer := range n.Dependents {
+		if !kubernetesLabelValue.MatchString(peer.App) {
+			return fmt.Errorf("invalid network-policy dependent %q: not a Kubernetes label value", peer.App)
+		}
+		if peer.Namespace != "" && !kubernetesNamespace.MatchString(peer.Namespace) {
+			return fmt.Errorf("invalid network-policy dependent namespace %q", peer.Namespace)
+		}
+	}
+	if n.Fallback != "" && !slices.Contains(networkPolicyFallbacks, n.Fallback) {
+		return fmt.Errorf("invalid network-policy fallback %q (want one of %s)", n.Fallback, strings.Join(networkPolicyFallbacks, ", "))
+	}
+	return nil
+}
+
+// MetricsSettings add a Prometheus redis_exporter sidecar to every redis pod.
+type MetricsSettings struct {
+	Enabled        bool                   `yaml:"enabled,omitempty"`
+	ServiceMonitor ServiceMonitorSettings `yaml:"service-monitor,omitempty"`
+}
+
+// ServiceMonitorSettings render a prometheus-operator ServiceMonitor for the
+// exporter. Only enable it on clusters that have the CRD installed.
+type ServiceMonitorSettings struct {
+	Enabled  bool   `yaml:"enabled,omitempty"`
+	Interval string `yaml:"interval,omitempty"`
+	// Labels are added to the ServiceMonitor so a Prometheus instance's
+	// serviceMonitorSelector picks it up.
+	Labels map[string]string `yaml:"labels,omitempty"`
+}
+
+// ServiceMonitorEnabled reports whether a ServiceMonitor renders.
+func (d *DeploymentSettings) ServiceMonitorEnabled() bool {
+	return d.Metrics.Enabled && d.Metrics.ServiceMonitor.Enabled
+}
+
+// Deployment outputs.
+const (
+	DeploymentOutputKustomize = "kustomize"
+	DeploymentOutputHelm      = "helm"
+	DeploymentOutputCompose   = "compose"
+)
+
+// Anti-affinity modes.
+const (
+	AntiAffinityNone      = "none"
+	AntiAffinityPreferred = "preferred"
+	AntiAffinityRequired  = "required"
+)
+
+// defaultDeploymentSettings are the built-in defaults. Resources and storage
+// match what the StatefulSet shipped with before they became configurable.
+func defaultDeploymentSettings() *DeploymentSettings {
+	// A fresh pointer per call: decoding overrides writes through it.
+	shardReplicas := 1
+	return &DeploymentSettings{
+		Resources: ResourceSettings{
+			Requests: ResourceQuantities{CPU: "50m", Memory: "64Mi"},
+			Limits:   ResourceQuantities{CPU: "500m", Memory: "256Mi"},
+		},
+		Storage: StorageSettings{
+			Size:       "1Gi",
+			AccessMode: "ReadWriteOnce",
+		},
+		Topology: TopologyStandalone,
+		Replicas: 3,
+		Sentinel: SentinelSettings{
+			Replicas:   3,
+			Quorum:     2,
+			MasterName: "mymaster",
+		},
+		Cluster: ClusterSettings{
+			Shards:           3,
+			ReplicasPerShard: &shardReplicas,
+		},
+		Availability: AvailabilitySettings{
+			PodDisruptionBudget: PodDisruptionBudgetSettings{MaxUnavailable: 1},
+			AntiAffinity:        AntiAffinityPreferred,
+			TopologySpread: TopologySpreadSettings{
+				TopologyKey:       "topology.kubernetes.io/zone",
+				MaxSkew:           1,
+				WhenUnsatisfiable: "ScheduleAnyway",
+			},
+		},
+		NetworkPolicy: NetworkPolicySettings{Fallback: NetworkPolicyFallbackClients},
+		Metrics: MetricsSettings{
+			ServiceMonitor: ServiceMonitorSettings{Interval: "30s"},
+		},
+		Backup: BackupSettings{
+			RetentionDays: 7,
+			Target:        BackupTargetVolume,
+			Volume:        BackupVolumeSettings{Size: "5Gi"},
+		},
+		Output: DeploymentOutputKustomize,
+	}
+}
+
+// Replicated reports whether the topology runs a primary with replicas and
+// Sentinel.
+func (d *DeploymentSettings) Replicated() bool {
+	return d.Topology == TopologyReplicated
+}
+
+// Clustered reports whether the topology is a sharded Redis Cluster.
+func (d *DeploymentSettings) Clustered() bool {
+	return d.Topology == TopologyCluster
+}
+
+// RedisReplicas is the replica count of the redis StatefulSet.
+func (d *DeploymentSettings) RedisReplicas() int {
+	switch {
+	case d.Replicated():
+		return d.Replicas
+	case d.Clustered():
+		return d.Cluster.Shards * (1 + d.Cluster.ShardReplicas())
+	default:
+		return 1
+	}
+}
+
+// forEnvironment returns the effective settings for environment: defaults,
+// then the shared block, then the environment's override when one exists.
+func (d *DeploymentSettings) forEnvironment(environment string) (*DeploymentSettings, error) {
+	resolved := defaultDeploymentSettings()
+	if d == nil {
+		return resolved, nil
+	}
+	shared := *d
+	shared.Environments = nil
+	raw, err := yaml.Marshal(&shared)
+	if err != nil {
+		return nil, fmt.Errorf("encode deployment settings: %w", err)
+	}
+	if err := yaml.Unmarshal(raw, resolved); err != nil {
+		return nil, fmt.Errorf("apply deployment settings: %w", err)
+	}
+	if override, ok := d.Environments[environment]; ok {
+		if err := override.Decode(resolved); err != nil {
+			return nil, fmt.Errorf("apply deployment settings for environment %q: %w", environment, err)
+		}
+		resolved.Environments = nil
+	}
+	return resolved, nil
+}
+
+// allEnvironments resolves the shared settings and every environment
+// override.
+func (d *DeploymentSettings) allEnvironments() ([]*DeploymentSettings, error) {
+	environments := []string{""}
+	for environment := range d.Environments {
+		environments = append(environments, environment)
+	}
+	all := make([]*DeploymentSettings, 0, len(environments))
+	for _, environment := range environments {
+		resolved, err := d.forEnvironment(environment)
+		if err != nil {
+			return nil, err
+		}
+		all = append(all, resolved)
+	}
+	return all, nil
+}
+
+// MultiPod reports whether redis runs more than one pod.
+func (d *DeploymentSettings) MultiPod() bool {
+	return d.RedisReplicas() > 1
+}
+
+// PodDisruptionBudgetEnabled reports whether PodDisruptionBudgets render.
+func (d *DeploymentSettings) PodDisruptionBudgetEnabled() bool {
+	return d.MultiPod() && !d.Availability.PodDisruptionBudget.Disabled
+}
+
+// AntiAffinityEnabled reports whether pod anti-affinity renders.
+func (d *DeploymentSettings) AntiAffinityEnabled() bool {
+	return d.MultiPod() && d.Availability.AntiAffinity != AntiAffinityNone
+}
+
+// TopologySpreadEnabled reports whether topology spread constraints render.
+func (d *DeploymentSettings) TopologySpreadEnabled() bool {
+	return d.MultiPod() && !d.Availability.TopologySpread.Disabled
+}
+
+var deploymentTopologies = []string{TopologyStandalone, TopologyReplicated, TopologyCluster}
+
+var deploymentOutputs = []string{DeploymentOutputKustomize, DeploymentOutputHelm, DeploymentOutputCompose}
+
+var kubernetesAccessModes = []string{"ReadWriteOnce", "ReadWriteOncePod", "ReadWriteMany", "ReadOnlyMany"}
+
+var cpuQuantity = regexp.MustCompile(`^(\d+(\.\d+)?|\.\d+)m?$`)
+
+// validate rejects settings Kubernetes would refuse and a memory limit that
+// leaves redis no headroom above maxmemory: once the container limit is hit
+// the kernel OOM-kills redis before eviction ever gets a chance to run.
+func (d *DeploymentSettings) validate(maxMemory string) error {
+	for name, quantity := range map[string]string{
+		"requests.cpu": d.Resources.Requests.CPU,
+		"limits.cpu":   d.Resources.Limits.CPU,
+	} {
+		if !cpuQuantity.MatchString(quantity) {
+			return fmt.Errorf("invalid deployment resources %s %q", name, quantity)
+		}
+	}
+	requestMemory, err := parseKubernetesMemory(d.Resources.Requests.Memory)
+	if err != nil {
+		return fmt.Errorf("invalid deployment resources requests.memory: %w", err)
+	}
+	limitMemory, err := parseKubernetesMemory(d.Resources.Limits.Memory)
+	if err != nil {
+		return fmt.Errorf("invalid deployment resources limits.memory: %w", err)
+	}
+	if requestMemory > limitMemory {
+		return fmt.Errorf("deployment memory request %s exceeds memory limit %s", d.Resources.Requests.Memory, d.Resources.Limits.Memory)
+	}
+	if _, err := parseKubernetesMemory(d.Storage.Size); err != nil {
+		return fmt.Errorf("invalid deployment storage size: %w", err)
+	}
+	if !slices.Contains(kubernetesAccessModes, d.Storage.AccessMode) {
+		return fmt.Errorf("invalid deployment storage access-mode %q (want one of %s)", d.Storage.AccessMode, strings.Join(kubernetesAccessModes, ", "))
+	}
+	if !slices.Contains(deploymentOutputs, d.Output) {
+		return fmt.Errorf("invalid deployment output %q (want one of %s)", d.Output, strings.Join(deploymentOutputs, ", "))
+	}
+	if err := d.validateTopology(); err != nil {
+		return err
+	}
+	if err := d.Image.validate(); err != nil {
+		return err
+	}
+	if err := d.Availability.validate(); err != nil {
+		return err
+	}
+	if err := d.validateBackup(); err != nil {
+		return err
+	}
+	if d.ServiceMonitorEnabled() && !prometheusDuration.MatchString(d.Metrics.ServiceMonitor.Interval) {
+		return fmt.Errorf("invalid metrics service-monitor interval %q", d.Metrics.ServiceMonitor.Interval)
+	}
+	if err := d.NetworkPolicy.validate(); err != nil {
+		return err
+	}
+	if maxMemory == "" {
+		return nil
+	}
+	maxMemoryBytes, err := parseRedisMemory(maxMemory)
+	if err != nil {
+		return fmt.Errorf("invalid maxmemory: %w", err)
+	}
+	if maxMemoryBytes > 0 && limitMemory <= maxMemoryBytes {
+		return fmt.Errorf("deployment memory limit %s must exceed redis maxmemory %s", d.Resources.Limits.Memory, maxMemory)
+	}
+	return nil
+}
+
+var prometheusDuration = regexp.MustCompile(`^(\d+(ms|s|m|h|d|w|y))+$`)
+
+var kubernetesLabelValue = regexp.MustCompile(`^[A-Za-z0-9]([A-Za-z0-9._-]{0,61}[A-Za-z0-9])?$`)
+
+var kubernetesNamespace = regexp.MustCompile(`^[a-z0-9]([a-z0-9-]{0,61}[a-z0-9])?$`)
+
+var sentinelMasterName = regexp.MustCompile(`^[A-Za-z0-9._-]+$`)
+
+func (d *DeploymentSettings) validateTopology() error {
+	if !slices.Contains(deploymentTopologies, d.Topology) {
+		return fmt.Errorf("invalid deployment topology %q (want one of %s)", d.Topology, strings.Join(deploymentTopologies, ", "))
+	}
+	if d.Clustered() {
+		// Redis Cluster refuses to form with fewer than three primaries.
+		if d.Cluster.Shards < 3 {
+			return fmt.Errorf("cluster topology needs at least 3 shards, got %d", d.Cluster.Shards)
+		}
+		if d.Cluster.ShardReplicas() < 0 {
+			return fmt.Errorf("cluster replicas-per-shard must not be negative, got %d", d.Cluster.ShardReplicas())
+		}
+		return nil
+	}
+	if !d.Replicated() {
+		return nil
+	}
+	if d.Replicas < 2 {
+		return fmt.Errorf("replicated topology needs at least 2 replicas, got %d", d.Replicas)
+	}
+	if d.Sentinel.Quorum < 1 || d.Sentinel.Quorum > d.Sentinel.Replicas {
+		return fmt.Errorf("sentinel quorum %d must be between 1 and the %d sentinel replicas", d.Sentinel.Quorum, d.Sentinel.Replicas)
+	}
+	if !sentinelMasterName.MatchString(d.Sentinel.MasterName) {
+		return fmt.Errorf("invalid sentinel master-name %q", d.Sentinel.MasterName)
+	}
+	return nil
+}
+
+var (
+	antiAffinityModes  = []string{AntiAffinityNone, AntiAffinityPreferred, AntiAffinityRequired}
+	unsatisfiableModes = []string{"ScheduleAnyway", "DoNotSchedule"}
+)
+
+func (a *AvailabilitySettings) validate() error {
+	// A budget of zero would block every drain of a node running redis.
+	if a.PodDisruptionBudget.MaxUnavailable < 1 {
+		return fmt.Errorf("pod-disruption-budget max-unavailable must be at least 1, got %d", a.PodDisruptionBudget.MaxUnavailable)
+	}
+	if !slices.Contains(antiAffinityModes, a.AntiAffinity) {
+		return fmt.Errorf("invalid anti-affinity %q (want one of %s)", a.AntiAffinity, strings.Join(antiAffinityModes, ", "))
+	}
+	if a.TopologySpread.Disabled {
+		return nil
+	}
+	if a.TopologySpread.TopologyKey == "" {
+		return fmt.Errorf("topology-spread needs a topology-key")
+	}
+	if a.TopologySpread.MaxSkew < 1 {
+		return fmt.Errorf("topology-spread max-skew must be at least 1, got %d", a.TopologySpread.MaxSkew)
+	}
+	if !slices.Contains(unsatisfiableModes, a.TopologySpread.WhenUnsatisfiable) {
+		return fmt.Errorf("invalid topology-spread when-unsatisfiable %q (want one of %s)", a.TopologySpread.WhenUnsatisfiable, strings.Join(unsatisfiableModes, ", "))
+	}
+	return nil
+}
+
+var kubernetesMemorySuffixes = map[string]float64{
+	"":   1,
+	"k":  1e3,
+	"M":  1e6,
+	"G":  1e9,
+	"T":  1e12,
+	"Ki": 1 << 10,
+	"Mi": 1 << 20,
+	"Gi": 1 << 30,
+	"Ti": 1 << 40,
+}
+
+var kubernetesMemoryQuantity = regexp.MustCompile(`^(\d+(?:\.\d+)?)([kMGT]i?|Ki)?$`)
+
+// parseKubernetesMemory converts a Kubernetes memory quantity to bytes.
+func parseKubernetesMemory(quantity string) (int64, error) {
+	match := kubernetesMemoryQuantity.FindStringSubmatch(quantity)
+	if match == nil {
+		return 0, fmt.Errorf("%q is not a memory quantity", quantity)
+	}
+	value, err := strconv.ParseFloat(match[1], 64)
+	if err != nil {
+		return 0, fmt.Errorf("%q is not a memory quantity: %w", quantity, err)
+	}
+	multiplier, ok := kubernetesMemorySuffixes[match[2]]
+	if !ok {
+		return 0, fmt.Errorf("%q has an unknown memory suffix", quantity)
+	}
+	return int64(value * multiplier), nil
+}
+
+var redisMemorySuffixes = map[string]int64{
+	"":   1,
+	"b":  1,
+	"k":  1000,
+	"kb": 1 << 10,
+	"m":  1000 * 1000,
+	"mb": 1 << 20,
+	"g":  1000 * 1000 * 1000,
+	"gb": 1 << 30,
+}
+
+var redisMemoryValue = regexp.MustCompile(`^(\d+)([a-z]*)$`)
+
+// parseRedisMemory converts a redis.conf memory value to bytes using redis'
+// own units: k/m/g are powers of 1000, kb/mb/gb powers of 1024.
+func parseRedisMemory(value string) (int64, error) {
+	match := redisMemoryValue.FindStringSubmatch(strings.ToLower(strings.TrimSpace(value)))
+	if match == nil {
+		return 0, fmt.Errorf("%q is not a redis memory value", value)
+	}
+	amount, err := strconv.ParseInt(match[1], 10, 64)
+	if err != nil {
+		return 0, fmt.Errorf("%q is not a redis memory value: %w", value, err)
+	}
+	multiplier, ok := redisMemorySuffixes[match[2]]
+	if !ok {
+		return 0, fmt.Errorf("%q has an unknown memory unit", value)
+	}
+	return amount * multiplier, nil
+}
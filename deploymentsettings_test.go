@@ -0,0 +1,359 @@
+package main
+
+import (
+	"slices"
+	"strings"
+	"testing"
+
+	"gopkg.in/yaml.v3"
+)
+
+func TestDeploymentSettingsLayerEnvironmentOverrides(t *testing.T) {
+	src := []byte(`
+deployment:
+  resources:
+    limits:
+      memory: 512Mi
+  storage:
+    storage-class: standard
+  environments:
+    production:
+      resources:
+        requests:
+          cpu: 250m
+      storage:
+        size: 20Gi
+        storage-class: fast-ssd
+        access-mode: ReadWriteOncePod
+`)
+	var s Settings
+	if err := yaml.Unmarshal(src, &s); err != nil {
+		t.Fatalf("yaml unmarshal: %v", err)
+	}
+
+	production, err := s.Deployment.forEnvironment("production")
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := ResourceSettings{
+		Requests: ResourceQuantities{CPU: "250m", Memory: "64Mi"},
+		Limits:   ResourceQuantities{CPU: "500m", Memory: "512Mi"},
+	}
+	if production.Resources != want {
+		t.Errorf("production resources = %+v, want %+v", production.Resources, want)
+	}
+	if production.Storage != (StorageSettings{Size: "20Gi", StorageClass: "fast-ssd", AccessMode: "ReadWriteOncePod"}) {
+		t.Errorf("production storage = %+v", production.Storage)
+	}
+
+	staging, err := s.Deployment.forEnvironment("staging")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if staging.Resources.Requests.CPU != "50m" || staging.Resources.Limits.Memory != "512Mi" {
+		t.Errorf("staging resources = %+v, want shared values over defaults", staging.Resources)
+	}
+	if staging.Storage != (StorageSettings{Size: "1Gi", StorageClass: "standard", AccessMode: "ReadWriteOnce"}) {
+		t.Errorf("staging storage = %+v", staging.Storage)
+	}
+	if s.Deployment.Resources.Requests.CPU != "" {
+		t.Error("resolving an environment mutated the shared settings")
+	}
+}
+
+func TestDeploymentSettingsValidate(t *testing.T) {
+	tests := []struct {
+		name      string
+		mutate    func(*DeploymentSettings)
+		maxMemory string
+		message   string
+	}{
+		{name: "defaults"},
+		{name: "maxmemory below limit", maxMemory: "200mb"},
+		{name: "maxmemory at limit", maxMemory: "256mb", message: "must exceed redis maxmemory"},
+		{name: "maxmemory above limit", maxMemory: "1gb", message: "must exceed redis maxmemory"},
+		{
+			name:      "raised limit",
+			mutate:    func(d *DeploymentSettings) { d.Resources.Limits.Memory = "2Gi" },
+			maxMemory: "1gb",
+		},
+		{name: "bad maxmemory", maxMemory: "lots", message: "invalid maxmemory"},
+		{
+			name:    "bad cpu",
+			mutate:  func(d *DeploymentSettings) { d.Resources.Limits.CPU = "fast" },
+			message: "limits.cpu",
+		},
+		{
+			name:    "request above limit",
+			mutate:  func(d *DeploymentSettings) { d.Resources.Requests.Memory = "1Gi" },
+			message: "exceeds memory limit",
+		},
+		{
+			name:    "bad storage size",
+			mutate:  func(d *DeploymentSettings) { d.Storage.Size = "big" },
+			message: "storage size",
+		},
+		{
+			name:   "replicated",
+			mutate: func(d *DeploymentSettings) { d.Topology = TopologyReplicated },
+		},
+		{
+			name:    "unknown topology",
+			mutate:  func(d *DeploymentSettings) { d.Topology = "mesh" },
+			message: "invalid deployment topology",
+		},
+		{
+			name: "single replica",
+			mutate: func(d *DeploymentSettings) {
+				d.Topology = TopologyReplicated
+				d.Replicas = 1
+			},
+			message: "at least 2 replicas",
+		},
+		{
+			name: "quorum above sentinels",
+			mutate: func(d *DeploymentSettings) {
+				d.Topology = TopologyReplicated
+				d.Sentinel.Quorum = 4
+			},
+			message: "sentinel quorum",
+		},
+		{
+			name: "bad master name",
+			mutate: func(d *DeploymentSettings) {
+				d.Topology = TopologyReplicated
+				d.Sentinel.MasterName = "my master"
+			},
+			message: "master-name",
+		},
+		{
+			name:   "cluster",
+			mutate: func(d *DeploymentSettings) { d.Topology = TopologyCluster },
+		},
+		{
+			name: "cluster with two shards",
+			mutate: func(d *DeploymentSettings) {
+				d.Topology = TopologyCluster
+				d.Cluster.Shards = 2
+			},
+			message: "at least 3 shards",
+		},
+		{
+			name:    "zero disruption budget",
+			mutate:  func(d *DeploymentSettings) { d.Availability.PodDisruptionBudget.MaxUnavailable = 0 },
+			message: "max-unavailable",
+		},
+		{
+			name:    "bad anti-affinity",
+			mutate:  func(d *DeploymentSettings) { d.Availability.AntiAffinity = "sometimes" },
+			message: "anti-affinity",
+		},
+		{
+			name:    "bad spread mode",
+			mutate:  func(d *DeploymentSettings) { d.Availability.TopologySpread.WhenUnsatisfiable = "Maybe" },
+			message: "when-unsatisfiable",
+		},
+		{
+			name: "spread disabled",
+			mutate: func(d *DeploymentSettings) {
+				d.Availability.TopologySpread = TopologySpreadSettings{Disabled: true}
+			},
+		},
+		{
+			name: "bad network-policy dependent",
+			mutate: func(d *DeploymentSettings) {
+				d.NetworkPolicy.Dependents = []NetworkPolicyPeer{{App: "api"}, {App: "not a label"}}
+			},
+			message: "network-policy dependent",
+		},
+		{
+			name: "bad network-policy dependent namespace",
+			mutate: func(d *DeploymentSettings) {
+				d.NetworkPolicy.Dependents = []NetworkPolicyPeer{{App: "api", Namespace: "Jobs"}}
+			},
+			message: "network-policy dependent namespace",
+		},
+		{
+			name:    "bad network-policy fallback",
+			mutate:  func(d *DeploymentSettings) { d.NetworkPolicy.Fallback = "anyone" },
+			message: "network-policy fallback",
+		},
+		{
+			name: "bad service monitor interval",
+			mutate: func(d *DeploymentSettings) {
+				d.Metrics.Enabled = true
+				d.Metrics.ServiceMonitor = ServiceMonitorSettings{Enabled: true, Interval: "often"}
+			},
+			message: "service-monitor interval",
+		},
+		{
+			name:   "volume backups",
+			mutate: func(d *DeploymentSettings) { d.Backup.Schedule = "0 3 * * *" },
+		},
+		{
+			name:    "bad backup schedule",
+			mutate:  func(d *DeploymentSettings) { d.Backup.Schedule = "nightly" },
+			message: "backup schedule",
+		},
+		{
+			name: "s3 backups without credentials",
+			mutate: func(d *DeploymentSettings) {
+				d.Backup.Schedule = "@daily"
+				d.Backup.Target = BackupTargetS3
+				d.Backup.S3 = BackupS3Settings{Endpoint: "http://minio:9000", Bucket: "backups"}
+			},
+			message: "credentials-secret",
+		},
+		{
+			name: "cluster backups",
+			mutate: func(d *DeploymentSettings) {
+				d.Topology = TopologyCluster
+				d.Backup.Schedule = "@daily"
+			},
+			message: "not supported for the cluster topology",
+		},
+		{
+			name:    "restore path",
+			mutate:  func(d *DeploymentSettings) { d.Restore.File = "../etc/dump.rdb" },
+			message: "invalid restore file",
+		},
+		{
+			name: "replicated restore from the backup volume",
+			mutate: func(d *DeploymentSettings) {
+				d.Topology = TopologyReplicated
+				d.Restore.File = "redis-20260101T030000Z.rdb"
+			},
+			message: "needs a single redis pod",
+		},
+		{
+			name:    "bad access mode",
+			mutate:  func(d *DeploymentSettings) { d.Storage.AccessMode = "ReadWriteSometimes" },
+			message: "access-mode",
+		},
+		{
+			name:    "unknown output",
+			mutate:  func(d *DeploymentSettings) { d.Output = "jsonnet" },
+			message: "invalid deployment output",
+		},
+		{
+			name:    "image tag without digest",
+			mutate:  func(d *DeploymentSettings) { d.Image.Tag = "8.10.1-alpine" },
+			message: "needs its digest",
+		},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			settings := defaultDeploymentSettings()
+			if test.mutate != nil {
+				test.mutate(settings)
+			}
+			err := settings.validate(test.maxMemory)
+			if test.message == "" {
+				if err != nil {
+					t.Fatalf("validate: %v", err)
+				}
+				return
+			}
+			if err == nil || !strings.Contains(err.Error(), test.message) {
+				t.Fatalf("validate error = %v, want %q", err, test.message)
+			}
+		})
+	}
+}
+
+func TestParseMemoryUnits(t *testing.T) {
+	for quantity, want := range map[string]int64{
+		"1024":  1024,
+		"64Mi":  64 << 20,
+		"1.5Gi": 3 << 29,
+		"1G":    1e9,
+		"500k":  500e3,
+	} {
+		got, err := parseKubernetesMemory(quantity)
+		if err != nil || got != want {
+			t.Errorf("parseKubernetesMemory(%q) = %d, %v; want %d", quantity, got, err, want)
+		}
+	}
+	for value, want := range map[string]int64{
+		"100":   100,
+		"1k":    1000,
+		"1kb":   1024,
+		"200MB": 200 << 20,
+		"2g":    2e9,
+	} {
+		got, err := parseRedisMemory(value)
+		if err != nil || got != want {
+			t.Errorf("parseRedisMemory(%q) = %d, %v; want %d", value, got, err, want)
+		}
+	}
+}
+
+func TestDeploymentSettingsClusterReplicasOverride(t *testing.T) {
+	var d DeploymentSettings
+	if err := yaml.Unmarshal([]byte(`
+topology: cluster
+cluster:
+  shards: 5
+  replicas-per-shard: 0
+`), &d); err != nil {
+		t.Fatal(err)
+	}
+	resolved, err := d.forEnvironment("test")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got := resolved.RedisReplicas(); got != 5 {
+		t.Fatalf("cluster pods = %d, want 5 primaries without replicas", got)
+	}
+	if got := defaultDeploymentSettings().Cluster.ShardReplicas(); got != 1 {
+		t.Fatalf("default replicas-per-shard = %d after an override, want 1", got)
+	}
+}
+
+func TestDeploymentSettingsAllEnvironments(t *testing.T) {
+	d := DeploymentSettings{
+		Metrics:      MetricsSettings{Enabled: true},
+		Environments: map[string]yaml.Node{"production": yamlNode(t, "metrics:\n  enabled: false")},
+	}
+	all, err := d.allEnvironments()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(all) != 2 {
+		t.Fatalf("resolved %d environments, want shared and production", len(all))
+	}
+	enabled := 0
+	for _, settings := range all {
+		if settings.Metrics.Enabled {
+			enabled++
+		}
+	}
+	if enabled != 1 {
+		t.Fatalf("metrics enabled in %d resolutions, want only the shared one", enabled)
+	}
+}
+
+func TestNetworkPolicyDependentsAndFallback(t *testing.T) {
+	var d DeploymentSettings
+	if err := yaml.Unmarshal([]byte(`
+network-policy:
+  dependents:
+    - api
+    - app: worker
+      namespace: jobs
+`), &d); err != nil {
+		t.Fatal(err)
+	}
+	resolved, err := d.forEnvironment("test")
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := []NetworkPolicyPeer{{App: "api"}, {App: "worker", Namespace: "jobs"}}
+	if !slices.Equal(resolved.NetworkPolicy.Dependents, want) {
+		t.Errorf("dependents = %+v, want %+v", resolved.NetworkPolicy.Dependents, want)
+	}
+
+	if got := resolved.NetworkPolicy.Fallback; got != NetworkPolicyFallbackClients {
+		t.Errorf("default fallback = %q, want clients", got)
+	}
+}
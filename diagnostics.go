@@ -0,0 +1,87 @@
+package main
+
+// diagnostics.go — redis startup warnings turned into advice.
+//
+// Redis warns about host settings it does not like (memory overcommit,
+// transparent huge pages, ...) once, at startup, in the middle of its banner.
+// The log writer collects every warning logged before redis is ready; known
+// ones get a remediation. The runtime logs them once redis is up and returns
+// them in the status of its start response, so they show in the codefly CLI.
+// Neither the Init response nor GetAgentInformation can carry them: Init
+// returns before redis starts, and the agent information describes the agent,
+// not a running redis.
+
+import (
+	"regexp"
+	"strings"
+)
+
+// startupDiagnostic is one warning redis logged while starting.
+type startupDiagnostic struct {
+	// Code names a known warning; empty for the others.
+	Code    string
+	Message string
+	// Remediation is the advice for a known warning.
+	Remediation string
+}
+
+func (d startupDiagnostic) String() string {
+	if d.Remediation == "" {
+		return d.Message
+	}
+	return d.Message + " — " + d.Remediation
+}
+
+var knownStartupWarnings = []struct {
+	code        string
+	pattern     *regexp.Regexp
+	remediation string
+}{
+	{
+		code:    "overcommit-memory",
+		pattern: regexp.MustCompile(`(?i)memory overcommit must be enabled|overcommit_memory is set to 0`),
+		remediation: "background saves and replication can fail under low memory: run `sysctl vm.overcommit_memory=1` " +
+			"on the host (Docker Desktop: in its VM) and add it to /etc/sysctl.conf",
+	},
+	{
+		code:    "transparent-huge-pages",
+		pattern: regexp.MustCompile(`(?i)transparent huge pages`),
+		remediation: "THP causes latency spikes and memory bloat: run " +
+			"`echo madvise > /sys/kernel/mm/transparent_hugepage/enabled` on the host",
+	},
+	{
+		code:        "tcp-backlog",
+		pattern:     regexp.MustCompile(`(?i)TCP backlog setting of \d+ cannot be enforced`),
+		remediation: "raise the host's somaxconn: `sysctl net.core.somaxconn=1024`",
+	},
+	{
+		code:    "no-config-file",
+		pattern: regexp.MustCompile(`(?i)no config file specified`),
+		remediation: "expected: the agent passes redis directives on the command line; " +
+			"set them in the service settings rather than a redis.conf",
+	},
+	{
+		code:        "no-memory-limit",
+		pattern:     regexp.MustCompile(`(?i)32 bit instance detected but no memory limit set`),
+		remediation: "set maxmemory in the service settings",
+	},
+}
+
+// diagnose maps a warning line to its advice.
+func diagnose(msg string) startupDiagnostic {
+	for _, known := range knownStartupWarnings {
+		if known.pattern.MatchString(msg) {
+			return startupDiagnostic{Code: known.code, Message: msg, Remediation: known.remediation}
+		}
+	}
+	return startupDiagnostic{Message: msg}
+}
+
+// startupDiagnosticsMessage is the start status message listing diagnostics.
+func startupDiagnosticsMessage(diagnostics []startupDiagnostic) string {
+	lines := make([]string, 0, len(diagnostics))
+	for _, d := range diagnostics {
+		lines = append(lines, d.String())
+	}
+	return "redis warned about its environment when it started: " + strings.Join(lines, "; ")
+}
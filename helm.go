@@ -0,0 +1,302 @@
+package main
+
+// helm.go — a Helm chart equivalent to the kustomize bundle.
+//
+// Some platforms only install Helm charts. With `deployment.output: helm`,
+// Deploy still renders and validates the kustomize bundle, then writes a chart
+// next to it. The chart's templates are the kustomize base templates rendered
+// with Helm value references in place of the tunable parameters, so the two
+// outputs cannot drift apart: installed with its default values, the chart
+// produces the kustomize base.
+//
+// The chart follows the restricted-portable profile: it never carries the
+// password, only a reference to an existing Secret. Redis is addressed through
+// the namespace the release is installed in, which must be the namespace the
+// deployment was planned for so dependents' connection details stay valid.
+// The topology is fixed when the chart is generated.
+
+import (
+	"bytes"
+	"fmt"
+	"os"
+	"path"
+	"path/filepath"
+	"strings"
+	"text/template"
+
+	builderv0 "github.com/codefly-dev/core/generated/go/codefly/services/builder/v0"
+	"github.com/codefly-dev/core/resources"
+	"gopkg.in/yaml.v3"
+)
+
+const kustomizeBaseTemplates = "templates/deployment/kustomize/base"
+
+// helmChart is the Chart.yaml of the generated chart.
+type helmChart struct {
+	APIVersion  string `yaml:"apiVersion"`
+	Name        string `yaml:"name"`
+	Description string `yaml:"description"`
+	Type        string `yaml:"type"`
+	Version     string `yaml:"version"`
+	AppVersion  string `yaml:"appVersion"`
+}
+
+// helmValues are the chart's default values, taken from the deployment.
+type helmValues struct {
+	Image    string `yaml:"image"`
+	Topology string `yaml:"topology"`
+	Replicas int    `yaml:"replicas,omitempty"`
+	// Auth is only set when redis requires a password.
+	Auth      *helmAuthValues   `yaml:"auth,omitempty"`
+	Resources ResourceSettings  `yaml:"resources"`
+	Storage   helmStorageValues `yaml:"storage"`
+}
+
+type helmAuthValues struct {
+	ExistingSecret helmSecretKeyValues `yaml:"existingSecret"`
+}
+
+type helmSecretKeyValues struct {
+	Name string `yaml:"name"`
+	Key  string `yaml:"key"`
+}
+
+type helmStorageValues struct {
+	Size         string `yaml:"size"`
+	StorageClass string `yaml:"storageClass"`
+	AccessMode   string `yaml:"accessMode"`
+}
+
+var helmValueComments = map[string]string{
+	"image":     "Redis image, pinned by digest when the agent ships one.",
+	"topology":  "Fixed when the chart is generated: regenerate it with codefly to change the topology.",
+	"replicas":  "Redis pods, primary included.",
+	"auth":      "Secret holding the redis password. Create it before installing the chart.",
+	"resources": "Requests and limits of the redis container.",
+	"storage":   "Data volume claim of each redis pod. An empty storageClass uses the cluster default.",
+}
+
+// helmTemplateData mirrors the data the kustomize templates are rendered with.
+type helmTemplateData struct {
+	Name       string
+	Namespace  string
+	Image      string
+	Restricted bool
+	Deployment helmTemplateDeployment
+}
+
+type helmTemplateDeployment struct {
+	Parameters *deploymentTemplateParameters
+}
+
+const helmStorageClassReference = "{{ .Values.storage.storageClass }}"
+
+// writeHelmChart writes the chart of the deployment of img rendered with
+// parameters to directory.
+func (s *Builder) writeHelmChart(directory string, img *resources.DockerImage, parameters *deploymentTemplateParameters) error {
+	name := s.Identity.Name
+	values := helmValues{
+		Image:     imageReference(img),
+		Topology:  parameters.Topology,
+		Resources: parameters.Resources,
+		Storage: helmStorageValues{
+			Size:         parameters.Storage.Size,
+			StorageClass: parameters.Storage.StorageClass,
+			AccessMode:   parameters.Storage.AccessMode,
+		},
+	}
+	if parameters.Replicated() {
+		values.Replicas = parameters.Replicas
+	}
+	switch {
+	case parameters.PasswordReference != nil:
+		values.Auth = &helmAuthValues{ExistingSecret: helmSecretKeyValues{
+			Name: parameters.PasswordReference.GetName(),
+			Key:  parameters.PasswordReference.GetKey(),
+		}}
+	case s.redisPassword != "":
+		// The Secret the kustomize overlay would have created.
+		values.Auth = &helmAuthValues{ExistingSecret: helmSecretKeyValues{Name: name + "-secret", Key: "REDIS_PASSWORD"}}
+	}
+
+	templates, err := renderHelmTemplates(name, helmTemplateParameters(parameters, values))
+	if err != nil {
+		return err
+	}
+	templates["stateful-set.yaml"] = helmTopologyGuard(values.Topology) + templates["stateful-set.yaml"]
+
+	chart := helmChart{
+		APIVersion:  "v2",
+		Name:        name,
+		Description: "Redis deployed by codefly.",
+		Type:        "application",
+		Version:     helmChartVersion(s.Identity.Version),
+		AppVersion:  img.Tag,
+	}
+	chartYAML, err := yaml.Marshal(chart)
+	if err != nil {
+		return fmt.Errorf("encode Chart.yaml: %w", err)
+	}
+	valuesYAML, err := encodeHelmValues(values)
+	if err != nil {
+		return err
+	}
+
+	if err = os.RemoveAll(directory); err != nil {
+		return err
+	}
+	if err = os.MkdirAll(filepath.Join(directory, "templates"), 0o755); err != nil {
+		return err
+	}
+	files := map[string][]byte{
+		"Chart.yaml":  chartYAML,
+		"values.yaml": valuesYAML,
+	}
+	for file, content := range templates {
+		files[path.Join("templates", file)] = []byte(content)
+	}
+	for file, content := range files {
+		if err = os.WriteFile(filepath.Join(directory, filepath.FromSlash(file)), content, 0o644); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// helmTemplateParameters copies parameters with the values exposed by the
+// chart replaced by references to them.
+func helmTemplateParameters(parameters *deploymentTemplateParameters, values helmValues) *deploymentTemplateParameters {
+	chart := *parameters
+	chart.Resources = ResourceSettings{
+		Requests: ResourceQuantities{CPU: "{{ .Values.resources.requests.cpu }}", Memory: "{{ .Values.resources.requests.memory }}"},
+		Limits:   ResourceQuantities{CPU: "{{ .Values.resources.limits.cpu }}", Memory: "{{ .Values.resources.limits.memory }}"},
+	}
+	chart.Storage = StorageSettings{
+		Size:         "{{ .Values.storage.size }}",
+		StorageClass: helmStorageClassReference,
+		AccessMode:   "{{ .Values.storage.accessMode }}",
+	}
+	if values.Auth != nil {
+		chart.PasswordReference = &builderv0.KubernetesSecretKeyReference{
+			Name: "{{ .Values.auth.existingSecret.name }}",
+			Key:  "{{ .Values.auth.existingSecret.key }}",
+		}
+	}
+	if values.Replicas > 0 {
+		chart.ReplicaCount = "{{ .Values.replicas }}"
+	}
+	// Script sources and stream names are data, not chart templates.
+	chart.Scripts = make([]scriptFile, 0, len(parameters.Scripts))
+	for _, script := range parameters.Scripts {
+		script.Content = helmLiteral(script.Content)
+		chart.Scripts = append(chart.Scripts, script)
+	}
+	chart.StreamProvisioning = make([]string, 0, len(parameters.StreamProvisioning))
+	for _, line := range parameters.StreamProvisioning {
+		chart.StreamProvisioning = append(chart.StreamProvisioning, helmLiteral(line))
+	}
+	return &chart
+}
+
+// helmLiteral escapes the template delimiters of text for Helm.
+func helmLiteral(text string) string {
+	return strings.ReplaceAll(text, "{{", `{{ "{{" }}`)
+}
+
+// renderHelmTemplates renders the kustomize base resources as chart
+// templates, keyed by file name.
+func renderHelmTemplates(name string, parameters *deploymentTemplateParameters) (map[string]string, error) {
+	data := helmTemplateData{
+		Name:       name,
+		Namespace:  "{{ .Release.Namespace }}",
+		Image:      "{{ .Values.image }}",
+		Restricted: true,
+		Deployment: helmTemplateDeployment{Parameters: parameters},
+	}
+	kustomization, err := renderBaseTemplate("kustomization.yaml", data)
+	if err != nil {
+		return nil, err
+	}
+	var resources struct {
+		Resources []string `yaml:"resources"`
+	}
+	if err = yaml.Unmarshal([]byte(kustomization), &resources); err != nil {
+		return nil, fmt.Errorf("parse kustomization.yaml: %w", err)
+	}
+	templates := map[string]string{}
+	for _, resource := range resources.Resources {
+		content, err := renderBaseTemplate(resource, data)
+		if err != nil {
+			return nil, err
+		}
+		templates[resource] = helmOptionalStorageClass(content)
+	}
+	return templates, nil
+}
+
+func renderBaseTemplate(file string, data helmTemplateData) (string, error) {
+	source, err := deploymentFS.ReadFile(path.Join(kustomizeBaseTemplates, file+".tmpl"))
+	if err != nil {
+		return "", err
+	}
+	tmpl, err := template.New(file).Option("missingkey=error").Parse(string(source))
+	if err != nil {
+		return "", fmt.Errorf("parse %s: %w", file, err)
+	}
+	var out bytes.Buffer
+	if err = tmpl.Execute(&out, data); err != nil {
+		return "", fmt.Errorf("render %s: %w", file, err)
+	}
+	return out.String(), nil
+}
+
+// helmOptionalStorageClass drops storageClassName from claims when the
+// storageClass value is empty, as the kustomize templates do.
+func helmOptionalStorageClass(content string) string {
+	lines := strings.SplitAfter(content, "\n")
+	var out strings.Builder
+	for _, line := range lines {
+		trimmed := strings.TrimSpace(line)
+		if trimmed != "storageClassName: "+helmStorageClassReference {
+			out.WriteString(line)
+			continue
+		}
+		indent := line[:len(line)-len(strings.TrimLeft(line, " "))]
+		fmt.Fprintf(&out, "%s{{- with .Values.storage.storageClass }}\n%sstorageClassName: {{ . }}\n%s{{- end }}\n", indent, indent, indent)
+	}
+	return out.String()
+}
+
+// helmTopologyGuard fails the install when the topology value no longer
+// matches the topology the chart was generated for.
+func helmTopologyGuard(topology string) string {
+	return fmt.Sprintf("{{- if ne .Values.topology %q }}{{ fail %q }}{{- end -}}\n",
+		topology, "this chart was generated for the "+topology+" topology: regenerate it with codefly to change it")
+}
+
+func encodeHelmValues(values helmValues) ([]byte, error) {
+	var document yaml.Node
+	if err := document.Encode(values); err != nil {
+		return nil, fmt.Errorf("encode values.yaml: %w", err)
+	}
+	for i := 0; i+1 < len(document.Content); i += 2 {
+		key := document.Content[i]
+		key.HeadComment = helmValueComments[key.Value]
+	}
+	document.HeadComment = "Default values, as planned by codefly for this environment."
+	var out bytes.Buffer
+	encoder := yaml.NewEncoder(&out)
+	encoder.SetIndent(2)
+	if err := encoder.Encode(&document); err != nil {
+		return nil, fmt.Errorf("encode values.yaml: %w", err)
+	}
+	return out.Bytes(), nil
+}
+
+// helmChartVersion uses the service version, which must be SemVer for Helm.
+func helmChartVersion(version string) string {
+	if version == "" {
+		return "0.1.0"
+	}
+	return strings.TrimPrefix(version, "v")
+}
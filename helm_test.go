@@ -0,0 +1,219 @@
+package main
+
+import (
+	"errors"
+	"maps"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"reflect"
+	"slices"
+	"strings"
+	"testing"
+	"text/template"
+	"text/template/parse"
+
+	builderv0 "github.com/codefly-dev/core/generated/go/codefly/services/builder/v0"
+	"gopkg.in/yaml.v3"
+)
+
+// helmChartFuncs are the Helm template functions the generated charts use.
+var helmChartFuncs = template.FuncMap{
+	"fail": func(message string) (string, error) { return "", errors.New(message) },
+}
+
+func TestHelmChartValueReferencesResolve(t *testing.T) {
+	tests := []struct {
+		name     string
+		topology string
+		password *builderv0.KubernetesSecretKeyReference
+	}{
+		{name: "standalone without auth", topology: TopologyStandalone},
+		{
+			name:     "replicated with auth",
+			topology: TopologyReplicated,
+			password: &builderv0.KubernetesSecretKeyReference{Name: "redis-credentials", Key: "password"},
+		},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			builder, _ := newDeploymentTestBuilder(t)
+			parameters := newDeploymentTemplateParameters()
+			parameters.Topology = test.topology
+			parameters.PasswordReference = test.password
+			chart := filepath.Join(t.TempDir(), "redis")
+			if err := builder.writeHelmChart(chart, image, parameters); err != nil {
+				t.Fatal(err)
+			}
+			var values map[string]any
+			if err := yaml.Unmarshal([]byte(readDeploymentFile(t, chart, "values.yaml")), &values); err != nil {
+				t.Fatal(err)
+			}
+			assertHelmValueReferences(t, chart, values)
+			_, auth := values["auth"]
+			if auth != (test.password != nil) {
+				t.Errorf("values.yaml auth = %v, want it only with a password reference", values["auth"])
+			}
+		})
+	}
+}
+
+// assertHelmValueReferences checks, as helm template would fail on, that
+// every .Values reference of the chart's templates resolves in values, and
+// that every value is used by a template.
+func assertHelmValueReferences(t *testing.T, chart string, values map[string]any) {
+	t.Helper()
+	references := helmValueReferences(t, chart)
+	for _, reference := range slices.Sorted(maps.Keys(references)) {
+		if _, ok := lookupHelmValue(values, reference); !ok {
+			t.Errorf(".Values.%s referenced by %s is not in values.yaml", reference, strings.Join(references[reference], ", "))
+		}
+	}
+	for _, leaf := range helmValueLeaves("", values) {
+		used := false
+		for reference := range references {
+			if leaf == reference || strings.HasPrefix(leaf, reference+".") {
+				used = true
+				break
+			}
+		}
+		if !used {
+			t.Errorf("values.yaml sets %s but no template uses it", leaf)
+		}
+	}
+}
+
+// helmValueReferences are the .Values paths the chart's templates reference,
+// with the templates referencing them.
+func helmValueReferences(t *testing.T, chart string) map[string][]string {
+	t.Helper()
+	entries, err := os.ReadDir(filepath.Join(chart, "templates"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	references := map[string][]string{}
+	for _, entry := range entries {
+		source, err := os.ReadFile(filepath.Join(chart, "templates", entry.Name()))
+		if err != nil {
+			t.Fatal(err)
+		}
+		tmpl, err := template.New(entry.Name()).Funcs(helmChartFuncs).Parse(string(source))
+		if err != nil {
+			t.Fatalf("parse chart template %s: %v", entry.Name(), err)
+		}
+		for _, defined := range tmpl.Templates() {
+			walkHelmValueReferences(defined.Root, func(reference string) {
+				if !slices.Contains(references[reference], entry.Name()) {
+					references[reference] = append(references[reference], entry.Name())
+				}
+			})
+		}
+	}
+	return references
+}
+
+func walkHelmValueReferences(node parse.Node, found func(string)) {
+	switch node := node.(type) {
+	case *parse.ListNode:
+		if node == nil {
+			return
+		}
+		for _, child := range node.Nodes {
+			walkHelmValueReferences(child, found)
+		}
+	case *parse.ActionNode:
+		walkHelmValueReferences(node.Pipe, found)
+	case *parse.IfNode:
+		walkHelmBranch(&node.BranchNode, found)
+	case *parse.RangeNode:
+		walkHelmBranch(&node.BranchNode, found)
+	case *parse.WithNode:
+		walkHelmBranch(&node.BranchNode, found)
+	case *parse.PipeNode:
+		if node == nil {
+			return
+		}
+		for _, command := range node.Cmds {
+			walkHelmValueReferences(command, found)
+		}
+	case *parse.CommandNode:
+		for _, argument := range node.Args {
+			walkHelmValueReferences(argument, found)
+		}
+	case *parse.FieldNode:
+		if len(node.Ident) > 1 && node.Ident[0] == "Values" {
+			found(strings.Join(node.Ident[1:], "."))
+		}
+	}
+}
+
+func walkHelmBranch(branch *parse.BranchNode, found func(string)) {
+	walkHelmValueReferences(branch.Pipe, found)
+	walkHelmValueReferences(branch.List, found)
+	walkHelmValueReferences(branch.ElseList, found)
+}
+
+func lookupHelmValue(values map[string]any, reference string) (any, bool) {
+	var value any = values
+	for _, key := range strings.Split(reference, ".") {
+		object, ok := value.(map[string]any)
+		if !ok {
+			return nil, false
+		}
+		if value, ok = object[key]; !ok {
+			return nil, false
+		}
+	}
+	return value, true
+}
+
+func helmValueLeaves(prefix string, values map[string]any) []string {
+	var leaves []string
+	for key, value := range values {
+		path := key
+		if prefix != "" {
+			path = prefix + "." + key
+		}
+		if object, ok := value.(map[string]any); ok && len(object) > 0 {
+			leaves = append(leaves, helmValueLeaves(path, object)...)
+			continue
+		}
+		leaves = append(leaves, path)
+	}
+	return leaves
+}
+
+// assertHelmTemplate renders the chart with helm, when it is installed, and
+// compares each manifest with the one rendered by the test.
+func assertHelmTemplate(t *testing.T, chart string, manifests map[string]string) {
+	t.Helper()
+	if _, err := exec.LookPath("helm"); err != nil {
+		t.Log("helm is not installed: only the in-process render is checked")
+		return
+	}
+	output, err := exec.Command("helm", "template", "redis", chart, "--namespace", "codefly-test").CombinedOutput()
+	if err != nil {
+		t.Fatalf("helm template: %v\n%s", err, output)
+	}
+	rendered := map[string]string{}
+	for _, document := range strings.Split(string(output), "\n---\n") {
+		source, manifest, found := strings.Cut(strings.TrimPrefix(document, "---\n"), "\n")
+		if !found || !strings.HasPrefix(source, "# Source: ") {
+			continue
+		}
+		rendered[filepath.Base(strings.TrimPrefix(source, "# Source: "))] = manifest
+	}
+	for name, manifest := range manifests {
+		if strings.TrimSpace(manifest) == "" {
+			continue
+		}
+		helmManifest, ok := rendered[name]
+		if !ok {
+			t.Errorf("helm template did not render %s", name)
+			continue
+		}
+		if !reflect.DeepEqual(parseManifest(t, helmManifest), parseManifest(t, manifest)) {
+			t.Errorf("helm template renders %s differently:\n%s", name, helmManifest)
+		}
+	}
+}
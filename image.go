@@ -0,0 +1,447 @@
+package main
+
+// image.go — the redis image a service runs.
+//
+// The agent ships a default image (`image` in main.go). A non-dry-run
+// Builder.Upgrade pins a newer tag and digest in redis.lock.yaml next to
+// service.codefly.yaml; once the lockfile pins it, the runtime, the deployment
+// and the audits all use the pinned image.
+//
+// The sidecar and job images deployed next to redis ship by tag only. The
+// first deployment using one resolves its digest from the registry and pins
+// it in the lockfile, so a deployment's images only change through it.
+//
+// The `image` settings then override parts of that reference, typically to
+// pull from a registry mirror:
+//
+//	image:
+//	  registry: mirror.example.com
+//	deployment:
+//	  environments:
+//	    production:
+//	      image:
+//	        registry: registry.prod.example.com
+//
+// Whatever is overridden, the resulting image is always pinned by digest.
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"regexp"
+	"strconv"
+	"strings"
+
+	"github.com/codefly-dev/core/agents/services/upgrade"
+	"github.com/codefly-dev/core/resources"
+	"gopkg.in/yaml.v3"
+)
+
+const imageLockFile = "redis.lock.yaml"
+
+const imageLockHeader = "# Pinned by the redis agent. Edit with care: each digest must match its tag.\n"
+
+// imageLock is the content of redis.lock.yaml.
+type imageLock struct {
+	// Image is the redis image; without it the agent's default runs.
+	Image *lockedImage `yaml:"image,omitempty"`
+	// Sidecars pin the sidecar and job images, by sidecar key.
+	Sidecars map[string]lockedImage `yaml:",inline"`
+}
+
+type lockedImage struct {
+	Name   string `yaml:"name"`
+	Tag    string `yaml:"tag"`
+	Digest string `yaml:"digest"`
+}
+
+func lockImage(img *resources.DockerImage) lockedImage {
+	return lockedImage{Name: img.Name, Tag: img.Tag, Digest: img.Digest}
+}
+
+func (l lockedImage) validate(path, key string) error {
+	if l.Name == "" || l.Tag == "" || !imageDigest.MatchString(l.Digest) {
+		return fmt.Errorf("invalid %s %s: want an image name, tag and sha256 digest", path, key)
+	}
+	return nil
+}
+
+func (l lockedImage) dockerImage() *resources.DockerImage {
+	return &resources.DockerImage{Name: l.Name, Tag: l.Tag, Digest: l.Digest}
+}
+
+// sidecar is an image deployed next to redis.
+type sidecar struct {
+	// Key names the image in redis.lock.yaml.
+	Key string
+	// Default is the agent's image, by tag until a deployment pins it.
+	Default *resources.DockerImage
+	// Deployed reports whether a deployment with settings runs the image.
+	Deployed func(settings *DeploymentSettings) bool
+}
+
+// Sidecar keys in redis.lock.yaml.
+const (
+	exporterSidecar     = "exporter"
+	backupClientSidecar = "backup-client"
+	kubectlSidecar      = "kubectl"
+)
+
+// sidecars are the images deployed next to redis, in the order audits list
+// them.
+var sidecars = []sidecar{
+	{
+		Key:      exporterSidecar,
+		Default:  exporterImage,
+		Deployed: func(settings *DeploymentSettings) bool { return settings.Metrics.Enabled },
+	},
+	{
+		Key:      backupClientSidecar,
+		Default:  backupClientImage,
+		Deployed: (*DeploymentSettings).BackupS3Enabled,
+	},
+	{
+		Key:      kubectlSidecar,
+		Default:  kubectlImage,
+		Deployed: (*DeploymentSettings).Replicated,
+	},
+}
+
+func findSidecar(key string) (sidecar, bool) {
+	for _, candidate := range sidecars {
+		if candidate.Key == key {
+			return candidate, true
+		}
+	}
+	return sidecar{}, false
+}
+
+var imageDigest = regexp.MustCompile(`^sha256:[0-9a-f]{64}$`)
+
+// ImageSettings override parts of the redis image reference.
+type ImageSettings struct {
+	// Registry replaces the registry host, e.g. mirror.example.com.
+	Registry string `yaml:"registry,omitempty"`
+	// Repository replaces the repository path, e.g. library/redis.
+	Repository string `yaml:"repository,omitempty"`
+	// Tag replaces the tag; it needs the matching Digest.
+	Tag string `yaml:"tag,omitempty"`
+	// Digest pins the manifest, e.g. sha256:9d31...
+	Digest string `yaml:"digest,omitempty"`
+}
+
+// PinsVersion reports whether the settings choose the tag or digest, leaving
+// Upgrade nothing to pin.
+func (o ImageSettings) PinsVersion() bool {
+	return o.Tag != "" || o.Digest != ""
+}
+
+// validate rejects overrides that do not form an image reference or would
+// leave the tag unpinned.
+func (o ImageSettings) validate() error {
+	if o.Registry != "" && !validRegistryHost(o.Registry) {
+		return fmt.Errorf("invalid image registry %q", o.Registry)
+	}
+	if strings.ContainsAny(o.Repository, ":@ ") {
+		return fmt.Errorf("invalid image repository %q", o.Repository)
+	}
+	if strings.ContainsAny(o.Tag, ":@/ ") {
+		return fmt.Errorf("invalid image tag %q", o.Tag)
+	}
+	if o.Digest != "" && !imageDigest.MatchString(o.Digest) {
+		return fmt.Errorf("invalid image digest %q: want sha256:<64 hex digits>", o.Digest)
+	}
+	if o.Tag != "" && o.Digest == "" {
+		return fmt.Errorf("image tag %q needs its digest: images are always pinned", o.Tag)
+	}
+	return nil
+}
+
+// validRegistryHost accepts host and host:port.
+func validRegistryHost(registry string) bool {
+	host, port, found := strings.Cut(registry, ":")
+	if host == "" || strings.ContainsAny(host, "/@ ") {
+		return false
+	}
+	if !found {
+		return true
+	}
+	_, err := strconv.ParseUint(port, 10, 16)
+	return err == nil
+}
+
+// splitImageName splits an image name into its registry host and repository,
+// applying Docker Hub's defaults: redis is docker.io/library/redis.
+func splitImageName(name string) (string, string) {
+	host, repository, found := strings.Cut(name, "/")
+	if !found || (!strings.ContainsAny(host, ".:") && host != "localhost") {
+		host, repository = "docker.io", name
+	}
+	if host == "docker.io" {
+		host = "registry-1.docker.io"
+		if !strings.Contains(repository, "/") {
+			repository = "library/" + repository
+		}
+	}
+	return host, repository
+}
+
+// apply returns base with the overrides applied.
+func (o ImageSettings) apply(base *resources.DockerImage) (*resources.DockerImage, error) {
+	if err := o.validate(); err != nil {
+		return nil, err
+	}
+	if o == (ImageSettings{}) {
+		return base, nil
+	}
+	overridden := *base
+	if o.Registry != "" || o.Repository != "" {
+		registry, repository := splitImageName(base.Name)
+		if o.Registry != "" {
+			registry = o.Registry
+		}
+		if o.Repository != "" {
+			repository = strings.Trim(o.Repository, "/")
+		}
+		overridden.Name = registry + "/" + repository
+	}
+	if o.Tag != "" {
+		overridden.Tag = o.Tag
+	}
+	if o.Digest != "" {
+		overridden.Digest = o.Digest
+	}
+	if !imageDigest.MatchString(overridden.Digest) {
+		return nil, fmt.Errorf("image %s is not pinned by digest: set image.digest", overridden.FullName())
+	}
+	return &overridden, nil
+}
+
+// redisImage is the image this service runs: the lockfile's when one exists,
+// the agent's default otherwise, with the image settings applied.
+func (s *Service) redisImage() (*resources.DockerImage, error) {
+	base, err := s.pinnedImage()
+	if err != nil {
+		return nil, err
+	}
+	return s.Image.apply(base)
+}
+
+// deployedImage is the image deployed with settings, resolved for one
+// environment.
+func (s *Service) deployedImage(settings *DeploymentSettings) (*resources.DockerImage, error) {
+	img, err := s.redisImage()
+	if err != nil {
+		return nil, err
+	}
+	return settings.Image.apply(img)
+}
+
+// pinnedImage is the image before the image settings apply.
+func (s *Service) pinnedImage() (*resources.DockerImage, error) {
+	lock, err := s.imageLock()
+	if err != nil || lock.Image == nil {
+		return image, err
+	}
+	return lock.Image.dockerImage(), nil
+}
+
+// sidecarImage is the sidecar image under key: the lockfile's when it pins
+// one, the agent's default by tag otherwise.
+func (s *Service) sidecarImage(key string) (*resources.DockerImage, error) {
+	lock, err := s.imageLock()
+	if err != nil {
+		return nil, err
+	}
+	if locked, ok := lock.Sidecars[key]; ok {
+		return locked.dockerImage(), nil
+	}
+	deployed, _ := findSidecar(key)
+	return deployed.Default, nil
+}
+
+// pinSidecarImages returns the sidecar images a deployment with settings
+// runs, by key and pinned by digest. Images the lockfile does not pin yet are
+// resolved from their registry and pinned, so later deployments pull the
+// same manifests.
+func (s *Builder) pinSidecarImages(ctx context.Context, settings *DeploymentSettings) (map[string]*resources.DockerImage, error) {
+	lock, err := s.imageLock()
+	if err != nil {
+		return nil, err
+	}
+	pinned := map[string]*resources.DockerImage{}
+	resolved := false
+	for _, deployed := range sidecars {
+		if !deployed.Deployed(settings) {
+			continue
+		}
+		if locked, ok := lock.Sidecars[deployed.Key]; ok {
+			pinned[deployed.Key] = locked.dockerImage()
+			continue
+		}
+		img := *deployed.Default
+		if img.Digest, err = s.resolveDigest(ctx, img.FullName()); err != nil {
+			return nil, fmt.Errorf("cannot resolve the digest of %s: %w", img.FullName(), err)
+		}
+		if !imageDigest.MatchString(img.Digest) {
+			return nil, fmt.Errorf("registry returned an invalid digest %q for %s", img.Digest, img.FullName())
+		}
+		if lock.Sidecars == nil {
+			lock.Sidecars = map[string]lockedImage{}
+		}
+		lock.Sidecars[deployed.Key] = lockImage(&img)
+		pinned[deployed.Key] = &img
+		resolved = true
+	}
+	if resolved {
+		if _, err = writeImageLock(filepath.Join(s.Location, imageLockFile), lock); err != nil {
+			return nil, err
+		}
+	}
+	return pinned, nil
+}
+
+// imageLock is the service's lockfile, empty when there is none.
+func (s *Service) imageLock() (*imageLock, error) {
+	lock, err := readImageLock(filepath.Join(s.Location, imageLockFile))
+	if errors.Is(err, fs.ErrNotExist) {
+		return &imageLock{}, nil
+	}
+	return lock, err
+}
+
+func readImageLock(path string) (*imageLock, error) {
+	content, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	var lock imageLock
+	if err = yaml.Unmarshal(content, &lock); err != nil {
+		return nil, fmt.Errorf("invalid %s: %w", path, err)
+	}
+	if lock.Image != nil {
+		if err = lock.Image.validate(path, "image"); err != nil {
+			return nil, err
+		}
+	}
+	for key, locked := range lock.Sidecars {
+		if _, ok := findSidecar(key); !ok {
+			return nil, fmt.Errorf("invalid %s: unknown image %q", path, key)
+		}
+		if err = locked.validate(path, key); err != nil {
+			return nil, err
+		}
+	}
+	return &lock, nil
+}
+
+// writeImageLock writes lock to path and returns the change as a diff of the
+// lockfile.
+func writeImageLock(path string, lock *imageLock) (string, error) {
+	previous, err := os.ReadFile(path)
+	if err != nil && !errors.Is(err, fs.ErrNotExist) {
+		return "", err
+	}
+	content := bytes.NewBufferString(imageLockHeader)
+	encoder := yaml.NewEncoder(content)
+	encoder.SetIndent(2)
+	if err = encoder.Encode(lock); err != nil {
+		return "", err
+	}
+	if err = os.WriteFile(path, content.Bytes(), 0o644); err != nil {
+		return "", err
+	}
+	return lineDiff(filepath.Base(path), string(previous), content.String()), nil
+}
+
+// applyImageUpgrade pins candidate in the lockfile, unless the service's
+// audit policy fails it on findings the pinned image does not have. It
+// returns the lockfile diff, empty when there is no candidate.
+func (s *Builder) applyImageUpgrade(ctx context.Context, candidate *resources.DockerImage) (string, error) {
+	if s.Image.PinsVersion() {
+		return "", fmt.Errorf("the image tag is pinned in the service settings: change it there")
+	}
+	pinned, err := s.pinnedImage()
+	if err != nil || candidate == nil {
+		return "", err
+	}
+	policy, err := s.auditPolicy()
+	if err != nil {
+		return "", err
+	}
+	// Only what the candidate adds blocks it: findings the pinned image
+	// already has are no reason to stay on it.
+	report, err := s.auditImages(ctx, policy, []string{imageReference(pinned), imageReference(candidate)})
+	if err != nil {
+		return "", err
+	}
+	if introduced := report.Images[1].introducedFailures(report.Images[0]); len(introduced) > 0 {
+		return "", fmt.Errorf("refusing upgrade to %s: it introduces %s", candidate.FullName(), strings.Join(introduced, ", "))
+	}
+	lock, err := s.imageLock()
+	if err != nil {
+		return "", err
+	}
+	locked := lockImage(&resources.DockerImage{Name: pinned.Name, Tag: candidate.Tag, Digest: candidate.Digest})
+	lock.Image = &locked
+	return writeImageLock(filepath.Join(s.Location, imageLockFile), lock)
+}
+
+// upgradeTarget is the image changes move current to, resolved to its
+// digest, or nil when they leave it alone. The digest is resolved where
+// current is pulled from, so a mirror is checked for the candidate; digests
+// do not change across mirrors.
+func (s *Builder) upgradeTarget(ctx context.Context, current *resources.DockerImage, changes []upgrade.Change) (*resources.DockerImage, error) {
+	for _, change := range changes {
+		if change.Name != current.Name || change.To == current.Tag {
+			continue
+		}
+		candidate := &resources.DockerImage{Name: current.Name, Tag: change.To}
+		digest, err := s.resolveDigest(ctx, candidate.FullName())
+		if err != nil {
+			return nil, fmt.Errorf("cannot resolve the digest of %s: %w", candidate.FullName(), err)
+		}
+		candidate.Digest = digest
+		return candidate, nil
+	}
+	return nil, nil
+}
+
+// lineDiff is a minimal diff of two small files: the lines only in before,
+// then the lines only in after.
+func lineDiff(name, before, after string) string {
+	beforeLines := strings.Split(strings.TrimSuffix(before, "\n"), "\n")
+	afterLines := strings.Split(strings.TrimSuffix(after, "\n"), "\n")
+	kept := map[string]bool{}
+	for _, line := range afterLines {
+		kept[line] = true
+	}
+	old := map[string]bool{}
+	var diff strings.Builder
+	fmt.Fprintf(&diff, "--- %s\n+++ %s\n", name, name)
+	for _, line := range beforeLines {
+		old[line] = true
+		if line != "" && !kept[line] {
+			fmt.Fprintf(&diff, "-%s\n", line)
+		}
+	}
+	for _, line := range afterLines {
+		if !old[line] {
+			fmt.Fprintf(&diff, "+%s\n", line)
+		}
+	}
+	return diff.String()
+}
+
+// imageReference is img as the manifests reference it: by digest when one is
+// pinned.
+func imageReference(img *resources.DockerImage) string {
+	if img.Digest != "" {
+		return img.Name + "@" + img.Digest
+	}
+	return img.FullName()
+}
@@ -0,0 +1,333 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+	"testing"
+
+	"github.com/codefly-dev/core/agents/services/upgrade"
+	"github.com/codefly-dev/core/resources"
+	"gopkg.in/yaml.v3"
+)
+
+func TestUpgradeTargetFollowsCoreProposal(t *testing.T) {
+	candidateDigest := "sha256:" + strings.Repeat("b", 64)
+	current := &resources.DockerImage{Name: "mirror.example.com/redis", Tag: "8.8.0-alpine", Digest: "sha256:" + strings.Repeat("a", 64)}
+	builder := NewBuilder()
+	var resolved []string
+	builder.resolveDigest = func(_ context.Context, image string) (string, error) {
+		resolved = append(resolved, image)
+		return candidateDigest, nil
+	}
+
+	candidate, err := builder.upgradeTarget(context.Background(), current, []upgrade.Change{
+		{Name: "oliver006/redis_exporter", From: "v1.67.0-alpine", To: "v1.68.0-alpine"},
+		{Name: current.Name, From: current.Tag, To: "8.10.1-alpine"},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if candidate == nil || candidate.Name != current.Name || candidate.Tag != "8.10.1-alpine" || candidate.Digest != candidateDigest {
+		t.Errorf("candidate = %+v", candidate)
+	}
+	if !slices.Equal(resolved, []string{"mirror.example.com/redis:8.10.1-alpine"}) {
+		t.Errorf("resolved digests of %v, want the candidate's on the mirror", resolved)
+	}
+
+	if candidate, err = builder.upgradeTarget(context.Background(), current, nil); err != nil || candidate != nil {
+		t.Errorf("upgradeTarget without changes = %+v, %v", candidate, err)
+	}
+	builder.resolveDigest = func(context.Context, string) (string, error) { return "", fmt.Errorf("manifest unknown") }
+	changes := []upgrade.Change{{Name: current.Name, From: current.Tag, To: "8.10.1-alpine"}}
+	if _, err = builder.upgradeTarget(context.Background(), current, changes); err == nil || !strings.Contains(err.Error(), "manifest unknown") {
+		t.Errorf("upgradeTarget error = %v, want the registry's", err)
+	}
+}
+
+func TestApplyImageUpgradeGatesOnAudit(t *testing.T) {
+	candidateDigest := "sha256:" + strings.Repeat("b", 64)
+	current := &resources.DockerImage{Name: "mirror.example.com/redis", Tag: "8.8.0-alpine", Digest: "sha256:" + strings.Repeat("a", 64)}
+	candidate := &resources.DockerImage{Name: current.Name, Tag: "8.9.0-alpine", Digest: candidateDigest}
+
+	builder := NewBuilder()
+	builder.Location = t.TempDir()
+	lockPath := filepath.Join(builder.Location, imageLockFile)
+	locked := lockImage(current)
+	if _, err := writeImageLock(lockPath, &imageLock{Image: &locked}); err != nil {
+		t.Fatal(err)
+	}
+	vulnerable := `{"Results":[{"Vulnerabilities":[
+		{"VulnerabilityID":"CVE-2026-0001","Severity":"HIGH"},
+		{"VulnerabilityID":"CVE-2026-0002","Severity":"LOW"}]}]}`
+
+	t.Run("failing audit", func(t *testing.T) {
+		useFakeTrivy(t, candidateDigest, `{"Results":[]}`, vulnerable)
+		_, err := builder.applyImageUpgrade(context.Background(), candidate)
+		if err == nil || !strings.Contains(err.Error(), "introduces CVE-2026-0001 (HIGH)") || strings.Contains(err.Error(), "CVE-2026-0002") {
+			t.Fatalf("applyImageUpgrade error = %v, want a refusal naming CVE-2026-0001", err)
+		}
+		if locked, err := builder.redisImage(); err != nil || locked.Tag != current.Tag {
+			t.Fatalf("refused upgrade changed the lockfile: %+v, %v", locked, err)
+		}
+	})
+
+	t.Run("already in the pinned image", func(t *testing.T) {
+		useFakeTrivy(t, candidateDigest, vulnerable, vulnerable)
+		if _, err := builder.applyImageUpgrade(context.Background(), candidate); err != nil {
+			t.Fatalf("applyImageUpgrade refused findings the pinned image has: %v", err)
+		}
+		if _, err := writeImageLock(lockPath, &imageLock{Image: &locked}); err != nil {
+			t.Fatal(err)
+		}
+	})
+
+	t.Run("accepted by the policy", func(t *testing.T) {
+		policy := "accept:\n  - id: CVE-2026-0001\n    expires: 2999-12-31\n"
+		if err := os.WriteFile(filepath.Join(builder.Location, auditPolicyFile), []byte(policy), 0o644); err != nil {
+			t.Fatal(err)
+		}
+		useFakeTrivy(t, candidateDigest, `{"Results":[]}`, vulnerable)
+		diff, err := builder.applyImageUpgrade(context.Background(), candidate)
+		if err != nil {
+			t.Fatal(err)
+		}
+		for _, expected := range []string{"-  tag: 8.8.0-alpine", "+  tag: 8.9.0-alpine", "+  digest: " + candidateDigest} {
+			if !strings.Contains(diff, expected) {
+				t.Errorf("lockfile diff missing %q:\n%s", expected, diff)
+			}
+		}
+		locked, err := builder.redisImage()
+		if err != nil {
+			t.Fatal(err)
+		}
+		if locked.Name != current.Name || locked.Tag != "8.9.0-alpine" || locked.Digest != candidateDigest {
+			t.Errorf("locked image = %+v", locked)
+		}
+	})
+}
+
+func TestRedisImageDefaultsToAgentImage(t *testing.T) {
+	builder := NewBuilder()
+	builder.Location = t.TempDir()
+	if img, err := builder.redisImage(); err != nil || img != image {
+		t.Fatalf("redisImage = %+v, %v; want the agent default", img, err)
+	}
+	if err := os.WriteFile(filepath.Join(builder.Location, imageLockFile), []byte("image:\n  name: redis\n  tag: 8.8.0-alpine\n"), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := builder.redisImage(); err == nil {
+		t.Fatal("lockfile without a digest accepted")
+	}
+}
+
+func TestDeploymentPinsSidecarImages(t *testing.T) {
+	builder := NewBuilder()
+	builder.Location = t.TempDir()
+	builder.Deployment.Metrics.Enabled = true
+	digest := "sha256:" + strings.Repeat("d", 64)
+	var resolved []string
+	builder.resolveDigest = func(_ context.Context, img string) (string, error) {
+		resolved = append(resolved, img)
+		return digest, nil
+	}
+	lockPath := filepath.Join(builder.Location, imageLockFile)
+	if err := os.WriteFile(lockPath, []byte("image:\n  name: redis\n  tag: 8.8.0-alpine\n  digest: "+image.Digest+"\n"), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	if exporter, err := builder.sidecarImage(exporterSidecar); err != nil || exporter != exporterImage {
+		t.Fatalf("sidecarImage = %+v, %v; want the agent default for a lockfile without one", exporter, err)
+	}
+
+	pinned, err := builder.pinSidecarImages(context.Background(), &builder.Deployment)
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := resources.DockerImage{Name: exporterImage.Name, Tag: exporterImage.Tag, Digest: digest}
+	if exporter := pinned[exporterSidecar]; exporter == nil || *exporter != want {
+		t.Fatalf("pinned exporter = %+v, want %+v", exporter, want)
+	}
+	if !slices.Equal(resolved, []string{exporterImage.FullName()}) {
+		t.Errorf("resolved digests of %v, want the exporter's", resolved)
+	}
+	content, err := os.ReadFile(lockPath)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, expected := range []string{"digest: " + image.Digest, "exporter:\n  name: " + exporterImage.Name, "digest: " + digest} {
+		if !strings.Contains(string(content), expected) {
+			t.Errorf("lockfile misses %q:\n%s", expected, content)
+		}
+	}
+	if exporter, err := builder.sidecarImage(exporterSidecar); err != nil || *exporter != want {
+		t.Fatalf("sidecarImage = %+v, %v; want the pinned %+v", exporter, err, want)
+	}
+	if _, err = builder.pinSidecarImages(context.Background(), &builder.Deployment); err != nil || len(resolved) != 1 {
+		t.Errorf("second deployment resolved %v, %v; want the lockfile's pin", resolved, err)
+	}
+	images, err := builder.deployedImages()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !slices.Contains(images, want.FullName()) {
+		t.Errorf("deployed images %v miss the pinned exporter", images)
+	}
+
+	builder.Deployment.Metrics.Enabled = false
+	if err = os.Remove(lockPath); err != nil {
+		t.Fatal(err)
+	}
+	if pinned, err = builder.pinSidecarImages(context.Background(), &builder.Deployment); err != nil || len(pinned) != 0 {
+		t.Errorf("pinSidecarImages without metrics = %v, %v", pinned, err)
+	}
+	if _, err = os.Stat(lockPath); !errors.Is(err, fs.ErrNotExist) {
+		t.Errorf("deployment without sidecars wrote a lockfile: %v", err)
+	}
+
+	builder.Deployment.Metrics.Enabled = true
+	builder.resolveDigest = func(context.Context, string) (string, error) { return "", fmt.Errorf("manifest unknown") }
+	if _, err = builder.pinSidecarImages(context.Background(), &builder.Deployment); err == nil || !strings.Contains(err.Error(), "manifest unknown") {
+		t.Errorf("pinSidecarImages error = %v, want the registry's", err)
+	}
+
+	for name, lock := range map[string]string{
+		"without a digest": "exporter:\n  name: x\n  tag: y\n",
+		"unknown image":    "sidecar:\n  name: x\n  tag: y\n  digest: " + digest + "\n",
+	} {
+		if err = os.WriteFile(lockPath, []byte(lock), 0o644); err != nil {
+			t.Fatal(err)
+		}
+		if _, err = builder.sidecarImage(exporterSidecar); err == nil {
+			t.Errorf("lockfile with an image %s accepted", name)
+		}
+	}
+}
+
+func TestS3BackupsPinTheBackupClient(t *testing.T) {
+	builder := NewBuilder()
+	builder.Location = t.TempDir()
+	digest := "sha256:" + strings.Repeat("e", 64)
+	builder.resolveDigest = func(context.Context, string) (string, error) { return digest, nil }
+	builder.Deployment.Backup = BackupSettings{
+		Schedule:      "0 3 * * *",
+		RetentionDays: 7,
+		Target:        BackupTargetS3,
+		S3:            BackupS3Settings{Endpoint: "https://s3.example.com", Bucket: "backups", CredentialsSecret: "s3"},
+	}
+	pinned, err := builder.pinSidecarImages(context.Background(), &builder.Deployment)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if client := pinned[backupClientSidecar]; client == nil || client.Name != backupClientImage.Name || client.Digest != digest {
+		t.Fatalf("pinned backup client = %+v", client)
+	}
+	if _, ok := pinned[exporterSidecar]; ok {
+		t.Error("pinned the exporter of a deployment without metrics")
+	}
+	images, err := builder.deployedImages()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !slices.Contains(images, backupClientImage.FullName()) {
+		t.Errorf("deployed images %v miss the backup client", images)
+	}
+}
+
+func TestImageSettingsApply(t *testing.T) {
+	digest := "sha256:" + strings.Repeat("c", 64)
+	base := &resources.DockerImage{Name: "redis", Tag: "8.8.0-alpine", Digest: "sha256:" + strings.Repeat("a", 64)}
+	tests := []struct {
+		name     string
+		settings ImageSettings
+		want     string
+		message  string
+	}{
+		{name: "none", want: "redis@" + base.Digest},
+		{name: "mirror", settings: ImageSettings{Registry: "mirror.example.com"}, want: "mirror.example.com/library/redis@" + base.Digest},
+		{
+			name:     "repository",
+			settings: ImageSettings{Registry: "localhost:5000", Repository: "/cache/redis/"},
+			want:     "localhost:5000/cache/redis@" + base.Digest,
+		},
+		{name: "tag and digest", settings: ImageSettings{Tag: "8.10.1-alpine", Digest: digest}, want: "redis@" + digest},
+		{name: "tag alone", settings: ImageSettings{Tag: "8.10.1-alpine"}, message: "needs its digest"},
+		{name: "short digest", settings: ImageSettings{Digest: "sha256:abc"}, message: "invalid image digest"},
+		{name: "registry path", settings: ImageSettings{Registry: "mirror.example.com/redis"}, message: "invalid image registry"},
+		{name: "registry port", settings: ImageSettings{Registry: "mirror.example.com:http"}, message: "invalid image registry"},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			img, err := test.settings.apply(base)
+			if test.message != "" {
+				if err == nil || !strings.Contains(err.Error(), test.message) {
+					t.Fatalf("apply error = %v, want %q", err, test.message)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+			if got := imageReference(img); got != test.want {
+				t.Errorf("image = %s, want %s", got, test.want)
+			}
+		})
+	}
+
+	unpinned := &resources.DockerImage{Name: "redis", Tag: "8.8.0-alpine"}
+	if _, err := (ImageSettings{Registry: "mirror.example.com"}).apply(unpinned); err == nil {
+		t.Error("image without a digest accepted")
+	}
+}
+
+func TestDeployedImagesFollowImageSettings(t *testing.T) {
+	builder := NewBuilder()
+	builder.Location = t.TempDir()
+	builder.Image = ImageSettings{Registry: "mirror.example.com"}
+	builder.Deployment.Environments = map[string]yaml.Node{
+		"production": {Kind: yaml.MappingNode, Content: []*yaml.Node{
+			{Kind: yaml.ScalarNode, Value: "image"},
+			{Kind: yaml.MappingNode, Content: []*yaml.Node{
+				{Kind: yaml.ScalarNode, Value: "registry"},
+				{Kind: yaml.ScalarNode, Value: "registry.prod.example.com"},
+			}},
+		}},
+	}
+	images, err := builder.deployedImages()
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := []string{
+		"mirror.example.com/library/redis:" + image.Tag,
+		"registry.prod.example.com/library/redis:" + image.Tag,
+	}
+	if strings.Join(images, " ") != strings.Join(want, " ") {
+		t.Errorf("deployedImages = %v, want %v", images, want)
+	}
+
+	builder.Image = ImageSettings{Tag: "8.10.1-alpine", Digest: "sha256:" + strings.Repeat("c", 64)}
+	if _, err := builder.applyImageUpgrade(context.Background(), image); err == nil || !strings.Contains(err.Error(), "pinned in the service settings") {
+		t.Errorf("applyImageUpgrade error = %v, want a refusal to override the settings", err)
+	}
+}
+
+// useFakeTrivy puts a trivy on PATH that reports candidate for images pinned
+// to candidateDigest and current for any other image.
+func useFakeTrivy(t *testing.T, candidateDigest, current, candidate string) {
+	t.Helper()
+	bin := t.TempDir()
+	for name, report := range map[string]string{"current.json": current, "candidate.json": candidate} {
+		if err := os.WriteFile(filepath.Join(bin, name), []byte(report), 0o644); err != nil {
+			t.Fatal(err)
+		}
+	}
+	script := fmt.Sprintf("#!/bin/sh\nfor last; do :; done\ncase \"$last\" in\n  *%s) cat %s/candidate.json ;;\n  *) cat %s/current.json ;;\nesac\n", candidateDigest, bin, bin)
+	if err := os.WriteFile(filepath.Join(bin, "trivy"), []byte(script), 0o755); err != nil {
+		t.Fatal(err)
+	}
+	t.Setenv("PATH", bin+string(os.PathListSeparator)+os.Getenv("PATH"))
+}
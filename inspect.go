@@ -0,0 +1,263 @@
+package main
+
+// inspect.go — looking into the keyspace of the local redis without
+// redis-cli.
+//
+// Runtime.InspectKeyspace walks the keys matching a pattern with SCAN, so it
+// never blocks redis the way KEYS does, and reports each key's type, TTL and
+// memory usage. With Values, it also renders the value the way redis-cli
+// would print it, truncated to the first few elements of collections. The
+// agent's `keys` command (control.go) runs it against the local redis.
+
+import (
+	"context"
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// KeyspaceQuery selects the keys InspectKeyspace reports.
+type KeyspaceQuery struct {
+	// Pattern is a SCAN MATCH glob. Defaults to *.
+	Pattern string
+	// Type keeps the keys of one type (string, list, hash, ...).
+	Type string
+	// DB is the logical database.
+	DB int
+	// Limit is the most keys reported. Defaults to 100.
+	Limit int
+	// Values renders each key's value.
+	Values bool
+	// Elements is the most elements of a collection rendered. Defaults to 10.
+	Elements int
+}
+
+// KeyInfo describes one key.
+type KeyInfo struct {
+	Key  string
+	Type string
+	// TTL is the time left before expiry, or -1 for a persistent key.
+	TTL time.Duration
+	// MemoryBytes is what MEMORY USAGE reports for the key and its value.
+	MemoryBytes int64
+	// Value is the rendered value, when requested.
+	Value string
+}
+
+const (
+	defaultInspectLimit    = 100
+	defaultInspectElements = 10
+)
+
+// InspectKeyspace reports the keys of the running redis matching query,
+// sorted as SCAN returns them.
+func (s *Runtime) InspectKeyspace(ctx context.Context, query KeyspaceQuery) ([]KeyInfo, error) {
+	if query.Pattern == "" {
+		query.Pattern = "*"
+	}
+	if query.Limit <= 0 {
+		query.Limit = defaultInspectLimit
+	}
+	if query.Elements <= 0 {
+		query.Elements = defaultInspectElements
+	}
+	conn, err := s.dialRunning(ctx)
+	if err != nil {
+		return nil, err
+	}
+	defer conn.Close()
+	return inspectKeyspace(ctx, conn, query)
+}
+
+func inspectKeyspace(ctx context.Context, conn *redisConn, query KeyspaceQuery) ([]KeyInfo, error) {
+	if query.DB != 0 {
+		if _, err := conn.Do("SELECT", strconv.Itoa(query.DB)); err != nil {
+			return nil, fmt.Errorf("cannot select database %d: %w", query.DB, err)
+		}
+	}
+	var keys []KeyInfo
+	seen := map[string]bool{}
+	cursor := "0"
+	for {
+		if err := ctx.Err(); err != nil {
+			return nil, err
+		}
+		args := []string{"SCAN", cursor, "MATCH", query.Pattern, "COUNT", "100"}
+		if query.Type != "" {
+			args = append(args, "TYPE", query.Type)
+		}
+		reply, err := conn.Do(args...)
+		if err != nil {
+			return nil, err
+		}
+		page, ok := reply.([]any)
+		if !ok || len(page) != 2 {
+			return nil, fmt.Errorf("unexpected SCAN reply %#v", reply)
+		}
+		next, _ := page[0].(string)
+		batch, _ := page[1].([]any)
+		for _, item := range batch {
+			key, _ := item.(string)
+			// SCAN may return a key more than once.
+			if seen[key] {
+				continue
+			}
+			seen[key] = true
+			info, found, err := inspectKey(conn, key, query)
+			if err != nil {
+				return nil, err
+			}
+			if !found {
+				// Expired or deleted since SCAN saw it.
+				continue
+			}
+			keys = append(keys, info)
+			if len(keys) == query.Limit {
+				return keys, nil
+			}
+		}
+		if next == "0" || next == "" {
+			return keys, nil
+		}
+		cursor = next
+	}
+}
+
+func inspectKey(conn *redisConn, key string, query KeyspaceQuery) (KeyInfo, bool, error) {
+	info := KeyInfo{Key: key}
+	reply, err := conn.Do("TYPE", key)
+	if err != nil {
+		return info, false, err
+	}
+	info.Type, _ = reply.(string)
+	if info.Type == "none" {
+		return info, false, nil
+	}
+	reply, err = conn.Do("PTTL", key)
+	if err != nil {
+		return info, false, err
+	}
+	switch ttl, _ := reply.(int64); {
+	case ttl == -2:
+		return info, false, nil
+	case ttl < 0:
+		info.TTL = -1
+	default:
+		info.TTL = time.Duration(ttl) * time.Millisecond
+	}
+	reply, err = conn.Do("MEMORY", "USAGE", key)
+	if err != nil {
+		return info, false, err
+	}
+	info.MemoryBytes, _ = reply.(int64)
+	if query.Values {
+		if info.Value, err = renderValue(conn, key, info.Type, query.Elements); err != nil {
+			return info, false, err
+		}
+	}
+	return info, true, nil
+}
+
+// renderValue prints the value of key, showing at most elements elements of
+// a collection.
+func renderValue(conn *redisConn, key, kind string, elements int) (string, error) {
+	last := strconv.Itoa(elements - 1)
+	count := strconv.Itoa(elements)
+	var (
+		reply any
+		size  any
+		err   error
+	)
+	switch kind {
+	case "string":
+		reply, err = conn.Do("GET", key)
+		if err != nil {
+			return "", err
+		}
+		value, _ := reply.(string)
+		return quoteArg(value), nil
+	case "list":
+		if reply, err = conn.Do("LRANGE", key, "0", last); err == nil {
+			size, err = conn.Do("LLEN", key)
+		}
+	case "set":
+		if reply, err = conn.Do("SRANDMEMBER", key, count); err == nil {
+			size, err = conn.Do("SCARD", key)
+		}
+	case "zset":
+		if reply, err = conn.Do("ZRANGE", key, "0", last, "WITHSCORES"); err == nil {
+			size, err = conn.Do("ZCARD", key)
+		}
+	case "hash":
+		if reply, err = conn.Do("HSCAN", key, "0", "COUNT", count); err == nil {
+			size, err = conn.Do("HLEN", key)
+			if page, ok := reply.([]any); ok && len(page) == 2 {
+				reply = page[1]
+			}
+		}
+	case "stream":
+		if reply, err = conn.Do("XRANGE", key, "-", "+", "COUNT", count); err == nil {
+			size, err = conn.Do("XLEN", key)
+		}
+	default:
+		return fmt.Sprintf("(%s value not rendered)", kind), nil
+	}
+	if err != nil {
+		return "", err
+	}
+	items, _ := reply.([]any)
+	total, _ := size.(int64)
+	var out strings.Builder
+	switch kind {
+	case "hash", "zset":
+		// Field/value and member/score pairs.
+		out.WriteString("{")
+		for i := 0; i+1 < len(items); i += 2 {
+			if i > 0 {
+				out.WriteString(", ")
+			}
+			fmt.Fprintf(&out, "%s: %s", renderReply(items[i]), renderReply(items[i+1]))
+		}
+		writeMore(&out, int64(len(items)/2), total)
+		out.WriteString("}")
+	default:
+		out.WriteString("[")
+		for i, item := range items {
+			if i > 0 {
+				out.WriteString(", ")
+			}
+			out.WriteString(renderReply(item))
+		}
+		writeMore(&out, int64(len(items)), total)
+		out.WriteString("]")
+	}
+	return out.String(), nil
+}
+
+func writeMore(out *strings.Builder, shown, total int64) {
+	if total > shown {
+		fmt.Fprintf(out, ", … %d more", total-shown)
+	}
+}
+
+// renderReply prints one reply element; stream entries are [id, [field,
+// value, ...]].
+func renderReply(reply any) string {
+	switch value := reply.(type) {
+	case string:
+		return quoteArg(value)
+	case int64:
+		return strconv.FormatInt(value, 10)
+	case nil:
+		return "(nil)"
+	case []any:
+		parts := make([]string, 0, len(value))
+		for _, item := range value {
+			parts = append(parts, renderReply(item))
+		}
+		return "[" + strings.Join(parts, " ") + "]"
+	default:
+		return fmt.Sprint(value)
+	}
+}
@@ -0,0 +1,73 @@
+package main
+
+import (
+	"context"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestInspectKeyspace(t *testing.T) {
+	server := newFakeRedis(t, func(args []string) string {
+		switch strings.ToUpper(args[0]) {
+		case "SELECT":
+			return "+OK\r\n"
+		case "SCAN":
+			if args[3] != "session:*" {
+				return "-ERR unexpected pattern\r\n"
+			}
+			if args[1] == "0" {
+				return "*2\r\n" + bulkReply("7") + "*2\r\n" + bulkReply("session:1") + bulkReply("session:gone")
+			}
+			return "*2\r\n" + bulkReply("0") + "*2\r\n" + bulkReply("session:2") + bulkReply("session:1")
+		case "TYPE":
+			switch args[1] {
+			case "session:1":
+				return "+string\r\n"
+			case "session:2":
+				return "+hash\r\n"
+			}
+			return "+none\r\n"
+		case "PTTL":
+			if args[1] == "session:1" {
+				return ":1500\r\n"
+			}
+			return ":-1\r\n"
+		case "MEMORY":
+			return ":72\r\n"
+		case "GET":
+			return bulkReply("hello world")
+		case "HSCAN":
+			return "*2\r\n" + bulkReply("0") + "*4\r\n" + bulkReply("user") + bulkReply("ada") + bulkReply("visits") + bulkReply("3")
+		case "HLEN":
+			return ":3\r\n"
+		}
+		return "-ERR unknown command\r\n"
+	})
+
+	runtime := NewRuntime()
+	runtime.redisAddress = server.address
+	keys, err := runtime.InspectKeyspace(context.Background(), KeyspaceQuery{Pattern: "session:*", DB: 2, Values: true, Elements: 2})
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := []KeyInfo{
+		{Key: "session:1", Type: "string", TTL: 1500 * time.Millisecond, MemoryBytes: 72, Value: `"hello world"`},
+		{Key: "session:2", Type: "hash", TTL: -1, MemoryBytes: 72, Value: "{user: ada, visits: 3, … 1 more}"},
+	}
+	if !reflect.DeepEqual(keys, want) {
+		t.Errorf("InspectKeyspace = %+v, want %+v", keys, want)
+	}
+
+	keys, err = runtime.InspectKeyspace(context.Background(), KeyspaceQuery{Pattern: "session:*", Limit: 1})
+	if err != nil || len(keys) != 1 || keys[0].Value != "" {
+		t.Errorf("InspectKeyspace with limit 1 = %+v, %v", keys, err)
+	}
+}
+
+func TestInspectKeyspaceNeedsRunningRedis(t *testing.T) {
+	if _, err := NewRuntime().InspectKeyspace(context.Background(), KeyspaceQuery{}); err == nil {
+		t.Error("inspection without redis accepted")
+	}
+}
@@ -5,6 +5,10 @@ import (
 	"embed"
 	"fmt"
 	"net/url"
+	"os"
+	"slices"
+	"strconv"
+	"strings"
 
 	"google.golang.org/grpc/codes"
 	"google.golang.org/grpc/status"
@@ -31,6 +35,143 @@ var requirements = builders.NewDependencies(agent.Name,
 type Settings struct {
 	Password    string `yaml:"password"`
 	RequirePass bool   `yaml:"require-pass"`
+
+	// MaxMemory is redis' maxmemory limit (e.g. 200mb). Empty keeps redis'
+	// default of no limit.
+	MaxMemory string `yaml:"maxmemory"`
+	// MaxMemoryPolicy is how redis evicts keys once maxmemory is reached (e.g.
+	// allkeys-lru). Empty keeps redis' default, noeviction.
+	MaxMemoryPolicy string `yaml:"maxmemory-policy,omitempty"`
+	// Persistence tunes RDB snapshots and the append-only file.
+	Persistence PersistenceSettings `yaml:"persistence,omitempty"`
+
+	// Metrics serves a Prometheus endpoint for the redis run by Runtime.
+	Metrics RuntimeMetricsSettings `yaml:"metrics,omitempty"`
+
+	// Slowlog reports the slow commands of the redis run by Runtime.
+	Slowlog RuntimeSlowlogSettings `yaml:"slowlog,omitempty"`
+
+	// Monitor taps the commands of the redis run by Runtime.
+	Monitor RuntimeMonitorSettings `yaml:"monitor,omitempty"`
+
+	// Logs filters the redis log stream of the runtime.
+	Logs RuntimeLogSettings `yaml:"logs,omitempty"`
+
+	// Seed is a file of redis commands, in the service directory, replayed
+	// into an empty redis and by Runtime.Reset.
+	Seed string `yaml:"seed,omitempty"`
+	// ResetOnStart flushes redis and replays the seed on every Start.
+	ResetOnStart bool `yaml:"reset-on-start,omitempty"`
+
+	// Scripts are .lua files, or globs of them, in the service directory:
+	// Lua scripts and Redis Functions libraries loaded into redis.
+	Scripts []string `yaml:"scripts,omitempty"`
+
+	// Streams are the streams and consumer groups provisioned for consumers.
+	Streams []StreamSettings `yaml:"streams,omitempty"`
+
+	// Notifications enable keyspace notifications.
+	Notifications NotificationSettings `yaml:"notifications,omitempty"`
+
+	// Image overrides the redis image, e.g. to pull it from a mirror.
+	Image ImageSettings `yaml:"image,omitempty"`
+
+	Deployment DeploymentSettings `yaml:"deployment"`
+}
+
+// PersistenceSettings configure how redis persists its dataset.
+type PersistenceSettings struct {
+	// Save is the RDB snapshot schedule as "<seconds> <changes>" pairs, e.g.
+	// "3600 1 300 100". "off" disables snapshots; empty keeps the default.
+	Save string `yaml:"save,omitempty"`
+	// AppendOnly enables the append-only file.
+	AppendOnly bool `yaml:"appendonly,omitempty"`
+	// AppendFsync is the AOF fsync policy: always, everysec or no.
+	AppendFsync string `yaml:"appendfsync,omitempty"`
+}
+
+var (
+	maxMemoryPolicies = []string{
+		"noeviction", "allkeys-lru", "allkeys-lfu", "allkeys-random",
+		"volatile-lru", "volatile-lfu", "volatile-random", "volatile-ttl",
+	}
+	appendFsyncPolicies = []string{"always", "everysec", "no"}
+)
+
+// validateRedisConfig rejects directives redis would refuse to start with.
+func (s *Settings) validateRedisConfig() error {
+	if s.MaxMemoryPolicy != "" && !slices.Contains(maxMemoryPolicies, s.MaxMemoryPolicy) {
+		return fmt.Errorf("invalid maxmemory-policy %q (want one of %s)", s.MaxMemoryPolicy, strings.Join(maxMemoryPolicies, ", "))
+	}
+	if save := s.Persistence.Save; save != "" && save != "off" {
+		fields := strings.Fields(save)
+		if len(fields)%2 != 0 {
+			return fmt.Errorf("invalid persistence save %q: want <seconds> <changes> pairs", save)
+		}
+		for _, field := range fields {
+			if _, err := strconv.ParseUint(field, 10, 32); err != nil {
+				return fmt.Errorf("invalid persistence save %q: want <seconds> <changes> pairs", save)
+			}
+		}
+	}
+	if fsync := s.Persistence.AppendFsync; fsync != "" && !slices.Contains(appendFsyncPolicies, fsync) {
+		return fmt.Errorf("invalid persistence appendfsync %q (want one of %s)", fsync, strings.Join(appendFsyncPolicies, ", "))
+	}
+	return s.Notifications.validate()
+}
+
+// redisDirective is one non-secret redis.conf directive.
+type redisDirective struct {
+	Name  string
+	Value string
+}
+
+// redisDirectives are the non-secret server directives derived from Settings.
+// Every runtime applies the same list: the nix runtime writes them to its
+// config file, Docker and compose pass them as redis-server flags, and
+// Kubernetes renders them into the redis.conf ConfigMap.
+func (s *Settings) redisDirectives() []redisDirective {
+	var directives []redisDirective
+	if s.MaxMemory != "" {
+		directives = append(directives, redisDirective{Name: "maxmemory", Value: s.MaxMemory})
+	}
+	if s.MaxMemoryPolicy != "" {
+		directives = append(directives, redisDirective{Name: "maxmemory-policy", Value: s.MaxMemoryPolicy})
+	}
+	switch s.Persistence.Save {
+	case "":
+	case "off":
+		directives = append(directives, redisDirective{Name: "save", Value: ""})
+	default:
+		directives = append(directives, redisDirective{Name: "save", Value: strings.Join(strings.Fields(s.Persistence.Save), " ")})
+	}
+	if s.Persistence.AppendOnly {
+		directives = append(directives, redisDirective{Name: "appendonly", Value: "yes"})
+	}
+	if s.Persistence.AppendFsync != "" {
+		directives = append(directives, redisDirective{Name: "appendfsync", Value: s.Persistence.AppendFsync})
+	}
+	if flags := s.Notifications.flags(); flags != "" {
+		directives = append(directives, redisDirective{Name: "notify-keyspace-events", Value: flags})
+	}
+	return directives
+}
+
+// redisConfigLines renders directives as redis.conf lines.
+func redisConfigLines(directives []redisDirective) []string {
+	lines := make([]string, 0, len(directives))
+	for _, directive := range directives {
+		lines = append(lines, directive.Name+" "+strconv.Quote(directive.Value))
+	}
+	return lines
+}
+
+func redisServerFlags(directives []redisDirective) []string {
+	var flags []string
+	for _, directive := range directives {
+		flags = append(flags, "--"+directive.Name, directive.Value)
+	}
+	return flags
 }
 
 var image = &resources.DockerImage{
@@ -39,6 +180,14 @@ var image = &resources.DockerImage{
 	Digest: "sha256:9d317178eceac8454a2284a9e6df2466b93c745529947f0cd42a0fa9609d7005",
 }
 
+// exporterImage is the Prometheus redis_exporter sidecar deployed when
+// metrics are enabled. The first such deployment pins its digest in
+// redis.lock.yaml.
+var exporterImage = &resources.DockerImage{
+	Name: "oliver006/redis_exporter",
+	Tag:  "v1.67.0-alpine",
+}
+
 type Service struct {
 	*services.Base
 
@@ -68,6 +217,13 @@ func (s *Service) GetAgentInformation(ctx context.Context, _ *agentv0.AgentInfor
 				Name: "redis", Description: "redis connection details",
 				Fields: []*agentv0.ConfigurationValueInformation{
 					{Name: "connection", Description: "connection string"},
+					{Name: "sentinels", Description: "comma-separated Sentinel host:port list (replicated deployments)"},
+					{Name: "master-name", Description: "name Sentinel monitors the primary under (replicated deployments)"},
+					{Name: "seed-nodes", Description: "comma-separated host:port list of cluster nodes (cluster deployments)"},
+					{Name: "script-<name>", Description: "SHA1 of the <name>.lua script, for EVALSHA"},
+					{Name: "notify-keyspace-events", Description: "keyspace notification classes redis publishes (notifications enabled)"},
+					{Name: "keyspace-prefix", Description: "pattern prefix of keyspace channels, followed by the key"},
+					{Name: "keyevent-prefix", Description: "pattern prefix of keyevent channels, followed by the event"},
 				},
 			},
 		},
@@ -173,6 +329,15 @@ func (s *Service) restrictedConnectionConfiguration(instance *basev0.NetworkInst
 }
 
 func main() {
+	// Run with a control command, the agent is the command line of the
+	// running redis; otherwise it serves the agent plugin.
+	if isControlCommand(os.Args[1:]) {
+		if err := runControlCommand(context.Background(), os.Args[1:], os.Stdout); err != nil {
+			fmt.Fprintln(os.Stderr, err)
+			os.Exit(1)
+		}
+		return
+	}
 	svc := NewService()
 	agents.Serve(agents.PluginRegistration{
 		Agent:   svc,
@@ -0,0 +1,305 @@
+package main
+
+// metrics.go — a Prometheus endpoint for the redis started by Runtime.
+//
+// Deployed redis gets the redis_exporter sidecar; locally the agent polls INFO
+// itself and serves the last snapshot in the Prometheus text format, so a local
+// Grafana can chart redis next to the application services.
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// RuntimeMetricsSettings configure the local metrics endpoint.
+type RuntimeMetricsSettings struct {
+	Enabled bool `yaml:"enabled,omitempty"`
+	// Address the endpoint listens on. Defaults to localhost:9121.
+	Address string `yaml:"address,omitempty"`
+	// Interval between INFO polls. Defaults to 10s.
+	Interval string `yaml:"interval,omitempty"`
+}
+
+const (
+	defaultMetricsAddress  = "localhost:9121"
+	defaultMetricsInterval = 10 * time.Second
+)
+
+// infoExporter polls INFO from one redis and serves it on /metrics.
+type infoExporter struct {
+	address       string
+	redisAddress  string
+	redisPassword string
+	interval      time.Duration
+
+	listener net.Listener
+	server   *http.Server
+	cancel   context.CancelFunc
+	done     chan struct{}
+
+	mu   sync.Mutex
+	info map[string]string
+	up   bool
+}
+
+func newInfoExporter(settings RuntimeMetricsSettings, redisAddress, redisPassword string) (*infoExporter, error) {
+	exporter := &infoExporter{
+		address:       settings.Address,
+		redisAddress:  redisAddress,
+		redisPassword: redisPassword,
+		interval:      defaultMetricsInterval,
+	}
+	if exporter.address == "" {
+		exporter.address = defaultMetricsAddress
+	}
+	if _, _, err := net.SplitHostPort(exporter.address); err != nil {
+		return nil, fmt.Errorf("invalid metrics address %q: %w", exporter.address, err)
+	}
+	if settings.Interval != "" {
+		interval, err := time.ParseDuration(settings.Interval)
+		if err != nil || interval <= 0 {
+			return nil, fmt.Errorf("invalid metrics interval %q", settings.Interval)
+		}
+		exporter.interval = interval
+	}
+	return exporter, nil
+}
+
+// Start listens on the metrics address and polls redis until Stop.
+func (e *infoExporter) Start() error {
+	if e.cancel != nil {
+		return nil
+	}
+	listener, err := net.Listen("tcp", e.address)
+	if err != nil {
+		return fmt.Errorf("cannot serve metrics on %s: %w", e.address, err)
+	}
+	e.listener = listener
+	mux := http.NewServeMux()
+	mux.Handle("/metrics", e)
+	e.server = &http.Server{Handler: mux, ReadHeaderTimeout: 5 * time.Second}
+	go func() { _ = e.server.Serve(listener) }()
+
+	ctx, cancel := context.WithCancel(context.Background())
+	e.cancel = cancel
+	e.done = make(chan struct{})
+	go e.run(ctx)
+	return nil
+}
+
+// Address is the address the endpoint actually listens on.
+func (e *infoExporter) Address() string {
+	if e.listener != nil {
+		return e.listener.Addr().String()
+	}
+	return e.address
+}
+
+func (e *infoExporter) Stop(ctx context.Context) error {
+	if e.cancel == nil {
+		return nil
+	}
+	e.cancel()
+	<-e.done
+	e.cancel = nil
+	return e.server.Shutdown(ctx)
+}
+
+func (e *infoExporter) run(ctx context.Context) {
+	defer close(e.done)
+	ticker := time.NewTicker(e.interval)
+	defer ticker.Stop()
+	for {
+		e.poll(ctx)
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+	}
+}
+
+func (e *infoExporter) poll(ctx context.Context) {
+	info, err := e.fetchInfo(ctx)
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	e.up = err == nil
+	e.info = info
+}
+
+func (e *infoExporter) fetchInfo(ctx context.Context) (map[string]string, error) {
+	conn, err := dialRedis(ctx, e.redisAddress, e.redisPassword)
+	if err != nil {
+		return nil, err
+	}
+	defer conn.Close()
+	reply, err := conn.Do("INFO")
+	if err != nil {
+		return nil, err
+	}
+	text, err := replyString(reply)
+	if err != nil {
+		return nil, err
+	}
+	return parseRedisInfo(text), nil
+}
+
+func (e *infoExporter) ServeHTTP(w http.ResponseWriter, _ *http.Request) {
+	e.mu.Lock()
+	info, up := e.info, e.up
+	e.mu.Unlock()
+	w.Header().Set("Content-Type", "text/plain; version=0.0.4; charset=utf-8")
+	writeRedisMetrics(w, info, up)
+}
+
+// parseRedisInfo parses INFO output into field -> value, skipping section
+// headers.
+func parseRedisInfo(text string) map[string]string {
+	info := map[string]string{}
+	for _, line := range strings.Split(text, "\n") {
+		line = strings.TrimSpace(line)
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+		if name, value, ok := strings.Cut(line, ":"); ok {
+			info[name] = value
+		}
+	}
+	return info
+}
+
+// redisInfoMetric maps one numeric INFO field to a metric.
+type redisInfoMetric struct {
+	name  string
+	kind  string
+	help  string
+	field string
+}
+
+var redisInfoMetrics = []redisInfoMetric{
+	{"redis_uptime_seconds", "gauge", "Seconds since redis started.", "uptime_in_seconds"},
+	{"redis_connected_clients", "gauge", "Client connections, excluding replicas.", "connected_clients"},
+	{"redis_blocked_clients", "gauge", "Clients blocked on a blocking call.", "blocked_clients"},
+	{"redis_memory_used_bytes", "gauge", "Memory allocated by redis.", "used_memory"},
+	{"redis_memory_rss_bytes", "gauge", "Memory resident as seen by the operating system.", "used_memory_rss"},
+	{"redis_memory_max_bytes", "gauge", "The maxmemory limit; 0 means unlimited.", "maxmemory"},
+	{"redis_instantaneous_ops_per_sec", "gauge", "Commands processed per second.", "instantaneous_ops_per_sec"},
+	{"redis_commands_processed_total", "counter", "Commands processed.", "total_commands_processed"},
+	{"redis_keyspace_hits_total", "counter", "Successful key lookups.", "keyspace_hits"},
+	{"redis_keyspace_misses_total", "counter", "Failed key lookups.", "keyspace_misses"},
+	{"redis_evicted_keys_total", "counter", "Keys evicted because of maxmemory.", "evicted_keys"},
+	{"redis_expired_keys_total", "counter", "Keys removed on expiry.", "expired_keys"},
+}
+
+// writeRedisMetrics renders an INFO snapshot in the Prometheus text format.
+func writeRedisMetrics(w io.Writer, info map[string]string, up bool) {
+	writeMetric(w, "redis_up", "gauge", "Whether the last INFO poll succeeded.", boolValue(up))
+	if !up {
+		return
+	}
+	for _, metric := range redisInfoMetrics {
+		value, err := strconv.ParseFloat(info[metric.field], 64)
+		if err != nil {
+			continue
+		}
+		writeMetric(w, metric.name, metric.kind, metric.help, value)
+	}
+	if ratio, ok := keyspaceHitRatio(info); ok {
+		writeMetric(w, "redis_keyspace_hit_ratio", "gauge", "Keyspace hits over lookups since startup.", ratio)
+	}
+
+	keyspace := keyspaceStatistics(info)
+	databases := make([]string, 0, len(keyspace))
+	for db := range keyspace {
+		databases = append(databases, db)
+	}
+	if len(databases) == 0 {
+		return
+	}
+	sort.Strings(databases)
+	for _, metric := range []struct{ name, help, field string }{
+		{"redis_db_keys", "Keys per database.", "keys"},
+		{"redis_db_keys_expiring", "Keys with an expiry per database.", "expires"},
+	} {
+		writeMetricHeader(w, metric.name, "gauge", metric.help)
+		for _, db := range databases {
+			if value, err := strconv.ParseFloat(keyspace[db][metric.field], 64); err == nil {
+				writeSample(w, metric.name, map[string]string{"db": db}, value)
+			}
+		}
+	}
+}
+
+func keyspaceHitRatio(info map[string]string) (float64, bool) {
+	hits, errHits := strconv.ParseFloat(info["keyspace_hits"], 64)
+	misses, errMisses := strconv.ParseFloat(info["keyspace_misses"], 64)
+	if errors.Join(errHits, errMisses) != nil || hits+misses == 0 {
+		return 0, false
+	}
+	return hits / (hits + misses), true
+}
+
+// keyspaceStatistics parses the `db0:keys=1,expires=0,avg_ttl=0` fields.
+func keyspaceStatistics(info map[string]string) map[string]map[string]string {
+	keyspace := map[string]map[string]string{}
+	for name, value := range info {
+		if !strings.HasPrefix(name, "db") {
+			continue
+		}
+		if _, err := strconv.Atoi(strings.TrimPrefix(name, "db")); err != nil {
+			continue
+		}
+		statistics := map[string]string{}
+		for _, pair := range strings.Split(value, ",") {
+			if key, v, ok := strings.Cut(pair, "="); ok {
+				statistics[key] = v
+			}
+		}
+		keyspace[name] = statistics
+	}
+	return keyspace
+}
+
+// writeMetric writes a metric with a single unlabelled sample.
+func writeMetric(w io.Writer, name, kind, help string, value float64) {
+	writeMetricHeader(w, name, kind, help)
+	writeSample(w, name, nil, value)
+}
+
+func writeMetricHeader(w io.Writer, name, kind, help string) {
+	fmt.Fprintf(w, "# HELP %s %s\n# TYPE %s %s\n", name, help, name, kind)
+}
+
+func writeSample(w io.Writer, name string, labels map[string]string, value float64) {
+	formatted := strconv.FormatFloat(value, 'g', -1, 64)
+	if len(labels) == 0 {
+		fmt.Fprintf(w, "%s %s\n", name, formatted)
+		return
+	}
+	keys := make([]string, 0, len(labels))
+	for key := range labels {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	pairs := make([]string, 0, len(keys))
+	for _, key := range keys {
+		pairs = append(pairs, fmt.Sprintf("%s=%q", key, labels[key]))
+	}
+	fmt.Fprintf(w, "%s{%s} %s\n", name, strings.Join(pairs, ","), formatted)
+}
+
+func boolValue(b bool) float64 {
+	if b {
+		return 1
+	}
+	return 0
+}
@@ -0,0 +1,88 @@
+package main
+
+import (
+	"context"
+	"io"
+	"net/http"
+	"strings"
+	"testing"
+)
+
+const testRedisInfo = "# Server\r\nredis_version:8.8.0\r\nuptime_in_seconds:120\r\n\r\n" +
+	"# Clients\r\nconnected_clients:3\r\nblocked_clients:0\r\n\r\n" +
+	"# Memory\r\nused_memory:1048576\r\nused_memory_rss:2097152\r\nmaxmemory:0\r\n\r\n" +
+	"# Stats\r\ninstantaneous_ops_per_sec:17\r\ntotal_commands_processed:900\r\n" +
+	"keyspace_hits:30\r\nkeyspace_misses:10\r\nevicted_keys:2\r\nexpired_keys:5\r\n\r\n" +
+	"# Keyspace\r\ndb0:keys=12,expires=4,avg_ttl=1000\r\ndb2:keys=1,expires=0,avg_ttl=0\r\n"
+
+func TestWriteRedisMetrics(t *testing.T) {
+	var out strings.Builder
+	writeRedisMetrics(&out, parseRedisInfo(testRedisInfo), true)
+	metrics := out.String()
+	for _, expected := range []string{
+		"redis_up 1\n",
+		"redis_uptime_seconds 120\n",
+		"redis_connected_clients 3\n",
+		"redis_memory_used_bytes 1.048576e+06\n",
+		"redis_instantaneous_ops_per_sec 17\n",
+		"# TYPE redis_keyspace_hits_total counter\n",
+		"redis_keyspace_hit_ratio 0.75\n",
+		"redis_evicted_keys_total 2\n",
+		`redis_db_keys{db="db0"} 12` + "\n",
+		`redis_db_keys{db="db2"} 1` + "\n",
+		`redis_db_keys_expiring{db="db0"} 4` + "\n",
+	} {
+		if !strings.Contains(metrics, expected) {
+			t.Errorf("metrics missing %q:\n%s", expected, metrics)
+		}
+	}
+	if strings.Count(metrics, "# TYPE redis_db_keys gauge") != 1 {
+		t.Errorf("redis_db_keys header repeated:\n%s", metrics)
+	}
+
+	out.Reset()
+	writeRedisMetrics(&out, nil, false)
+	if out.String() != "# HELP redis_up Whether the last INFO poll succeeded.\n# TYPE redis_up gauge\nredis_up 0\n" {
+		t.Errorf("metrics while down:\n%s", out.String())
+	}
+}
+
+func TestInfoExporterServesPolledInfo(t *testing.T) {
+	server := newFakeRedis(t, func(args []string) string {
+		if strings.EqualFold(args[0], "INFO") {
+			return bulkReply(testRedisInfo)
+		}
+		return "-ERR unexpected\r\n"
+	})
+	exporter, err := newInfoExporter(RuntimeMetricsSettings{Address: "127.0.0.1:0", Interval: "1h"}, server.address, "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	exporter.poll(context.Background())
+	if err = exporter.Start(); err != nil {
+		t.Fatal(err)
+	}
+	defer exporter.Stop(context.Background())
+
+	response, err := http.Get("http://" + exporter.Address() + "/metrics")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer response.Body.Close()
+	body, err := io.ReadAll(response.Body)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !strings.Contains(string(body), "redis_up 1\n") || !strings.Contains(string(body), "redis_connected_clients 3\n") {
+		t.Fatalf("metrics endpoint served:\n%s", body)
+	}
+}
+
+func TestNewInfoExporterValidatesSettings(t *testing.T) {
+	if _, err := newInfoExporter(RuntimeMetricsSettings{Interval: "often"}, "localhost:6379", ""); err == nil {
+		t.Error("accepted an invalid interval")
+	}
+	if _, err := newInfoExporter(RuntimeMetricsSettings{Address: "9121"}, "localhost:6379", ""); err == nil {
+		t.Error("accepted an address without a port")
+	}
+}
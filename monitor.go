@@ -0,0 +1,349 @@
+package main
+
+// monitor.go — a MONITOR tap on the local redis, for debugging.
+//
+// The tap streams every command redis runs to the agent log, or to
+// monitor.log under the runtime root. It can be turned on and off while redis
+// runs with the agent's `monitor on` and `monitor off` commands (control.go),
+// or from the settings for the whole session:
+//
+//	monitor:
+//	  enabled: true
+//	  clients: ["172.17.0.1:*"]
+//
+// Only the command and its first argument (usually the key) are shown unless
+// show-values is set; credentials are redacted either way. MONITOR costs redis
+// throughput: it is a development tool.
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"os"
+	"path"
+	"path/filepath"
+	"regexp"
+	"strconv"
+	"strings"
+	"sync"
+
+	"github.com/codefly-dev/core/wool"
+)
+
+// RuntimeMonitorSettings configure the MONITOR tap.
+type RuntimeMonitorSettings struct {
+	// Enabled starts the tap with redis.
+	Enabled bool `yaml:"enabled,omitempty"`
+	// Clients keeps the commands of the clients whose address matches one of
+	// these globs, e.g. 127.0.0.1:*. Empty keeps every client.
+	Clients []string `yaml:"clients,omitempty"`
+	// ShowValues shows every argument instead of the command and key only.
+	ShowValues bool `yaml:"show-values,omitempty"`
+	// File writes the commands to monitor.log under the runtime root instead
+	// of the agent log.
+	File bool `yaml:"file,omitempty"`
+}
+
+const monitorLogFile = "monitor.log"
+
+const redactedArg = "(redacted)"
+
+func (m RuntimeMonitorSettings) validate() error {
+	for _, pattern := range m.Clients {
+		if _, err := path.Match(pattern, ""); err != nil {
+			return fmt.Errorf("invalid monitor client pattern %q", pattern)
+		}
+	}
+	return nil
+}
+
+// monitoredCommand is one line of MONITOR output.
+type monitoredCommand struct {
+	Time   string
+	DB     string
+	Client string
+	Args   []string
+}
+
+var monitorLine = regexp.MustCompile(`^(\d+\.\d+) \[(\d+) ([^\]]+)\] (.*)$`)
+
+func parseMonitorLine(line string) (monitoredCommand, error) {
+	match := monitorLine.FindStringSubmatch(line)
+	if match == nil {
+		return monitoredCommand{}, fmt.Errorf("unexpected MONITOR line %q", line)
+	}
+	args, err := parseQuotedArgs(match[4])
+	if err != nil {
+		return monitoredCommand{}, fmt.Errorf("unexpected MONITOR line %q: %w", line, err)
+	}
+	return monitoredCommand{Time: match[1], DB: match[2], Client: match[3], Args: args}, nil
+}
+
+// parseQuotedArgs reads the space-separated "..." arguments of a MONITOR
+// line.
+func parseQuotedArgs(text string) ([]string, error) {
+	var args []string
+	for i := 0; i < len(text); {
+		if text[i] == ' ' {
+			i++
+			continue
+		}
+		if text[i] != '"' {
+			return nil, fmt.Errorf("unquoted argument at %d", i)
+		}
+		arg, next, err := readQuotedArg(text, i)
+		if err != nil {
+			return nil, err
+		}
+		args = append(args, arg)
+		i = next
+	}
+	return args, nil
+}
+
+// readQuotedArg reads the "..." argument starting at text[start], with
+// redis' escapes (\" \\ \n \r \t \a \b \xHH), and returns the index past
+// its closing quote.
+func readQuotedArg(text string, start int) (string, int, error) {
+	var arg strings.Builder
+	i := start + 1
+	for {
+		if i >= len(text) {
+			return "", 0, errors.New("unterminated argument")
+		}
+		c := text[i]
+		if c == '"' {
+			return arg.String(), i + 1, nil
+		}
+		if c != '\\' || i+1 >= len(text) {
+			arg.WriteByte(c)
+			i++
+			continue
+		}
+		i++
+		switch text[i] {
+		case 'n':
+			arg.WriteByte('\n')
+		case 'r':
+			arg.WriteByte('\r')
+		case 't':
+			arg.WriteByte('\t')
+		case 'a':
+			arg.WriteByte('\a')
+		case 'b':
+			arg.WriteByte('\b')
+		case 'x':
+			if i+2 >= len(text) {
+				return "", 0, errors.New("truncated \\x escape")
+			}
+			b, err := strconv.ParseUint(text[i+1:i+3], 16, 8)
+			if err != nil {
+				return "", 0, fmt.Errorf("invalid \\x escape %q", text[i-1:i+3])
+			}
+			arg.WriteByte(byte(b))
+			i += 2
+		default:
+			arg.WriteByte(text[i])
+		}
+		i++
+	}
+}
+
+// credentialArgs finds the arguments of args that hold credentials.
+func credentialArgs(args []string) map[int]bool {
+	secret := map[int]bool{}
+	if len(args) == 0 {
+		return secret
+	}
+	upper := func(i int) string {
+		if i < len(args) {
+			return strings.ToUpper(args[i])
+		}
+		return ""
+	}
+	switch upper(0) {
+	case "AUTH":
+		for i := 1; i < len(args); i++ {
+			secret[i] = true
+		}
+	case "HELLO":
+		// HELLO [protover] AUTH username password
+		for i := range args {
+			if upper(i) == "AUTH" {
+				secret[i+2] = true
+			}
+		}
+	case "MIGRATE":
+		// MIGRATE ... AUTH password | AUTH2 username password
+		for i := range args {
+			switch upper(i) {
+			case "AUTH":
+				secret[i+1] = true
+			case "AUTH2":
+				secret[i+2] = true
+			}
+		}
+	case "CONFIG":
+		if upper(1) == "SET" {
+			for i := 2; i+1 < len(args); i += 2 {
+				if name := strings.ToLower(args[i]); name == "requirepass" || name == "masterauth" {
+					secret[i+1] = true
+				}
+			}
+		}
+	case "ACL":
+		if upper(1) == "SETUSER" {
+			for i := 3; i < len(args); i++ {
+				// >password and #hash rules.
+				if strings.HasPrefix(args[i], ">") || strings.HasPrefix(args[i], "#") {
+					secret[i] = true
+				}
+			}
+		}
+	}
+	return secret
+}
+
+// redactArgs keeps the command and its first argument, or every argument
+// with showValues; credentials never show.
+func redactArgs(args []string, showValues bool) []string {
+	secret := credentialArgs(args)
+	shown := make([]string, 0, len(args))
+	for i, arg := range args {
+		switch {
+		case secret[i]:
+			shown = append(shown, redactedArg)
+		case i < 2 || showValues:
+			shown = append(shown, quoteArg(arg))
+		default:
+			return append(shown, redactedArg)
+		}
+	}
+	return shown
+}
+
+// commandMonitor holds a MONITOR connection open and reports what it reads.
+type commandMonitor struct {
+	settings RuntimeMonitorSettings
+	conn     *redisConn
+	// report receives every kept command.
+	report func(command monitoredCommand, shown []string)
+	closer io.Closer
+	done   chan struct{}
+}
+
+func (m *commandMonitor) keeps(command monitoredCommand) bool {
+	if len(m.settings.Clients) == 0 {
+		return true
+	}
+	for _, pattern := range m.settings.Clients {
+		if matched, _ := path.Match(pattern, command.Client); matched {
+			return true
+		}
+	}
+	return false
+}
+
+func (m *commandMonitor) run(w *wool.Wool) {
+	defer close(m.done)
+	for {
+		reply, err := m.conn.Receive()
+		if err != nil {
+			if !errors.Is(err, net.ErrClosed) {
+				w.Warn("redis monitor stopped", wool.ErrField(err))
+			}
+			return
+		}
+		line, err := replyString(reply)
+		if err != nil {
+			continue
+		}
+		command, err := parseMonitorLine(line)
+		if err != nil {
+			w.Debug("skipping monitor line", wool.ErrField(err))
+			continue
+		}
+		if m.keeps(command) {
+			m.report(command, redactArgs(command.Args, m.settings.ShowValues))
+		}
+	}
+}
+
+func (m *commandMonitor) Stop() error {
+	err := m.conn.Close()
+	<-m.done
+	if m.closer != nil {
+		err = errors.Join(err, m.closer.Close())
+	}
+	return err
+}
+
+// StartMonitor taps the commands the running redis executes, replacing the
+// current tap if any.
+func (s *Runtime) StartMonitor(ctx context.Context, settings RuntimeMonitorSettings) error {
+	if err := settings.validate(); err != nil {
+		return err
+	}
+	if s.redisAddress == "" {
+		return fmt.Errorf("redis is not running")
+	}
+	if err := s.StopMonitor(); err != nil {
+		return err
+	}
+	conn, err := dialRedis(ctx, s.redisAddress, s.redisPassword)
+	if err != nil {
+		return err
+	}
+	if _, err = conn.Do("MONITOR"); err != nil {
+		_ = conn.Close()
+		return fmt.Errorf("cannot monitor redis: %w", err)
+	}
+	conn.timeout = 0
+	monitor := &commandMonitor{settings: settings, conn: conn, done: make(chan struct{})}
+	if settings.File {
+		file, errFile := s.openMonitorLog()
+		if errFile != nil {
+			_ = conn.Close()
+			return errFile
+		}
+		var mu sync.Mutex
+		monitor.closer = file
+		monitor.report = func(command monitoredCommand, shown []string) {
+			mu.Lock()
+			defer mu.Unlock()
+			_, _ = fmt.Fprintf(file, "%s [%s %s] %s\n", command.Time, command.DB, command.Client, strings.Join(shown, " "))
+		}
+		s.Infof("writing redis commands to %s", file.Name())
+	} else {
+		monitor.report = func(command monitoredCommand, shown []string) {
+			s.Wool.Info("redis command", wool.Field("command", strings.Join(shown, " ")),
+				wool.Field("client", command.Client), wool.Field("db", command.DB))
+		}
+	}
+	s.monitor = monitor
+	go monitor.run(s.Wool)
+	return nil
+}
+
+// StopMonitor removes the tap; redis keeps running.
+func (s *Runtime) StopMonitor() error {
+	if s.monitor == nil {
+		return nil
+	}
+	err := s.monitor.Stop()
+	s.monitor = nil
+	return err
+}
+
+func (s *Runtime) openMonitorLog() (*os.File, error) {
+	root, err := redisRuntimeRoot(s.Location)
+	if err != nil {
+		return nil, err
+	}
+	if err = os.MkdirAll(root, 0o700); err != nil {
+		return nil, err
+	}
+	return os.OpenFile(filepath.Join(root, monitorLogFile), os.O_CREATE|os.O_APPEND|os.O_WRONLY, 0o600)
+}
@@ -0,0 +1,95 @@
+package main
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestParseMonitorLine(t *testing.T) {
+	command, err := parseMonitorLine(`1700000000.123456 [2 127.0.0.1:5000] "SET" "greeting" "hello \"world\"\r\n\x00"`)
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := monitoredCommand{Time: "1700000000.123456", DB: "2", Client: "127.0.0.1:5000", Args: []string{"SET", "greeting", "hello \"world\"\r\n\x00"}}
+	if !reflect.DeepEqual(command, want) {
+		t.Errorf("parseMonitorLine = %#v, want %#v", command, want)
+	}
+	if _, err = parseMonitorLine(`1700000000.123456 [0 lua] "GET" "unterminated`); err == nil {
+		t.Error("unterminated argument accepted")
+	}
+}
+
+func TestRedactArgs(t *testing.T) {
+	tests := []struct {
+		args       []string
+		showValues bool
+		want       string
+	}{
+		{args: []string{"SET", "session:1", "token"}, want: "SET session:1 (redacted)"},
+		{args: []string{"SET", "session:1", "token", "EX", "60"}, showValues: true, want: "SET session:1 token EX 60"},
+		{args: []string{"GET", "session:1"}, want: "GET session:1"},
+		{args: []string{"AUTH", "default", "secret"}, showValues: true, want: "AUTH (redacted) (redacted)"},
+		{args: []string{"HELLO", "3", "AUTH", "default", "secret"}, showValues: true, want: "HELLO 3 AUTH default (redacted)"},
+		{args: []string{"CONFIG", "SET", "maxmemory", "1gb", "requirepass", "secret"}, showValues: true, want: "CONFIG SET maxmemory 1gb requirepass (redacted)"},
+		{args: []string{"ACL", "SETUSER", "app", "on", ">secret", "~*"}, showValues: true, want: "ACL SETUSER app on (redacted) ~*"},
+		{args: []string{"MIGRATE", "10.0.0.2", "6379", "", "0", "5000", "AUTH", "secret", "KEYS", "a"}, showValues: true, want: `MIGRATE 10.0.0.2 6379 "" 0 5000 AUTH (redacted) KEYS a`},
+	}
+	for _, test := range tests {
+		if got := strings.Join(redactArgs(test.args, test.showValues), " "); got != test.want {
+			t.Errorf("redactArgs(%q, %v) = %s, want %s", test.args, test.showValues, got, test.want)
+		}
+	}
+}
+
+func TestRuntimeMonitorWritesFilteredCommandsToFile(t *testing.T) {
+	t.Setenv("XDG_CACHE_HOME", t.TempDir())
+	monitored := make(chan struct{})
+	server := newFakeRedis(t, func(args []string) string {
+		if strings.ToUpper(args[0]) != "MONITOR" {
+			return "-ERR unknown command\r\n"
+		}
+		defer close(monitored)
+		return "+OK\r\n" +
+			"+1700000000.000001 [0 10.0.0.9:4000] \"GET\" \"other\"\r\n" +
+			"+1700000000.000002 [0 127.0.0.1:5000] \"SET\" \"session:1\" \"token\"\r\n" +
+			"+1700000000.000003 [0 127.0.0.1:5000] \"AUTH\" \"secret\"\r\n"
+	})
+
+	runtime := NewRuntime()
+	runtime.Location = t.TempDir()
+	runtime.redisAddress = server.address
+	if err := runtime.StartMonitor(context.Background(), RuntimeMonitorSettings{Clients: []string{"127.0.0.1:*"}, File: true}); err != nil {
+		t.Fatal(err)
+	}
+	<-monitored
+	root, err := redisRuntimeRoot(runtime.Location)
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := "1700000000.000002 [0 127.0.0.1:5000] SET session:1 (redacted)\n" +
+		"1700000000.000003 [0 127.0.0.1:5000] AUTH (redacted)\n"
+	var content []byte
+	for deadline := time.Now().Add(5 * time.Second); time.Now().Before(deadline); time.Sleep(10 * time.Millisecond) {
+		if content, err = os.ReadFile(filepath.Join(root, monitorLogFile)); err == nil && string(content) == want {
+			break
+		}
+	}
+	if string(content) != want {
+		t.Errorf("monitor.log =\n%s\nwant\n%s", content, want)
+	}
+
+	if err = runtime.StopMonitor(); err != nil {
+		t.Fatal(err)
+	}
+	if runtime.monitor != nil {
+		t.Error("monitor still set after StopMonitor")
+	}
+	if err = runtime.StartMonitor(context.Background(), RuntimeMonitorSettings{Clients: []string{"[bad"}}); err == nil {
+		t.Error("invalid client pattern accepted")
+	}
+}
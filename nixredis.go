@@ -47,6 +47,7 @@ type nixRedis struct {
 	configPath string
 	port       uint16
 	password   string
+	directives []redisDirective
 	out        io.Writer
 	proc       runners.Proc
 	// serverCtx is the context the redis process runs under. It MUST outlive
@@ -64,7 +65,7 @@ type nixRedis struct {
 // private per-service user cache directory. Keeping Nix inputs, cache files,
 // Redis data, and the secret-bearing config out of the source checkout avoids
 // invalidating parent flakes and accidentally committing runtime state.
-func newNixRedis(ctx context.Context, baseDir string, port uint16, password string, out io.Writer) (*nixRedis, error) {
+func newNixRedis(ctx context.Context, baseDir string, port uint16, password string, directives []redisDirective, out io.Writer) (*nixRedis, error) {
 	runtimeRoot, err := redisRuntimeRoot(baseDir)
 	if err != nil {
 		return nil, err
@@ -97,6 +98,7 @@ func newNixRedis(ctx context.Context, baseDir string, port uint16, password stri
 		configPath: filepath.Join(runtimeRoot, "redis.conf"),
 		port:       port,
 		password:   password,
+		directives: directives,
 		out:        out,
 	}, nil
 }
@@ -150,6 +152,17 @@ func (n *nixRedis) resolveStore() error {
 	return nil
 }
 
+// nixRedisStartup are the directives the nix config file sets before the
+// settings' own: a local redis keeps neither snapshots nor an AOF.
+var nixRedisStartup = []redisDirective{
+	{Name: "save", Value: ""},
+	{Name: "appendonly", Value: "no"},
+}
+
+// nixRedisBaseline is what the nix runtime starts redis with for the
+// directives Reset restores.
+var nixRedisBaseline = overrideDirectives(dockerRedisBaseline, nixRedisStartup)
+
 // writeConfig keeps the password out of process argv (and therefore ps/process
 // inspection). The parent runtime directory and this file are owner-only.
 func (n *nixRedis) writeConfig() error {
@@ -158,10 +171,10 @@ func (n *nixRedis) writeConfig() error {
 		"bind 127.0.0.1",
 		"protected-mode yes",
 		"dir " + strconv.Quote(n.dataDir),
-		`save ""`,
-		"appendonly no",
 		"daemonize no",
 	}
+	lines = append(lines, redisConfigLines(nixRedisStartup)...)
+	lines = append(lines, redisConfigLines(n.directives)...)
 	if n.password != "" {
 		lines = append(lines, "requirepass "+strconv.Quote(n.password))
 	}
@@ -37,6 +37,7 @@ func TestWriteRedisConfigKeepsSecretInPrivateFile(t *testing.T) {
 		configPath: configPath,
 		port:       16379,
 		password:   `space and # "quotes"`,
+		directives: []redisDirective{{Name: "maxmemory", Value: "200mb"}},
 	}
 	if err := n.writeConfig(); err != nil {
 		t.Fatal(err)
@@ -46,7 +47,7 @@ func TestWriteRedisConfigKeepsSecretInPrivateFile(t *testing.T) {
 		t.Fatal(err)
 	}
 	text := string(data)
-	for _, want := range []string{"port 16379", "bind 127.0.0.1", "requirepass \"space and # \\\"quotes\\\"\""} {
+	for _, want := range []string{"port 16379", "bind 127.0.0.1", `save ""`, `appendonly "no"`, "requirepass \"space and # \\\"quotes\\\"\"", `maxmemory "200mb"`} {
 		if !strings.Contains(text, want) {
 			t.Fatalf("config missing %q:\n%s", want, text)
 		}
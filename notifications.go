@@ -0,0 +1,138 @@
+package main
+
+// notifications.go — keyspace notifications for dependents that subscribe to
+// key events, typically expiries.
+//
+//	notifications:
+//	  channels: [keyevent]
+//	  events: [expired, evicted]
+//
+// The setting becomes redis' notify-keyspace-events directive, so the local
+// runtimes and deployments publish the same events, and the channel pattern
+// prefixes are exported to dependents: with the example above they
+// PSUBSCRIBE to `<keyevent-prefix>expired`. In a cluster, every node
+// publishes the events of its own keys only.
+
+import (
+	"fmt"
+	"slices"
+	"strings"
+
+	basev0 "github.com/codefly-dev/core/generated/go/codefly/base/v0"
+)
+
+// NotificationSettings enable keyspace notifications.
+type NotificationSettings struct {
+	// Channels are keyspace (__keyspace@<db>__:<key>, the event as message)
+	// and keyevent (__keyevent@<db>__:<event>, the key as message). Defaults
+	// to keyevent when events are set.
+	Channels []string `yaml:"channels,omitempty"`
+	// Events are the event classes published.
+	Events []string `yaml:"events,omitempty"`
+}
+
+// notificationFlag is a notify-keyspace-events class, in redis' order.
+type notificationFlag struct {
+	Name string
+	Flag string
+}
+
+var (
+	notificationChannels = []notificationFlag{
+		{Name: "keyspace", Flag: "K"},
+		{Name: "keyevent", Flag: "E"},
+	}
+	notificationEvents = []notificationFlag{
+		{Name: "generic", Flag: "g"},
+		{Name: "string", Flag: "$"},
+		{Name: "list", Flag: "l"},
+		{Name: "set", Flag: "s"},
+		{Name: "hash", Flag: "h"},
+		{Name: "zset", Flag: "z"},
+		{Name: "expired", Flag: "x"},
+		{Name: "evicted", Flag: "e"},
+		{Name: "stream", Flag: "t"},
+		{Name: "key-miss", Flag: "m"},
+		{Name: "new", Flag: "n"},
+		{Name: "module", Flag: "d"},
+		// A is g$lshzxetd: key-miss and new are not part of it.
+		{Name: "all", Flag: "A"},
+	}
+)
+
+const (
+	keyspaceChannelPrefix = "__keyspace@*__:"
+	keyeventChannelPrefix = "__keyevent@*__:"
+)
+
+func (n NotificationSettings) enabled() bool {
+	return len(n.Events) > 0
+}
+
+func (n NotificationSettings) channels() []string {
+	if len(n.Channels) == 0 && n.enabled() {
+		return []string{"keyevent"}
+	}
+	return n.Channels
+}
+
+func (n NotificationSettings) validate() error {
+	if len(n.Channels) > 0 && !n.enabled() {
+		return fmt.Errorf("notifications: channels without events")
+	}
+	for _, channel := range n.Channels {
+		if !slices.ContainsFunc(notificationChannels, func(f notificationFlag) bool { return f.Name == channel }) {
+			return fmt.Errorf("notifications: unknown channel %q (want keyspace or keyevent)", channel)
+		}
+	}
+	for _, event := range n.Events {
+		if !slices.ContainsFunc(notificationEvents, func(f notificationFlag) bool { return f.Name == event }) {
+			return fmt.Errorf("notifications: unknown event class %q (want one of %s)", event, notificationFlagNames(notificationEvents))
+		}
+	}
+	return nil
+}
+
+func notificationFlagNames(flags []notificationFlag) string {
+	names := make([]string, 0, len(flags))
+	for _, flag := range flags {
+		names = append(names, flag.Name)
+	}
+	return strings.Join(names, ", ")
+}
+
+// flags renders the notify-keyspace-events value, e.g. "Exe".
+func (n NotificationSettings) flags() string {
+	if !n.enabled() {
+		return ""
+	}
+	var flags strings.Builder
+	for _, set := range []struct {
+		flags    []notificationFlag
+		selected []string
+	}{{notificationChannels, n.channels()}, {notificationEvents, n.Events}} {
+		for _, flag := range set.flags {
+			if slices.Contains(set.selected, flag.Name) {
+				flags.WriteString(flag.Flag)
+			}
+		}
+	}
+	return flags.String()
+}
+
+// configurationValues export the events and the channel pattern
+// prefixes dependents subscribe to.
+func (n NotificationSettings) configurationValues() []*basev0.ConfigurationValue {
+	if !n.enabled() {
+		return nil
+	}
+	values := []*basev0.ConfigurationValue{{Key: "notify-keyspace-events", Value: n.flags()}}
+	channels := n.channels()
+	if slices.Contains(channels, "keyspace") {
+		values = append(values, &basev0.ConfigurationValue{Key: "keyspace-prefix", Value: keyspaceChannelPrefix})
+	}
+	if slices.Contains(channels, "keyevent") {
+		values = append(values, &basev0.ConfigurationValue{Key: "keyevent-prefix", Value: keyeventChannelPrefix})
+	}
+	return values
+}
@@ -0,0 +1,69 @@
+package main
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestNotificationSettings(t *testing.T) {
+	tests := []struct {
+		settings NotificationSettings
+		flags    string
+		values   map[string]string
+	}{
+		{settings: NotificationSettings{}, flags: ""},
+		{
+			settings: NotificationSettings{Events: []string{"evicted", "expired"}},
+			flags:    "Exe",
+			values:   map[string]string{"notify-keyspace-events": "Exe", "keyevent-prefix": "__keyevent@*__:"},
+		},
+		{
+			settings: NotificationSettings{Channels: []string{"keyevent", "keyspace"}, Events: []string{"all", "key-miss"}},
+			flags:    "KEmA",
+			values:   map[string]string{"notify-keyspace-events": "KEmA", "keyspace-prefix": "__keyspace@*__:", "keyevent-prefix": "__keyevent@*__:"},
+		},
+	}
+	for _, test := range tests {
+		if err := test.settings.validate(); err != nil {
+			t.Errorf("validate(%+v) = %v", test.settings, err)
+		}
+		if got := test.settings.flags(); got != test.flags {
+			t.Errorf("flags(%+v) = %q, want %q", test.settings, got, test.flags)
+		}
+		var values map[string]string
+		for _, value := range test.settings.configurationValues() {
+			if values == nil {
+				values = map[string]string{}
+			}
+			values[value.Key] = value.Value
+		}
+		if !reflect.DeepEqual(values, test.values) {
+			t.Errorf("configurationValues(%+v) = %v, want %v", test.settings, values, test.values)
+		}
+	}
+
+	for _, settings := range []NotificationSettings{
+		{Channels: []string{"keyevent"}},
+		{Channels: []string{"keys"}, Events: []string{"expired"}},
+		{Events: []string{"expiry"}},
+	} {
+		if err := settings.validate(); err == nil {
+			t.Errorf("validate(%+v) accepted", settings)
+		}
+	}
+}
+
+func TestNotificationsBecomeRedisDirective(t *testing.T) {
+	settings := &Settings{Notifications: NotificationSettings{Events: []string{"expired"}}}
+	if err := settings.validateRedisConfig(); err != nil {
+		t.Fatal(err)
+	}
+	want := []redisDirective{{Name: "notify-keyspace-events", Value: "Ex"}}
+	if got := settings.redisDirectives(); !reflect.DeepEqual(got, want) {
+		t.Errorf("redisDirectives = %+v, want %+v", got, want)
+	}
+	settings.Notifications.Events = []string{"expiry"}
+	if err := settings.validateRedisConfig(); err == nil {
+		t.Error("unknown event class accepted")
+	}
+}
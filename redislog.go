@@ -9,11 +9,28 @@ package main
 // the container and Wool: it parses each line with a declarative gortk LogSpec,
 // drops the redundant timestamp (Wool stamps its own), keeps pid as a field,
 // and emits at the mapped Wool level.
+//
+// Several redis processes (replicas, sentinels, cluster nodes) can share one
+// stream, so every line is tagged with the instance it comes from, its port
+// and the role redis prints: primary, replica, child (a fork saving or
+// rewriting) or sentinel. The `logs.instances` runtime setting keeps the
+// stream to the instances listed; the others only log at debug. It may only
+// name instances the runtime starts, which today is the one redis process
+// named after the service.
+//
+// Lines announcing a state transition (a snapshot starting, a replica in
+// sync, the server ready, ...) also carry an `event` field naming it, and the
+// first "Ready to accept connections" is exposed as a readiness signal.
 
 import (
 	"bytes"
+	"fmt"
 	"io"
+	"maps"
+	"regexp"
+	"slices"
 	"strings"
+	"sync"
 
 	"github.com/codefly-dev/core/wool"
 	"github.com/codefly-dev/gortk"
@@ -35,16 +52,140 @@ func mustCompileLog(s gortk.LogSpec) *gortk.LogParser {
 	return p
 }
 
+// redisEvent names a state transition redis reports in its log.
+type redisEvent string
+
+const (
+	eventReady              redisEvent = "ready"
+	eventRDBSaveStarted     redisEvent = "rdb-save-started"
+	eventRDBSaveFinished    redisEvent = "rdb-save-finished"
+	eventRDBSaveFailed      redisEvent = "rdb-save-failed"
+	eventAOFRewriteStarted  redisEvent = "aof-rewrite-started"
+	eventAOFRewriteFinished redisEvent = "aof-rewrite-finished"
+	eventAOFRewriteFailed   redisEvent = "aof-rewrite-failed"
+	eventReplicaSyncStarted redisEvent = "replica-sync-started"
+	eventReplicaSyncDone    redisEvent = "replica-sync-finished"
+	eventOutOfMemory        redisEvent = "out-of-memory"
+	eventModuleLoadFailed   redisEvent = "module-load-failed"
+)
+
+// redisEventPatterns recognise events by message, first match wins. Named
+// captures become fields of the line.
+var redisEventPatterns = []struct {
+	event   redisEvent
+	pattern *regexp.Regexp
+}{
+	{eventReady, regexp.MustCompile(`^Ready to accept connections`)},
+	{eventRDBSaveStarted, regexp.MustCompile(`^Background saving started by pid (?P<child_pid>\d+)`)},
+	{eventRDBSaveFinished, regexp.MustCompile(`^(Background saving terminated with success|DB saved on disk)`)},
+	{eventRDBSaveFailed, regexp.MustCompile(`^(Background saving error|Background saving terminated by signal|Failed opening the temp RDB file|Write error saving DB on disk)`)},
+	{eventAOFRewriteStarted, regexp.MustCompile(`^Background append only file rewriting started by pid (?P<child_pid>\d+)`)},
+	{eventAOFRewriteFinished, regexp.MustCompile(`^Background AOF rewrite (finished successfully|terminated with success)`)},
+	{eventAOFRewriteFailed, regexp.MustCompile(`^(Background AOF rewrite terminated (with error|by signal)|Error opening /setting AOF rewrite IPC pipes)`)},
+	{eventReplicaSyncStarted, regexp.MustCompile(`^(MASTER <-> REPLICA sync started|Replica (?P<replica>\S+) asks for synchronization)`)},
+	{eventReplicaSyncDone, regexp.MustCompile(`^(MASTER <-> REPLICA sync: Finished with success|Synchronization with replica (?P<replica>\S+) succeeded)`)},
+	{eventOutOfMemory, regexp.MustCompile(`^(Out Of Memory allocating (?P<bytes>\d+) bytes|WARNING: 32 bit instance detected but no memory limit set)`)},
+	{eventModuleLoadFailed, regexp.MustCompile(`^(Module (?P<module>\S+) (failed to load|initialization failed)|Error loading the extension)`)},
+}
+
+// redisEventOf recognises the event msg announces, with its captured fields.
+func redisEventOf(msg string) (redisEvent, map[string]string) {
+	for _, candidate := range redisEventPatterns {
+		match := candidate.pattern.FindStringSubmatch(msg)
+		if match == nil {
+			continue
+		}
+		fields := map[string]string{}
+		for i, name := range candidate.pattern.SubexpNames() {
+			if name != "" && match[i] != "" {
+				fields[name] = match[i]
+			}
+		}
+		return candidate.event, fields
+	}
+	return "", nil
+}
+
+// RuntimeLogSettings shape the redis log stream of the runtime.
+type RuntimeLogSettings struct {
+	// Instances lists the redis instances whose lines are shown. Empty shows
+	// them all.
+	Instances []string `yaml:"instances,omitempty"`
+}
+
+// validate rejects instances the runtime does not start: filtering on them
+// would quietly demote the whole stream to debug.
+func (l RuntimeLogSettings) validate(started []redisInstance) error {
+	names := make([]string, 0, len(started))
+	for _, instance := range started {
+		names = append(names, instance.Name)
+	}
+	for _, name := range l.Instances {
+		if !slices.Contains(names, name) {
+			return fmt.Errorf("invalid logs instance %q (the runtime starts %s)", name, strings.Join(names, ", "))
+		}
+	}
+	return nil
+}
+
+// redisInstance identifies the redis process a log stream comes from.
+type redisInstance struct {
+	Name string
+	Port uint16
+}
+
+// redisRoles maps the role char of the log prefix.
+var redisRoles = map[string]string{"M": "primary", "S": "replica", "C": "child", "X": "sentinel"}
+
 // redisLogWriter parses the redis log stream and re-emits each line through Wool
 // at a severity-mapped level. It implements io.Writer so it can replace the raw
 // logger handed to the runner.
 type redisLogWriter struct {
-	w   *wool.Wool
-	buf []byte
+	w        *wool.Wool
+	instance redisInstance
+	// quiet demotes every line to debug: the instance is filtered out.
+	quiet bool
+	buf   []byte
+	// log emits a parsed line; logAt unless a test captures the lines.
+	log func(level wool.Loglevel, msg string, fields ...*wool.LogField)
+
+	ready     chan struct{}
+	readyOnce sync.Once
+
+	mu          sync.Mutex
+	diagnostics []startupDiagnostic
+}
+
+func newRedisLogWriter(w *wool.Wool, instance redisInstance, settings RuntimeLogSettings) *redisLogWriter {
+	p := &redisLogWriter{
+		w:        w,
+		instance: instance,
+		quiet:    len(settings.Instances) > 0 && !slices.Contains(settings.Instances, instance.Name),
+		ready:    make(chan struct{}),
+	}
+	p.log = p.logAt
+	return p
 }
 
-func newRedisLogWriter(w *wool.Wool) *redisLogWriter {
-	return &redisLogWriter{w: w}
+// Ready is closed once redis logs that it accepts connections.
+func (p *redisLogWriter) Ready() <-chan struct{} {
+	return p.ready
+}
+
+// Diagnostics are the warnings redis logged before it was ready.
+func (p *redisLogWriter) Diagnostics() []startupDiagnostic {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	return slices.Clone(p.diagnostics)
+}
+
+func (p *redisLogWriter) starting() bool {
+	select {
+	case <-p.ready:
+		return false
+	default:
+		return true
+	}
 }
 
 var _ io.Writer = (*redisLogWriter)(nil)
@@ -76,10 +217,45 @@ func (p *redisLogWriter) emit(line string) {
 	msg, _ := rec.Fields["msg"].(string)
 
 	var fields []*wool.LogField
+	if p.instance.Name != "" {
+		fields = append(fields, wool.Field("instance", p.instance.Name))
+	}
+	if p.instance.Port != 0 {
+		fields = append(fields, wool.Field("port", p.instance.Port))
+	}
+	if role, ok := redisRoles[fmt.Sprint(rec.Fields["role"])]; ok {
+		fields = append(fields, wool.Field("role", role))
+	}
 	if pid, ok := rec.Fields["pid"].(string); ok && pid != "" {
 		fields = append(fields, wool.Field("pid", pid))
 	}
-	p.logAt(woolLevel(rec.Level), msg, fields...)
+	level := woolLevel(rec.Level)
+	if level == wool.WARN && p.starting() {
+		diagnostic := diagnose(msg)
+		if diagnostic.Code != "" {
+			fields = append(fields, wool.Field("diagnostic", diagnostic.Code))
+		}
+		p.mu.Lock()
+		p.diagnostics = append(p.diagnostics, diagnostic)
+		p.mu.Unlock()
+	}
+	if event, eventFields := redisEventOf(msg); event != "" {
+		fields = append(fields, wool.Field("event", string(event)))
+		for _, name := range slices.Sorted(maps.Keys(eventFields)) {
+			fields = append(fields, wool.Field(name, eventFields[name]))
+		}
+		if event == eventReady {
+			p.readyOnce.Do(func() { close(p.ready) })
+		}
+		// Transitions are worth seeing even when redis logs them verbosely.
+		if level == wool.DEBUG {
+			level = wool.INFO
+		}
+	}
+	if p.quiet {
+		level = wool.DEBUG
+	}
+	p.log(level, msg, fields...)
 }
 
 func woolLevel(level string) wool.Loglevel {
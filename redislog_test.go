@@ -0,0 +1,159 @@
+package main
+
+import (
+	"reflect"
+	"strings"
+	"testing"
+
+	"github.com/codefly-dev/core/wool"
+)
+
+func TestRedisEventOf(t *testing.T) {
+	tests := []struct {
+		msg    string
+		event  redisEvent
+		fields map[string]string
+	}{
+		{msg: "Ready to accept connections tcp", event: eventReady},
+		{msg: "Background saving started by pid 42", event: eventRDBSaveStarted, fields: map[string]string{"child_pid": "42"}},
+		{msg: "Background saving terminated with success", event: eventRDBSaveFinished},
+		{msg: "Background saving error", event: eventRDBSaveFailed},
+		{msg: "Background append only file rewriting started by pid 51", event: eventAOFRewriteStarted, fields: map[string]string{"child_pid": "51"}},
+		{msg: "Background AOF rewrite finished successfully", event: eventAOFRewriteFinished},
+		{msg: "MASTER <-> REPLICA sync started", event: eventReplicaSyncStarted},
+		{msg: "Replica 10.0.0.7:6379 asks for synchronization", event: eventReplicaSyncStarted, fields: map[string]string{"replica": "10.0.0.7:6379"}},
+		{msg: "Synchronization with replica 10.0.0.7:6379 succeeded", event: eventReplicaSyncDone, fields: map[string]string{"replica": "10.0.0.7:6379"}},
+		{msg: "Out Of Memory allocating 1048576 bytes!", event: eventOutOfMemory, fields: map[string]string{"bytes": "1048576"}},
+		{msg: "Module /modules/search.so failed to load: file not found", event: eventModuleLoadFailed, fields: map[string]string{"module": "/modules/search.so"}},
+		{msg: "Server initialized"},
+	}
+	for _, test := range tests {
+		event, fields := redisEventOf(test.msg)
+		if event != test.event {
+			t.Errorf("redisEventOf(%q) = %q, want %q", test.msg, event, test.event)
+			continue
+		}
+		if len(fields) == 0 && len(test.fields) == 0 {
+			continue
+		}
+		if !reflect.DeepEqual(fields, test.fields) {
+			t.Errorf("redisEventOf(%q) fields = %v, want %v", test.msg, fields, test.fields)
+		}
+	}
+}
+
+func TestRedisLogWriterSignalsReadiness(t *testing.T) {
+	logs := newRedisLogWriter(NewService().Wool, redisInstance{}, RuntimeLogSettings{})
+	_, _ = logs.Write([]byte("1:M 16 Jun 2026 14:56:37.310 * Server initialized\n1:M 16 Jun 2026 14:56:37.312 * Ready to accept "))
+	select {
+	case <-logs.Ready():
+		t.Fatal("ready before the line is complete")
+	default:
+	}
+	_, _ = logs.Write([]byte("connections tcp\n1:M 16 Jun 2026 14:56:38.001 * Ready to accept connections tcp\n"))
+	select {
+	case <-logs.Ready():
+	default:
+		t.Fatal("not ready after redis accepted connections")
+	}
+}
+
+func TestRedisLogWriterCollectsStartupDiagnostics(t *testing.T) {
+	logs := newRedisLogWriter(NewService().Wool, redisInstance{}, RuntimeLogSettings{})
+	_, _ = logs.Write([]byte(`1:C 16 Jun 2026 14:56:37.300 # WARNING Memory overcommit must be enabled! Without it, a background save or replication may fail under low memory condition.
+1:C 16 Jun 2026 14:56:37.301 # Warning: no config file specified, using the default config.
+1:M 16 Jun 2026 14:56:37.305 # Server started with an unusual flag
+1:M 16 Jun 2026 14:56:37.310 * Server initialized
+1:M 16 Jun 2026 14:56:37.312 * Ready to accept connections tcp
+1:M 16 Jun 2026 14:57:00.000 # Connection with replica lost
+`))
+	diagnostics := logs.Diagnostics()
+	var codes []string
+	for _, d := range diagnostics {
+		codes = append(codes, d.Code)
+	}
+	if !reflect.DeepEqual(codes, []string{"overcommit-memory", "no-config-file", ""}) {
+		t.Fatalf("diagnostics = %+v", diagnostics)
+	}
+	if !strings.Contains(diagnostics[0].Remediation, "vm.overcommit_memory=1") {
+		t.Errorf("overcommit remediation = %q", diagnostics[0].Remediation)
+	}
+
+	response, err := NewRuntime().startResponse(diagnostics)
+	if err != nil {
+		t.Fatal(err)
+	}
+	message := response.GetStatus().GetMessage()
+	for _, expected := range []string{"redis warned about its environment", "WARNING Memory overcommit must be enabled!", "; Server started with an unusual flag"} {
+		if !strings.Contains(message, expected) {
+			t.Errorf("start status missing %q: %s", expected, message)
+		}
+	}
+	if response, err = NewRuntime().startResponse(nil); err != nil || response.GetStatus().GetMessage() != "" {
+		t.Errorf("start status without diagnostics = %+v, %v", response.GetStatus(), err)
+	}
+}
+
+func TestRedisLogWriterTagsInstanceAndRole(t *testing.T) {
+	type line struct {
+		level  wool.Loglevel
+		fields map[string]any
+	}
+	capture := func(logs *redisLogWriter) *[]line {
+		var lines []line
+		logs.log = func(level wool.Loglevel, _ string, fields ...*wool.LogField) {
+			captured := line{level: level, fields: map[string]any{}}
+			for _, field := range fields {
+				captured.fields[field.Key] = field.Value
+			}
+			lines = append(lines, captured)
+		}
+		return &lines
+	}
+	output := []byte("1:M 16 Jun 2026 14:56:37.312 * Ready to accept connections tcp\n" +
+		"7:S 16 Jun 2026 14:56:38.000 * MASTER <-> REPLICA sync started\n" +
+		"9:C 16 Jun 2026 14:56:39.000 # Write error saving DB on disk: No space left on device\n" +
+		"1:X 16 Jun 2026 14:56:40.000 # +sdown master mymaster 10.0.0.5 6379\n")
+
+	primary := newRedisLogWriter(NewService().Wool, redisInstance{Name: "redis-0", Port: 6379}, RuntimeLogSettings{Instances: []string{"redis-0"}})
+	lines := capture(primary)
+	_, _ = primary.Write(output)
+	var roles []any
+	for _, l := range *lines {
+		if l.fields["instance"] != "redis-0" || l.fields["port"] != uint16(6379) {
+			t.Errorf("line not tagged with its instance: %v", l.fields)
+		}
+		roles = append(roles, l.fields["role"])
+	}
+	if !reflect.DeepEqual(roles, []any{"primary", "replica", "child", "sentinel"}) {
+		t.Errorf("roles = %v", roles)
+	}
+	if (*lines)[2].level != wool.WARN {
+		t.Errorf("warning logged at %v", (*lines)[2].level)
+	}
+
+	replica := newRedisLogWriter(NewService().Wool, redisInstance{Name: "redis-1", Port: 6380}, RuntimeLogSettings{Instances: []string{"redis-0"}})
+	lines = capture(replica)
+	_, _ = replica.Write(output)
+	for _, l := range *lines {
+		if l.level != wool.DEBUG {
+			t.Errorf("filtered instance logged at %v: %v", l.level, l.fields)
+		}
+	}
+	select {
+	case <-replica.Ready():
+	default:
+		t.Error("filtering the log stream lost the readiness signal")
+	}
+}
+
+func TestRuntimeLogSettingsOnlyNameStartedInstances(t *testing.T) {
+	started := []redisInstance{{Name: "redis", Port: 6379}}
+	if err := (RuntimeLogSettings{Instances: []string{"redis"}}).validate(started); err != nil {
+		t.Errorf("started instance rejected: %v", err)
+	}
+	err := (RuntimeLogSettings{Instances: []string{"redis-1"}}).validate(started)
+	if err == nil || !strings.Contains(err.Error(), `"redis-1"`) {
+		t.Errorf("validate error = %v, want the unknown instance named", err)
+	}
+}
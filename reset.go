@@ -0,0 +1,259 @@
+package main
+
+// reset.go — a clean slate between test runs without recreating the
+// container or the nix process.
+//
+// Runtime.Flush empties every database (FLUSHALL) or the listed ones
+// (FLUSHDB). Runtime.Reset goes further: it flushes everything, puts the
+// server configuration back to what the settings say, then replays the seed
+// file. The agent's `flush` and `reset` commands run them (control.go). The
+// seed file holds redis commands, one per line as redis-cli takes them, in
+// the service directory:
+//
+//	seed: seed.redis
+//	reset-on-start: true
+//
+// The seed is also replayed when redis starts with an empty database 0;
+// reset-on-start resets the instance on every Start instead, dropping
+// whatever the previous run left.
+
+import (
+	"bufio"
+	"context"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+)
+
+// dockerRedisBaseline is what the Docker runtime starts redis with for the
+// directives the agent can change: the image runs redis-server without a
+// config file, so redis' compiled-in defaults apply. Reset restores them when
+// the settings leave one unset.
+var dockerRedisBaseline = []redisDirective{
+	{Name: "maxmemory", Value: "0"},
+	{Name: "maxmemory-policy", Value: "noeviction"},
+	{Name: "save", Value: "3600 1 300 100 60 10000"},
+	{Name: "appendonly", Value: "no"},
+	{Name: "appendfsync", Value: "everysec"},
+	{Name: "notify-keyspace-events", Value: ""},
+	{Name: "slowlog-log-slower-than", Value: "10000"},
+	{Name: "latency-monitor-threshold", Value: "0"},
+}
+
+// Flush deletes the keys of the listed databases, or of every database.
+func (s *Runtime) Flush(ctx context.Context, dbs ...int) error {
+	conn, err := s.dialRunning(ctx)
+	if err != nil {
+		return err
+	}
+	defer conn.Close()
+	return flushRedis(conn, dbs)
+}
+
+func flushRedis(conn *redisConn, dbs []int) error {
+	if len(dbs) == 0 {
+		if _, err := conn.Do("FLUSHALL"); err != nil {
+			return fmt.Errorf("cannot flush redis: %w", err)
+		}
+		return nil
+	}
+	for _, db := range dbs {
+		if _, err := conn.Do("SELECT", strconv.Itoa(db)); err != nil {
+			return fmt.Errorf("cannot select database %d: %w", db, err)
+		}
+		if _, err := conn.Do("FLUSHDB"); err != nil {
+			return fmt.Errorf("cannot flush database %d: %w", db, err)
+		}
+	}
+	return nil
+}
+
+// Reset flushes every database, restores the configured server settings,
+// replays the seed file and provisions the streams again.
+func (s *Runtime) Reset(ctx context.Context) error {
+	seed, err := s.readSeed()
+	if err != nil {
+		return err
+	}
+	conn, err := s.dialRunning(ctx)
+	if err != nil {
+		return err
+	}
+	defer conn.Close()
+	if err = flushRedis(conn, nil); err != nil {
+		return err
+	}
+	if err = resetRedisConfig(conn, s.baselineDirectives(), s.runtimeDirectives()); err != nil {
+		return err
+	}
+	if err = applySeed(conn, seed); err != nil {
+		return err
+	}
+	return provisionStreams(conn, s.Streams)
+}
+
+// baselineDirectives are the values the runtime starts redis with before
+// the settings apply.
+func (s *Runtime) baselineDirectives() []redisDirective {
+	if s.nixRuntime != nil {
+		return nixRedisBaseline
+	}
+	return dockerRedisBaseline
+}
+
+// overrideDirectives returns baseline with the values of overrides.
+func overrideDirectives(baseline []redisDirective, overrides []redisDirective) []redisDirective {
+	values := map[string]string{}
+	for _, directive := range overrides {
+		values[directive.Name] = directive.Value
+	}
+	directives := make([]redisDirective, 0, len(baseline))
+	for _, directive := range baseline {
+		if value, ok := values[directive.Name]; ok {
+			directive.Value = value
+		}
+		directives = append(directives, directive)
+	}
+	return directives
+}
+
+// runtimeDirectives are the directives the settings set on the running
+// redis, the slowlog thresholds included.
+func (s *Runtime) runtimeDirectives() []redisDirective {
+	directives := s.redisDirectives()
+	if s.slowlog != nil {
+		directives = append(directives, s.slowlog.directives()...)
+	}
+	return directives
+}
+
+// resetRedisConfig sets every directive of baseline, to its value in
+// directives when they set it, and clears the server statistics.
+func resetRedisConfig(conn *redisConn, baseline []redisDirective, directives []redisDirective) error {
+	args := []string{"CONFIG", "SET"}
+	for _, directive := range overrideDirectives(baseline, directives) {
+		args = append(args, directive.Name, directive.Value)
+	}
+	if _, err := conn.Do(args...); err != nil {
+		return fmt.Errorf("cannot reset the redis configuration: %w", err)
+	}
+	if _, err := conn.Do("CONFIG", "RESETSTAT"); err != nil {
+		return fmt.Errorf("cannot reset the redis statistics: %w", err)
+	}
+	return nil
+}
+
+// seedOnStart resets redis when reset-on-start is set, or replays the seed
+// into an empty redis.
+func (s *Runtime) seedOnStart(ctx context.Context) error {
+	if s.ResetOnStart {
+		s.Infof("resetting redis")
+		return s.Reset(ctx)
+	}
+	seed, err := s.readSeed()
+	if err != nil || len(seed) == 0 {
+		return err
+	}
+	conn, err := s.dialRunning(ctx)
+	if err != nil {
+		return err
+	}
+	defer conn.Close()
+	reply, err := conn.Do("DBSIZE")
+	if err != nil {
+		return err
+	}
+	if size, _ := reply.(int64); size > 0 {
+		s.Wool.Debug("redis has data: not seeding")
+		return nil
+	}
+	s.Infof("seeding redis from %s", s.Seed)
+	return applySeed(conn, seed)
+}
+
+// seedCommand is one command of the seed file.
+type seedCommand struct {
+	Line int
+	Args []string
+}
+
+func (s *Runtime) readSeed() ([]seedCommand, error) {
+	if s.Seed == "" {
+		return nil, nil
+	}
+	file, err := os.Open(filepath.Join(s.Location, s.Seed))
+	if err != nil {
+		return nil, fmt.Errorf("cannot read the redis seed: %w", err)
+	}
+	defer file.Close()
+	return parseSeed(file, s.Seed)
+}
+
+// parseSeed reads redis-cli style commands: bare or "quoted" arguments, one
+// command per line, # comments.
+func parseSeed(r io.Reader, name string) ([]seedCommand, error) {
+	var commands []seedCommand
+	scanner := bufio.NewScanner(r)
+	scanner.Buffer(nil, 1<<20)
+	for number := 1; scanner.Scan(); number++ {
+		line := strings.TrimSpace(scanner.Text())
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+		args, err := splitCommandLine(line)
+		if err != nil {
+			return nil, fmt.Errorf("%s:%d: %w", name, number, err)
+		}
+		commands = append(commands, seedCommand{Line: number, Args: args})
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, fmt.Errorf("cannot read the redis seed: %w", err)
+	}
+	return commands, nil
+}
+
+// splitCommandLine splits a redis-cli command line into its arguments.
+func splitCommandLine(line string) ([]string, error) {
+	var args []string
+	for i := 0; i < len(line); {
+		switch line[i] {
+		case ' ', '\t':
+			i++
+		case '"':
+			arg, next, err := readQuotedArg(line, i)
+			if err != nil {
+				return nil, err
+			}
+			args = append(args, arg)
+			i = next
+		default:
+			end := strings.IndexAny(line[i:], " \t")
+			if end < 0 {
+				end = len(line) - i
+			}
+			args = append(args, line[i:i+end])
+			i += end
+		}
+	}
+	return args, nil
+}
+
+func applySeed(conn *redisConn, seed []seedCommand) error {
+	for _, command := range seed {
+		if _, err := conn.Do(command.Args...); err != nil {
+			return fmt.Errorf("seed line %d: %w", command.Line, err)
+		}
+	}
+	return nil
+}
+
+// dialRunning connects to the running redis with the configured password.
+func (s *Runtime) dialRunning(ctx context.Context) (*redisConn, error) {
+	if s.redisAddress == "" {
+		return nil, fmt.Errorf("redis is not running")
+	}
+	return dialRedis(ctx, s.redisAddress, s.redisPassword)
+}
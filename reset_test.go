@@ -0,0 +1,127 @@
+package main
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"sync"
+	"testing"
+)
+
+func TestSplitCommandLine(t *testing.T) {
+	args, err := splitCommandLine(`HSET user:1 name "Ada Lovelace" bio "line\nbreak"  visits 3`)
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := []string{"HSET", "user:1", "name", "Ada Lovelace", "bio", "line\nbreak", "visits", "3"}
+	if !reflect.DeepEqual(args, want) {
+		t.Errorf("splitCommandLine = %q, want %q", args, want)
+	}
+	if _, err = splitCommandLine(`SET greeting "hello`); err == nil {
+		t.Error("unterminated argument accepted")
+	}
+}
+
+func TestRuntimeResetFlushesConfiguresAndSeeds(t *testing.T) {
+	var mu sync.Mutex
+	var commands []string
+	server := newFakeRedis(t, func(args []string) string {
+		mu.Lock()
+		defer mu.Unlock()
+		commands = append(commands, strings.Join(args, " "))
+		if strings.ToUpper(args[0]) == "DBSIZE" {
+			return ":0\r\n"
+		}
+		return "+OK\r\n"
+	})
+	location := t.TempDir()
+	seed := "# fixtures\nSET greeting \"hello world\"\n\nSELECT 1\nRPUSH jobs a b\n"
+	if err := os.WriteFile(filepath.Join(location, "seed.redis"), []byte(seed), 0o600); err != nil {
+		t.Fatal(err)
+	}
+
+	runtime := NewRuntime()
+	runtime.Location = location
+	runtime.redisAddress = server.address
+	runtime.Seed = "seed.redis"
+	runtime.MaxMemory = "100mb"
+	runtime.Persistence.Save = "off"
+	ctx := context.Background()
+
+	if err := runtime.Reset(ctx); err != nil {
+		t.Fatal(err)
+	}
+	want := []string{
+		"FLUSHALL",
+		"CONFIG SET maxmemory 100mb maxmemory-policy noeviction save  appendonly no appendfsync everysec notify-keyspace-events  slowlog-log-slower-than 10000 latency-monitor-threshold 0",
+		"CONFIG RESETSTAT",
+		"SET greeting hello world",
+		"SELECT 1",
+		"RPUSH jobs a b",
+	}
+	if !reflect.DeepEqual(commands, want) {
+		t.Errorf("Reset ran\n%s\nwant\n%s", strings.Join(commands, "\n"), strings.Join(want, "\n"))
+	}
+
+	// The slowlog tap's thresholds survive a reset.
+	tap, err := newSlowlogTap(RuntimeSlowlogSettings{Enabled: true, Threshold: "5ms"}, runtime.Wool, server.address, "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	runtime.slowlog = tap
+	commands = nil
+	if err = runtime.Reset(ctx); err != nil {
+		t.Fatal(err)
+	}
+	if len(commands) < 2 || !strings.HasSuffix(commands[1], "slowlog-log-slower-than 5000 latency-monitor-threshold 5") {
+		t.Errorf("Reset with the slowlog tap ran %q", commands)
+	}
+	runtime.slowlog = nil
+
+	// The nix runtime's config file turns snapshots and the AOF off: a reset
+	// keeps them off rather than restoring redis' compiled-in defaults.
+	runtime.nixRuntime = &nixRedis{}
+	runtime.Persistence.Save = ""
+	commands = nil
+	if err = runtime.Reset(ctx); err != nil {
+		t.Fatal(err)
+	}
+	if len(commands) < 2 || !strings.Contains(commands[1], "save  appendonly no") {
+		t.Errorf("nix Reset ran %q, want its startup persistence", commands)
+	}
+	runtime.nixRuntime = nil
+
+	commands = nil
+	if err := runtime.Flush(ctx, 0, 2); err != nil {
+		t.Fatal(err)
+	}
+	if got := strings.Join(commands, ", "); got != "SELECT 0, FLUSHDB, SELECT 2, FLUSHDB" {
+		t.Errorf("Flush ran %s", got)
+	}
+
+	// Without reset-on-start, an empty redis is seeded as is.
+	commands = nil
+	if err := runtime.seedOnStart(ctx); err != nil {
+		t.Fatal(err)
+	}
+	if got := strings.Join(commands, ", "); got != "DBSIZE, SET greeting hello world, SELECT 1, RPUSH jobs a b" {
+		t.Errorf("seedOnStart ran %s", got)
+	}
+}
+
+func TestRuntimeResetReportsSeedErrors(t *testing.T) {
+	location := t.TempDir()
+	if err := os.WriteFile(filepath.Join(location, "seed.redis"), []byte("SET a 1\nSET b \"2\n"), 0o600); err != nil {
+		t.Fatal(err)
+	}
+	runtime := NewRuntime()
+	runtime.Location = location
+	runtime.redisAddress = "127.0.0.1:1"
+	runtime.Seed = "seed.redis"
+	err := runtime.Reset(context.Background())
+	if err == nil || !strings.Contains(err.Error(), "seed.redis:2") {
+		t.Errorf("Reset = %v, want an error at seed.redis:2", err)
+	}
+}
@@ -0,0 +1,162 @@
+package main
+
+// respclient.go — a minimal RESP2 client for the agent's own calls to the
+// local redis (INFO polling, inspection). It speaks just enough of the
+// protocol for request/reply commands; applications use a real client.
+
+import (
+	"bufio"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// redisError is an error reply (`-ERR ...`) from the server.
+type redisError string
+
+func (e redisError) Error() string { return string(e) }
+
+// redisConn is a single connection to redis. It is not safe for concurrent
+// use.
+type redisConn struct {
+	conn   net.Conn
+	reader *bufio.Reader
+	// timeout bounds each read and write; zero waits indefinitely, for
+	// connections streaming pushed replies (MONITOR, SUBSCRIBE).
+	timeout time.Duration
+}
+
+const redisCommandTimeout = 5 * time.Second
+
+// dialRedis connects to address and authenticates when password is set.
+func dialRedis(ctx context.Context, address, password string) (*redisConn, error) {
+	var dialer net.Dialer
+	dialCtx, cancel := context.WithTimeout(ctx, redisCommandTimeout)
+	defer cancel()
+	conn, err := dialer.DialContext(dialCtx, "tcp", address)
+	if err != nil {
+		return nil, fmt.Errorf("cannot connect to redis at %s: %w", address, err)
+	}
+	c := &redisConn{conn: conn, reader: bufio.NewReader(conn), timeout: redisCommandTimeout}
+	if password != "" {
+		if _, err = c.Do("AUTH", password); err != nil {
+			_ = conn.Close()
+			return nil, fmt.Errorf("cannot authenticate to redis: %w", err)
+		}
+	}
+	return c, nil
+}
+
+func (c *redisConn) Close() error {
+	return c.conn.Close()
+}
+
+// Do sends one command and reads its reply. Replies decode to string (simple
+// and bulk strings), int64, []any (arrays) or nil; error replies are returned
+// as redisError.
+func (c *redisConn) Do(args ...string) (any, error) {
+	if err := c.Send(args...); err != nil {
+		return nil, err
+	}
+	return c.Receive()
+}
+
+// Send writes one command without waiting for its reply.
+func (c *redisConn) Send(args ...string) error {
+	var command strings.Builder
+	fmt.Fprintf(&command, "*%d\r\n", len(args))
+	for _, arg := range args {
+		fmt.Fprintf(&command, "$%d\r\n%s\r\n", len(arg), arg)
+	}
+	if err := c.conn.SetWriteDeadline(c.deadline()); err != nil {
+		return err
+	}
+	_, err := io.WriteString(c.conn, command.String())
+	return err
+}
+
+// Receive reads the next reply.
+func (c *redisConn) Receive() (any, error) {
+	if err := c.conn.SetReadDeadline(c.deadline()); err != nil {
+		return nil, err
+	}
+	return c.readReply()
+}
+
+func (c *redisConn) deadline() time.Time {
+	if c.timeout == 0 {
+		return time.Time{}
+	}
+	return time.Now().Add(c.timeout)
+}
+
+func (c *redisConn) readReply() (any, error) {
+	line, err := c.reader.ReadString('\n')
+	if err != nil {
+		return nil, err
+	}
+	line = strings.TrimSuffix(line, "\r\n")
+	if line == "" {
+		return nil, errors.New("empty redis reply")
+	}
+	payload := line[1:]
+	switch line[0] {
+	case '+':
+		return payload, nil
+	case '-':
+		return nil, redisError(payload)
+	case ':':
+		return strconv.ParseInt(payload, 10, 64)
+	case '$':
+		size, err := strconv.Atoi(payload)
+		if err != nil {
+			return nil, fmt.Errorf("invalid bulk length %q", payload)
+		}
+		if size < 0 {
+			return nil, nil
+		}
+		buf := make([]byte, size+2)
+		if _, err = io.ReadFull(c.reader, buf); err != nil {
+			return nil, err
+		}
+		return string(buf[:size]), nil
+	case '*':
+		count, err := strconv.Atoi(payload)
+		if err != nil {
+			return nil, fmt.Errorf("invalid array length %q", payload)
+		}
+		if count < 0 {
+			return nil, nil
+		}
+		items := make([]any, 0, count)
+		for i := 0; i < count; i++ {
+			item, err := c.readReply()
+			if err != nil {
+				// An error element does not end the array; keep reading so
+				// the connection stays in sync.
+				var replyErr redisError
+				if !errors.As(err, &replyErr) {
+					return nil, err
+				}
+				item = replyErr
+			}
+			items = append(items, item)
+		}
+		return items, nil
+	}
+	return nil, fmt.Errorf("unexpected redis reply %q", line)
+}
+
+// replyString converts a string reply, as returned by Do, to a string.
+func replyString(reply any) (string, error) {
+	value, ok := reply.(string)
+	if !ok {
+		return "", fmt.Errorf("unexpected redis reply %T, want string", reply)
+	}
+	return value, nil
+}
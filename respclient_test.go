@@ -0,0 +1,134 @@
+package main
+
+import (
+	"bufio"
+	"context"
+	"fmt"
+	"io"
+	"net"
+	"strconv"
+	"strings"
+	"testing"
+)
+
+// fakeRedis is a RESP server that answers each command with the reply
+// returned by handle, already encoded.
+type fakeRedis struct {
+	address string
+}
+
+func newFakeRedis(t *testing.T, handle func(args []string) string) *fakeRedis {
+	t.Helper()
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { _ = listener.Close() })
+	go func() {
+		for {
+			conn, err := listener.Accept()
+			if err != nil {
+				return
+			}
+			go serveFakeRedis(conn, handle)
+		}
+	}()
+	return &fakeRedis{address: listener.Addr().String()}
+}
+
+func serveFakeRedis(conn net.Conn, handle func(args []string) string) {
+	defer conn.Close()
+	reader := bufio.NewReader(conn)
+	for {
+		args, err := readFakeCommand(reader)
+		if err != nil {
+			return
+		}
+		if _, err = io.WriteString(conn, handle(args)); err != nil {
+			return
+		}
+	}
+}
+
+func readFakeCommand(reader *bufio.Reader) ([]string, error) {
+	line, err := reader.ReadString('\n')
+	if err != nil {
+		return nil, err
+	}
+	count, err := strconv.Atoi(strings.TrimSpace(strings.TrimPrefix(line, "*")))
+	if err != nil {
+		return nil, err
+	}
+	args := make([]string, 0, count)
+	for i := 0; i < count; i++ {
+		header, err := reader.ReadString('\n')
+		if err != nil {
+			return nil, err
+		}
+		size, err := strconv.Atoi(strings.TrimSpace(strings.TrimPrefix(header, "$")))
+		if err != nil {
+			return nil, err
+		}
+		buf := make([]byte, size+2)
+		if _, err = io.ReadFull(reader, buf); err != nil {
+			return nil, err
+		}
+		args = append(args, string(buf[:size]))
+	}
+	return args, nil
+}
+
+func bulkReply(s string) string {
+	return fmt.Sprintf("$%d\r\n%s\r\n", len(s), s)
+}
+
+func TestRedisConnDecodesReplies(t *testing.T) {
+	server := newFakeRedis(t, func(args []string) string {
+		switch strings.ToUpper(args[0]) {
+		case "AUTH":
+			if args[1] != "secret" {
+				return "-WRONGPASS invalid password\r\n"
+			}
+			return "+OK\r\n"
+		case "GET":
+			return bulkReply("value\r\nwith newline")
+		case "DBSIZE":
+			return ":42\r\n"
+		case "MISSING":
+			return "$-1\r\n"
+		case "MIXED":
+			return "*3\r\n+OK\r\n-ERR nested\r\n*1\r\n:1\r\n"
+		}
+		return "-ERR unknown command\r\n"
+	})
+
+	conn, err := dialRedis(context.Background(), server.address, "secret")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+	if _, err = dialRedis(context.Background(), server.address, "wrong"); err == nil {
+		t.Fatal("dial with a wrong password succeeded")
+	}
+
+	if reply, err := conn.Do("GET", "key"); err != nil || reply != "value\r\nwith newline" {
+		t.Errorf("GET = %#v, %v", reply, err)
+	}
+	if reply, err := conn.Do("DBSIZE"); err != nil || reply != int64(42) {
+		t.Errorf("DBSIZE = %#v, %v", reply, err)
+	}
+	if reply, err := conn.Do("MISSING"); err != nil || reply != nil {
+		t.Errorf("MISSING = %#v, %v", reply, err)
+	}
+	reply, err := conn.Do("MIXED")
+	if err != nil {
+		t.Fatal(err)
+	}
+	items, ok := reply.([]any)
+	if !ok || len(items) != 3 || items[0] != "OK" || items[1] != redisError("ERR nested") {
+		t.Errorf("MIXED = %#v", reply)
+	}
+	if _, err = conn.Do("NOPE"); err == nil || err.Error() != "ERR unknown command" {
+		t.Errorf("NOPE error = %v", err)
+	}
+}
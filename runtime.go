@@ -26,7 +26,27 @@ type Runtime struct {
 	// RuntimeContextNix — redis runs natively from a nix-provisioned binary.
 	nixRuntime *nixRedis
 
+	// metricsExporter serves the local metrics endpoint when enabled.
+	metricsExporter *infoExporter
+
+	// slowlog reports slow commands when enabled.
+	slowlog *slowlogTap
+
+	// monitor streams the commands redis runs, while tapped.
+	monitor *commandMonitor
+
+	// control serves the operations on the running redis.
+	control *controlServer
+
+	// scripts are loaded into redis once it is ready.
+	scripts []redisScript
+
+	// logs renders the redis output; it signals readiness from the log.
+	logs *redisLogWriter
+
 	redisPort uint16
+	// redisAddress is where the agent reaches the running redis.
+	redisAddress string
 }
 
 func NewRuntime() *Runtime {
@@ -90,6 +110,12 @@ func (s *Runtime) Init(ctx context.Context, req *runtimev0.InitRequest) (*runtim
 
 	s.Infof("will run on %s", instance.Host)
 	s.redisPort = 6379
+	s.redisAddress = instance.Address
+
+	s.scripts, err = readScripts(s.Location, s.Scripts)
+	if err != nil {
+		return s.Runtime.InitError(err)
+	}
 
 	// Create connection string resources for the network instance
 	for _, inst := range net.Instances {
@@ -97,22 +123,39 @@ func (s *Runtime) Init(ctx context.Context, req *runtimev0.InitRequest) (*runtim
 		if errConn != nil {
 			return s.Runtime.InitError(errConn)
 		}
+		conf = withConfigurationValues(conf, scriptConfigurationValues(s.scripts)...)
+		conf = withConfigurationValues(conf, s.Notifications.configurationValues()...)
 		w.Debug("adding configuration", wool.Field("config", resources.MakeConfigurationSummary(conf)), wool.Field("instance", inst))
 		s.Runtime.RuntimeConfigurations = append(s.Runtime.RuntimeConfigurations, conf)
 	}
 	s.Wool.Debug("sending runtime configuration", wool.Field("conf", resources.MakeManyConfigurationSummary(s.Runtime.RuntimeConfigurations)))
 
+	started := redisInstance{Name: s.Identity.Name, Port: uint16(instance.Port)}
+	if err = s.Logs.validate([]redisInstance{started}); err != nil {
+		return s.Runtime.InitError(err)
+	}
+	s.logs = newRedisLogWriter(s.Wool, started, s.Logs)
+
 	// Load password from configuration — needed by both runtimes.
 	if err = s.LoadConfiguration(ctx, configuration); err != nil {
 		return s.Runtime.InitError(err)
 	}
+	if err = s.validateRedisConfig(); err != nil {
+		return s.Runtime.InitError(err)
+	}
+	if err = s.Monitor.validate(); err != nil {
+		return s.Runtime.InitError(err)
+	}
+	if err = validateStreams(s.Streams); err != nil {
+		return s.Runtime.InitError(err)
+	}
 
 	// Nix runtime: run redis natively from a nix-provisioned binary instead of a
 	// Docker container — selected when the caller requests RuntimeContextNix
 	// (e.g. a host without Docker). Same port, so WaitForReady is unchanged.
 	if rc := req.GetRuntimeContext(); rc != nil && rc.Kind == resources.RuntimeContextNix {
 		s.Infof("using nix runtime for redis on port %d", instance.Port)
-		nixr, errNix := newNixRedis(ctx, s.Location, uint16(instance.Port), s.redisPassword, newRedisLogWriter(s.Wool))
+		nixr, errNix := newNixRedis(ctx, s.Location, uint16(instance.Port), s.redisPassword, s.redisDirectives(), s.logs)
 		if errNix != nil {
 			return s.Runtime.InitError(errNix)
 		}
@@ -122,17 +165,23 @@ func (s *Runtime) Init(ctx context.Context, req *runtimev0.InitRequest) (*runtim
 		s.nixRuntime = nixr
 	} else {
 		// Docker: container redis on 6379, mapped to the assigned port.
-		runner, errDocker := dockerrun.NewDockerHeadlessEnvironment(ctx, image, s.UniqueWithWorkspace())
+		img, errImage := s.redisImage()
+		if errImage != nil {
+			return s.Runtime.InitError(errImage)
+		}
+		runner, errDocker := dockerrun.NewDockerHeadlessEnvironment(ctx, img, s.UniqueWithWorkspace())
 		if errDocker != nil {
 			return s.Runtime.InitError(errDocker)
 		}
-		runner.WithOutput(newRedisLogWriter(s.Wool))
+		runner.WithOutput(s.logs)
 		runner.WithPortMapping(ctx, uint16(instance.Port), s.redisPort)
 		if s.redisPassword != "" {
 			runner.WithEnvironmentVariables(ctx,
 				resources.Env("REDIS_PASSWORD", s.redisPassword),
 			)
-			runner.WithCommand(redisDockerCommand()...)
+			runner.WithCommand(redisDockerCommand(redisServerFlags(s.redisDirectives())...)...)
+		} else if flags := redisServerFlags(s.redisDirectives()); len(flags) > 0 {
+			runner.WithCommand(append([]string{"redis-server"}, flags...)...)
 		}
 		s.runnerEnvironment = runner
 		w.Debug("init for runner environment: will start container")
@@ -141,14 +190,31 @@ func (s *Runtime) Init(ctx context.Context, req *runtimev0.InitRequest) (*runtim
 		}
 	}
 
+	if s.Metrics.Enabled {
+		exporter, errMetrics := newInfoExporter(s.Metrics, instance.Address, s.redisPassword)
+		if errMetrics != nil {
+			return s.Runtime.InitError(errMetrics)
+		}
+		s.metricsExporter = exporter
+	}
+
+	if s.Slowlog.Enabled {
+		tap, errSlowlog := newSlowlogTap(s.Slowlog, s.Wool, instance.Address, s.redisPassword)
+		if errSlowlog != nil {
+			return s.Runtime.InitError(errSlowlog)
+		}
+		s.slowlog = tap
+	}
+
 	s.Wool.Debug("init successful")
 	return s.Runtime.InitResponse()
 }
 
-func redisDockerCommand() []string {
+func redisDockerCommand(flags ...string) []string {
 	// Keep the password out of docker inspect's process argv. The fixed shell
-	// fragment expands the container environment variable inside the container.
-	return []string{"sh", "-c", `exec redis-server --requirepass "$REDIS_PASSWORD"`}
+	// fragment expands the container environment variable inside the container;
+	// the non-secret flags follow as positional parameters ("redis-server" is $0).
+	return append([]string{"sh", "-c", `exec redis-server --requirepass "$REDIS_PASSWORD" "$@"`, "redis-server"}, flags...)
 }
 
 func (s *Runtime) WaitForReady(ctx context.Context) error {
@@ -167,6 +233,10 @@ func (s *Runtime) WaitForReady(ctx context.Context) error {
 	address := instance.Address
 	s.Wool.Debug("waiting for redis to be ready", wool.Field("address", address))
 
+	var ready <-chan struct{}
+	if s.logs != nil {
+		ready = s.logs.Ready()
+	}
 	maxRetry := 10
 	for retry := 0; retry < maxRetry; retry++ {
 		conn, err := net.DialTimeout("tcp", address, 2*time.Second)
@@ -186,7 +256,15 @@ func (s *Runtime) WaitForReady(ctx context.Context) error {
 			conn.Close()
 		}
 		s.Wool.Debug("waiting for redis to be ready", wool.ErrField(err))
-		time.Sleep(2 * time.Second)
+		// Redis logging that it accepts connections cuts the wait short.
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+		case <-ready:
+			// Closed for good: wait on the timer from now on.
+			ready = nil
+		case <-time.After(2 * time.Second):
+		}
 	}
 	return s.Wool.NewError("redis is not ready")
 }
@@ -202,8 +280,73 @@ func (s *Runtime) Start(ctx context.Context, req *runtimev0.StartRequest) (*runt
 		return s.Runtime.StartError(err)
 	}
 
+	diagnostics := s.reportStartupDiagnostics(ctx)
+
+	if err = s.seedOnStart(ctx); err != nil {
+		return s.Runtime.StartError(err)
+	}
+
+	if err = s.loadScripts(ctx); err != nil {
+		return s.Runtime.StartError(err)
+	}
+
+	if err = s.provisionStreams(ctx); err != nil {
+		return s.Runtime.StartError(err)
+	}
+
+	if s.metricsExporter != nil {
+		if err = s.metricsExporter.Start(); err != nil {
+			return s.Runtime.StartError(err)
+		}
+		s.Infof("serving redis metrics on http://%s/metrics", s.metricsExporter.Address())
+	}
+
+	if s.slowlog != nil {
+		if err = s.slowlog.Start(ctx); err != nil {
+			return s.Runtime.StartError(err)
+		}
+	}
+
+	if s.Monitor.Enabled {
+		if err = s.StartMonitor(ctx, s.Monitor); err != nil {
+			return s.Runtime.StartError(err)
+		}
+	}
+
+	if err = s.startControl(); err != nil {
+		return s.Runtime.StartError(err)
+	}
+
 	s.Wool.Debug("start done")
-	return s.Runtime.StartResponse()
+	return s.startResponse(diagnostics)
+}
+
+// reportStartupDiagnostics shows the warnings redis logged while starting.
+// The ready line may trail the first successful PING; the warnings precede it.
+func (s *Runtime) reportStartupDiagnostics(ctx context.Context) []startupDiagnostic {
+	if s.logs == nil {
+		return nil
+	}
+	select {
+	case <-s.logs.Ready():
+	case <-ctx.Done():
+	case <-time.After(time.Second):
+	}
+	diagnostics := s.logs.Diagnostics()
+	for _, diagnostic := range diagnostics {
+		s.Infof("redis startup warning: %s", diagnostic)
+	}
+	return diagnostics
+}
+
+// startResponse carries the startup diagnostics in the start status.
+func (s *Runtime) startResponse(diagnostics []startupDiagnostic) (*runtimev0.StartResponse, error) {
+	response, err := s.Runtime.StartResponse()
+	if err != nil || len(diagnostics) == 0 {
+		return response, err
+	}
+	response.Status.Message = startupDiagnosticsMessage(diagnostics)
+	return response, nil
 }
 
 func (s *Runtime) Stop(ctx context.Context, req *runtimev0.StopRequest) (*runtimev0.StopResponse, error) {
@@ -220,6 +363,21 @@ func (s *Runtime) Destroy(ctx context.Context, req *runtimev0.DestroyRequest) (*
 
 	s.Wool.Debug("Destroying")
 
+	if err := s.stopControl(ctx); err != nil {
+		return s.Runtime.DestroyError(err)
+	}
+	if s.slowlog != nil {
+		s.slowlog.Stop()
+	}
+	if err := s.StopMonitor(); err != nil {
+		return s.Runtime.DestroyError(err)
+	}
+	if s.metricsExporter != nil {
+		if err := s.metricsExporter.Stop(ctx); err != nil {
+			return s.Runtime.DestroyError(err)
+		}
+	}
+
 	// Nix runtime: terminate the native redis process; there is no container.
 	if s.nixRuntime != nil {
 		if err := s.nixRuntime.Stop(ctx); err != nil {
@@ -228,7 +386,11 @@ func (s *Runtime) Destroy(ctx context.Context, req *runtimev0.DestroyRequest) (*
 		return s.Runtime.DestroyResponse()
 	}
 
-	runner, err := dockerrun.NewDockerHeadlessEnvironment(ctx, image, s.UniqueWithWorkspace())
+	img, err := s.redisImage()
+	if err != nil {
+		return s.Runtime.DestroyError(err)
+	}
+	runner, err := dockerrun.NewDockerHeadlessEnvironment(ctx, img, s.UniqueWithWorkspace())
 	if err != nil {
 		return s.Runtime.DestroyError(err)
 	}
@@ -0,0 +1,346 @@
+package main
+
+// sbomdiff.go — what an upgrade changes inside the redis image.
+//
+// Upgrade logs the diff for the candidate core proposes, dry run or not, so
+// relicensed packages show up before the lockfile moves. Both images go
+// through core's SBOMContainer, like SBOM does. The packages
+// are read from the response generically: any message with a name and a
+// version is a package, and a CycloneDX JSON document carried in a string
+// field is read as well. That keeps the diff independent of how core lays out
+// its response.
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"slices"
+	"sort"
+	"strings"
+
+	builderv0 "github.com/codefly-dev/core/generated/go/codefly/services/builder/v0"
+	"github.com/codefly-dev/core/resources"
+	"google.golang.org/protobuf/proto"
+	"google.golang.org/protobuf/reflect/protoreflect"
+)
+
+// SBOMPackage is one package of an image.
+type SBOMPackage struct {
+	Name     string   `json:"name"`
+	Version  string   `json:"version"`
+	Licenses []string `json:"licenses,omitempty"`
+}
+
+// SBOMPackageChange is a package present in both images with a different
+// version or licences.
+type SBOMPackageChange struct {
+	Name            string   `json:"name"`
+	FromVersion     string   `json:"from-version"`
+	ToVersion       string   `json:"to-version"`
+	FromLicenses    []string `json:"from-licenses,omitempty"`
+	ToLicenses      []string `json:"to-licenses,omitempty"`
+	LicensesChanged bool     `json:"licenses-changed,omitempty"`
+}
+
+// SBOMDiff compares the packages of the current image with the candidate's.
+type SBOMDiff struct {
+	Current   string `json:"current"`
+	Candidate string `json:"candidate"`
+
+	Added   []SBOMPackage `json:"added,omitempty"`
+	Removed []SBOMPackage `json:"removed,omitempty"`
+	// Upgraded lists packages whose version changed, including downgrades.
+	Upgraded []SBOMPackageChange `json:"upgraded,omitempty"`
+	// Relicensed lists packages whose licences changed at the same version.
+	Relicensed []SBOMPackageChange `json:"relicensed,omitempty"`
+}
+
+// sbomDiff compares the packages of the current image with the candidate's.
+func (s *Builder) sbomDiff(ctx context.Context, current, candidate *resources.DockerImage) (*SBOMDiff, error) {
+	before, err := s.imagePackages(ctx, current)
+	if err != nil {
+		return nil, err
+	}
+	after, err := s.imagePackages(ctx, candidate)
+	if err != nil {
+		return nil, err
+	}
+	diff := diffPackages(before, after)
+	diff.Current, diff.Candidate = current.FullName(), candidate.FullName()
+	return diff, nil
+}
+
+// summary lists the changes on one line, relicensing first.
+func (d *SBOMDiff) summary() string {
+	var changes []string
+	for _, change := range d.Relicensed {
+		changes = append(changes, fmt.Sprintf("%s relicensed %s -> %s", change.Name, licenses(change.FromLicenses), licenses(change.ToLicenses)))
+	}
+	for _, change := range d.Upgraded {
+		line := fmt.Sprintf("%s %s -> %s", change.Name, change.FromVersion, change.ToVersion)
+		if change.LicensesChanged {
+			line += fmt.Sprintf(" relicensed %s -> %s", licenses(change.FromLicenses), licenses(change.ToLicenses))
+		}
+		changes = append(changes, line)
+	}
+	for _, p := range d.Added {
+		changes = append(changes, "+"+p.Name+" "+p.Version)
+	}
+	for _, p := range d.Removed {
+		changes = append(changes, "-"+p.Name+" "+p.Version)
+	}
+	if len(changes) == 0 {
+		return "no package changes"
+	}
+	return strings.Join(changes, ", ")
+}
+
+func licenses(names []string) string {
+	if len(names) == 0 {
+		return "(none)"
+	}
+	return strings.Join(names, " AND ")
+}
+
+func (s *Builder) imagePackages(ctx context.Context, img *resources.DockerImage) ([]SBOMPackage, error) {
+	response, err := s.Builder.SBOMContainer(ctx, imageReference(img))
+	if err != nil {
+		return nil, fmt.Errorf("cannot generate the SBOM of %s: %w", img.FullName(), err)
+	}
+	packages := sbomPackages(response)
+	if len(packages) == 0 {
+		return nil, fmt.Errorf("the SBOM of %s lists no packages", img.FullName())
+	}
+	return packages, nil
+}
+
+// combineSBOMs builds the SBOM of the service from the SBOMs of its images,
+// the redis image first: the response describes the redis image, and lists
+// the packages of every image, from its package list and its document.
+func combineSBOMs(sboms []*builderv0.SBOMResponse) *builderv0.SBOMResponse {
+	combined := &builderv0.SBOMResponse{
+		Name:     sboms[0].GetName(),
+		Version:  sboms[0].GetVersion(),
+		Document: sboms[0].GetDocument(),
+	}
+	for _, sbom := range sboms {
+		seen := map[string]bool{}
+		add := func(p SBOMPackage) {
+			if p.Name == "" || p.Version == "" || seen[p.Name+"@"+p.Version] {
+				return
+			}
+			seen[p.Name+"@"+p.Version] = true
+			combined.Packages = append(combined.Packages, &builderv0.SBOMPackage{Name: p.Name, Version: p.Version, Licenses: p.Licenses})
+		}
+		for _, p := range sbom.GetPackages() {
+			add(SBOMPackage{Name: p.Name, Version: p.Version, Licenses: p.Licenses})
+		}
+		collectCycloneDXPackages(sbom.GetDocument(), add)
+	}
+	return combined
+}
+
+// diffPackages compares packages by name.
+func diffPackages(before, after []SBOMPackage) *SBOMDiff {
+	old := map[string]SBOMPackage{}
+	for _, p := range before {
+		old[p.Name] = p
+	}
+	diff := &SBOMDiff{}
+	seen := map[string]bool{}
+	for _, p := range after {
+		seen[p.Name] = true
+		previous, ok := old[p.Name]
+		if !ok {
+			diff.Added = append(diff.Added, p)
+			continue
+		}
+		change := SBOMPackageChange{
+			Name:            p.Name,
+			FromVersion:     previous.Version,
+			ToVersion:       p.Version,
+			FromLicenses:    previous.Licenses,
+			ToLicenses:      p.Licenses,
+			LicensesChanged: !slices.Equal(previous.Licenses, p.Licenses),
+		}
+		switch {
+		case previous.Version != p.Version:
+			diff.Upgraded = append(diff.Upgraded, change)
+		case change.LicensesChanged:
+			diff.Relicensed = append(diff.Relicensed, change)
+		}
+	}
+	for _, p := range before {
+		if !seen[p.Name] {
+			diff.Removed = append(diff.Removed, p)
+		}
+	}
+	return diff
+}
+
+// sbomPackages collects the packages of an SBOM response, sorted by name.
+// Versions of a package listed more than once are joined.
+func sbomPackages(response proto.Message) []SBOMPackage {
+	byName := map[string]*SBOMPackage{}
+	add := func(p SBOMPackage) {
+		if p.Name == "" || p.Version == "" {
+			return
+		}
+		known, ok := byName[p.Name]
+		if !ok {
+			known = &SBOMPackage{Name: p.Name}
+			byName[p.Name] = known
+		}
+		known.Version = joinSorted(known.Version, p.Version)
+		for _, license := range p.Licenses {
+			if license != "" && !slices.Contains(known.Licenses, license) {
+				known.Licenses = append(known.Licenses, license)
+			}
+		}
+	}
+	if response != nil && response.ProtoReflect().IsValid() {
+		// The response itself names the image, not a package.
+		collectPackages(response.ProtoReflect(), add)
+	}
+	packages := make([]SBOMPackage, 0, len(byName))
+	for _, p := range byName {
+		sort.Strings(p.Licenses)
+		packages = append(packages, *p)
+	}
+	sort.Slice(packages, func(i, j int) bool { return packages[i].Name < packages[j].Name })
+	return packages
+}
+
+func joinSorted(versions, version string) string {
+	if versions == "" {
+		return version
+	}
+	all := strings.Split(versions, ", ")
+	if slices.Contains(all, version) {
+		return versions
+	}
+	all = append(all, version)
+	sort.Strings(all)
+	return strings.Join(all, ", ")
+}
+
+// collectPackages walks message, reporting every nested name/version message
+// and every package of embedded CycloneDX documents. It returns message read
+// as a package.
+func collectPackages(message protoreflect.Message, add func(SBOMPackage)) SBOMPackage {
+	var p SBOMPackage
+	message.Range(func(field protoreflect.FieldDescriptor, value protoreflect.Value) bool {
+		name := strings.ToLower(string(field.Name()))
+		switch {
+		case field.IsMap():
+			value.Map().Range(func(_ protoreflect.MapKey, entry protoreflect.Value) bool {
+				if field.MapValue().Kind() == protoreflect.MessageKind {
+					add(collectPackages(entry.Message(), add))
+				}
+				return true
+			})
+		case field.IsList():
+			list := value.List()
+			for i := 0; i < list.Len(); i++ {
+				switch field.Kind() {
+				case protoreflect.MessageKind:
+					if isLicenseField(name) {
+						p.Licenses = append(p.Licenses, licenseName(list.Get(i).Message()))
+					} else {
+						add(collectPackages(list.Get(i).Message(), add))
+					}
+				case protoreflect.StringKind:
+					if isLicenseField(name) {
+						p.Licenses = append(p.Licenses, list.Get(i).String())
+					}
+				}
+			}
+		case field.Kind() == protoreflect.MessageKind:
+			if isLicenseField(name) {
+				p.Licenses = append(p.Licenses, licenseName(value.Message()))
+			} else {
+				add(collectPackages(value.Message(), add))
+			}
+		case field.Kind() == protoreflect.StringKind:
+			switch text := value.String(); {
+			case name == "name":
+				p.Name = text
+			case name == "version":
+				p.Version = text
+			case isLicenseField(name):
+				p.Licenses = append(p.Licenses, text)
+			case strings.HasPrefix(strings.TrimSpace(text), "{"):
+				collectCycloneDXPackages(text, add)
+			}
+		case field.Kind() == protoreflect.BytesKind:
+			collectCycloneDXPackages(string(value.Bytes()), add)
+		}
+		return true
+	})
+	return p
+}
+
+func isLicenseField(name string) bool {
+	return strings.HasPrefix(name, "license") || strings.HasPrefix(name, "licence")
+}
+
+// licenseName reads a license message: its SPDX id, expression or name.
+func licenseName(message protoreflect.Message) string {
+	var name string
+	message.Range(func(field protoreflect.FieldDescriptor, value protoreflect.Value) bool {
+		if field.Kind() != protoreflect.StringKind || field.IsList() {
+			return true
+		}
+		switch strings.ToLower(string(field.Name())) {
+		case "id", "spdx_id", "expression":
+			name = value.String()
+			return false
+		case "name", "value":
+			name = value.String()
+		}
+		return true
+	})
+	return name
+}
+
+// cycloneDX is the subset of a CycloneDX JSON document the diff reads.
+type cycloneDX struct {
+	Components []cycloneDXComponent `json:"components"`
+}
+
+type cycloneDXComponent struct {
+	Name     string `json:"name"`
+	Version  string `json:"version"`
+	Licenses []struct {
+		License struct {
+			ID   string `json:"id"`
+			Name string `json:"name"`
+		} `json:"license"`
+		Expression string `json:"expression"`
+	} `json:"licenses"`
+	Components []cycloneDXComponent `json:"components"`
+}
+
+func collectCycloneDXPackages(document string, add func(SBOMPackage)) {
+	var bom cycloneDX
+	if json.Unmarshal([]byte(document), &bom) != nil {
+		return
+	}
+	var walk func([]cycloneDXComponent)
+	walk = func(components []cycloneDXComponent) {
+		for _, component := range components {
+			p := SBOMPackage{Name: component.Name, Version: component.Version}
+			for _, license := range component.Licenses {
+				for _, name := range []string{license.License.ID, license.License.Name, license.Expression} {
+					if name != "" {
+						p.Licenses = append(p.Licenses, name)
+						break
+					}
+				}
+			}
+			add(p)
+			walk(component.Components)
+		}
+	}
+	walk(bom.Components)
+}
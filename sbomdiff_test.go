@@ -0,0 +1,155 @@
+package main
+
+import (
+	"reflect"
+	"testing"
+
+	builderv0 "github.com/codefly-dev/core/generated/go/codefly/services/builder/v0"
+	"google.golang.org/protobuf/proto"
+	"google.golang.org/protobuf/reflect/protodesc"
+	"google.golang.org/protobuf/reflect/protoreflect"
+	"google.golang.org/protobuf/types/descriptorpb"
+	"google.golang.org/protobuf/types/dynamicpb"
+)
+
+func TestSBOMPackages(t *testing.T) {
+	response := newTestSBOMResponse(t)
+	set := func(message protoreflect.Message, field string, value string) {
+		message.Set(message.Descriptor().Fields().ByName(protoreflect.Name(field)), protoreflect.ValueOfString(value))
+	}
+	set(response, "name", "redis")
+	set(response, "version", "8.8.0-alpine")
+	set(response, "document", `{"components":[
+		{"name":"busybox","version":"1.37.0-r12","licenses":[{"license":{"id":"GPL-2.0-only"}}]},
+		{"name":"redis","version":"8.8.0","components":[{"name":"jemalloc","version":"5.3.0","licenses":[{"expression":"BSD-2-Clause"}]}]}]}`)
+	packages := response.Mutable(response.Descriptor().Fields().ByName("packages")).List()
+	for _, p := range []SBOMPackage{
+		{Name: "musl", Version: "1.2.5-r9", Licenses: []string{"MIT"}},
+		{Name: "musl", Version: "1.2.5-r10", Licenses: []string{"MIT"}},
+		{Name: "busybox", Version: "1.37.0-r12"},
+	} {
+		element := packages.NewElement().Message()
+		set(element, "name", p.Name)
+		set(element, "version", p.Version)
+		for _, license := range p.Licenses {
+			licenses := element.Mutable(element.Descriptor().Fields().ByName("licenses")).List()
+			licenses.Append(protoreflect.ValueOfString(license))
+		}
+		packages.Append(protoreflect.ValueOfMessage(element))
+	}
+
+	got := sbomPackages(response.Interface())
+	want := []SBOMPackage{
+		{Name: "busybox", Version: "1.37.0-r12", Licenses: []string{"GPL-2.0-only"}},
+		{Name: "jemalloc", Version: "5.3.0", Licenses: []string{"BSD-2-Clause"}},
+		{Name: "musl", Version: "1.2.5-r10, 1.2.5-r9", Licenses: []string{"MIT"}},
+		{Name: "redis", Version: "8.8.0"},
+	}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("sbomPackages =\n%+v\nwant\n%+v", got, want)
+	}
+}
+
+func TestCombineSBOMs(t *testing.T) {
+	redis := &builderv0.SBOMResponse{
+		Name:     "redis",
+		Version:  "8.8.0-alpine",
+		Document: `{"components":[{"name":"redis","version":"8.8.0"}]}`,
+		Packages: []*builderv0.SBOMPackage{{Name: "musl", Version: "1.2.5-r9", Licenses: []string{"MIT"}}},
+	}
+	exporter := &builderv0.SBOMResponse{
+		Name:     "redis_exporter",
+		Version:  "v1.67.0",
+		Packages: []*builderv0.SBOMPackage{{Name: "redis_exporter", Version: "1.67.0", Licenses: []string{"MIT"}}},
+	}
+	combined := combineSBOMs([]*builderv0.SBOMResponse{redis, exporter})
+	if combined.GetName() != "redis" || combined.GetVersion() != "8.8.0-alpine" || combined.GetDocument() != redis.GetDocument() {
+		t.Errorf("combined SBOM describes %s %s, want the redis image", combined.GetName(), combined.GetVersion())
+	}
+	var got []string
+	for _, p := range combined.GetPackages() {
+		got = append(got, p.Name+" "+p.Version)
+	}
+	want := []string{"musl 1.2.5-r9", "redis 8.8.0", "redis_exporter 1.67.0"}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("packages = %v, want %v", got, want)
+	}
+}
+
+func TestDiffPackages(t *testing.T) {
+	before := []SBOMPackage{
+		{Name: "busybox", Version: "1.37.0-r12", Licenses: []string{"GPL-2.0-only"}},
+		{Name: "libssl3", Version: "3.3.2-r0", Licenses: []string{"Apache-2.0"}},
+		{Name: "musl", Version: "1.2.5-r9", Licenses: []string{"MIT"}},
+		{Name: "redis", Version: "8.8.0", Licenses: []string{"BSD-3-Clause"}},
+	}
+	after := []SBOMPackage{
+		{Name: "busybox", Version: "1.37.0-r12", Licenses: []string{"GPL-2.0-only"}},
+		{Name: "libssl3", Version: "3.3.3-r0", Licenses: []string{"Apache-2.0"}},
+		{Name: "musl", Version: "1.2.5-r9", Licenses: []string{"MIT", "BSD-2-Clause"}},
+		{Name: "redis", Version: "8.10.1", Licenses: []string{"AGPL-3.0-only"}},
+		{Name: "tzdata", Version: "2026a-r0"},
+	}
+	diff := diffPackages(before, after)
+	want := &SBOMDiff{
+		Added: []SBOMPackage{{Name: "tzdata", Version: "2026a-r0"}},
+		Upgraded: []SBOMPackageChange{
+			{Name: "libssl3", FromVersion: "3.3.2-r0", ToVersion: "3.3.3-r0", FromLicenses: []string{"Apache-2.0"}, ToLicenses: []string{"Apache-2.0"}},
+			{Name: "redis", FromVersion: "8.8.0", ToVersion: "8.10.1", FromLicenses: []string{"BSD-3-Clause"}, ToLicenses: []string{"AGPL-3.0-only"}, LicensesChanged: true},
+		},
+		Relicensed: []SBOMPackageChange{
+			{Name: "musl", FromVersion: "1.2.5-r9", ToVersion: "1.2.5-r9", FromLicenses: []string{"MIT"}, ToLicenses: []string{"MIT", "BSD-2-Clause"}, LicensesChanged: true},
+		},
+	}
+	if !reflect.DeepEqual(diff, want) {
+		t.Errorf("diffPackages =\n%+v\nwant\n%+v", diff, want)
+	}
+	if removed := diffPackages(after, before).Removed; len(removed) != 1 || removed[0].Name != "tzdata" {
+		t.Errorf("removed = %+v, want tzdata", removed)
+	}
+	summary := "musl relicensed MIT -> MIT AND BSD-2-Clause, libssl3 3.3.2-r0 -> 3.3.3-r0, " +
+		"redis 8.8.0 -> 8.10.1 relicensed BSD-3-Clause -> AGPL-3.0-only, +tzdata 2026a-r0"
+	if got := diff.summary(); got != summary {
+		t.Errorf("summary = %q, want %q", got, summary)
+	}
+	if got := diffPackages(before, before).summary(); got != "no package changes" {
+		t.Errorf("summary without changes = %q", got)
+	}
+}
+
+// newTestSBOMResponse builds a response message shaped like an SBOM: the
+// image's name and version, a package list and a raw document.
+func newTestSBOMResponse(t *testing.T) *dynamicpb.Message {
+	t.Helper()
+	field := func(name string, number int32, label descriptorpb.FieldDescriptorProto_Label, kind descriptorpb.FieldDescriptorProto_Type, typeName string) *descriptorpb.FieldDescriptorProto {
+		f := &descriptorpb.FieldDescriptorProto{Name: proto.String(name), Number: proto.Int32(number), Label: label.Enum(), Type: kind.Enum(), JsonName: proto.String(name)}
+		if typeName != "" {
+			f.TypeName = proto.String(typeName)
+		}
+		return f
+	}
+	optional, repeated := descriptorpb.FieldDescriptorProto_LABEL_OPTIONAL, descriptorpb.FieldDescriptorProto_LABEL_REPEATED
+	text, message := descriptorpb.FieldDescriptorProto_TYPE_STRING, descriptorpb.FieldDescriptorProto_TYPE_MESSAGE
+	file, err := protodesc.NewFile(&descriptorpb.FileDescriptorProto{
+		Name:    proto.String("sbom_test.proto"),
+		Package: proto.String("sbomtest"),
+		Syntax:  proto.String("proto3"),
+		MessageType: []*descriptorpb.DescriptorProto{
+			{Name: proto.String("Package"), Field: []*descriptorpb.FieldDescriptorProto{
+				field("name", 1, optional, text, ""),
+				field("version", 2, optional, text, ""),
+				field("licenses", 3, repeated, text, ""),
+			}},
+			{Name: proto.String("Response"), Field: []*descriptorpb.FieldDescriptorProto{
+				field("name", 1, optional, text, ""),
+				field("version", 2, optional, text, ""),
+				field("packages", 3, repeated, message, ".sbomtest.Package"),
+				field("document", 4, optional, text, ""),
+			}},
+		},
+	}, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return dynamicpb.NewMessage(file.Messages().ByName("Response"))
+}
@@ -0,0 +1,173 @@
+package main
+
+// scripts.go — Lua scripts and Redis Functions loaded into redis.
+//
+// The `scripts` setting lists .lua files, or globs of them, in the service
+// directory:
+//
+//	scripts:
+//	  - lua/*.lua
+//
+// A file starting with a `#!lua name=<library>` line is a Redis Functions
+// library, loaded with FUNCTION LOAD REPLACE; any other file is a script,
+// loaded with SCRIPT LOAD. The runtime loads them once redis is ready, and
+// Kubernetes pods load them from a ConfigMap in a post-start hook.
+//
+// A script's SHA1 only depends on its source, so dependents get it as the
+// `script-<file name>` configuration value, computed without redis, and can
+// EVALSHA it directly.
+
+import (
+	"context"
+	"crypto/sha1"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"regexp"
+	"slices"
+	"strings"
+
+	basev0 "github.com/codefly-dev/core/generated/go/codefly/base/v0"
+)
+
+// redisScript is one Lua file of the scripts setting.
+type redisScript struct {
+	// Name is the file name without .lua.
+	Name string
+	Body string
+	// Library is the Functions library the file declares, if any.
+	Library string
+	// SHA is the SCRIPT LOAD digest of a script.
+	SHA string
+}
+
+var (
+	scriptName     = regexp.MustCompile(`^[A-Za-z0-9_-]+$`)
+	libraryShebang = regexp.MustCompile(`^#!lua\s+name=([A-Za-z0-9_]+)`)
+)
+
+// readScripts reads the files the patterns of the scripts setting match in
+// the service directory, sorted by name.
+func readScripts(location string, patterns []string) ([]redisScript, error) {
+	var files []string
+	for _, pattern := range patterns {
+		matches, err := filepath.Glob(filepath.Join(location, pattern))
+		if err != nil {
+			return nil, fmt.Errorf("invalid scripts pattern %q", pattern)
+		}
+		if len(matches) == 0 {
+			return nil, fmt.Errorf("scripts pattern %q matches no file", pattern)
+		}
+		files = append(files, matches...)
+	}
+	slices.Sort(files)
+	files = slices.Compact(files)
+
+	scripts := make([]redisScript, 0, len(files))
+	names := map[string]string{}
+	libraries := map[string]string{}
+	for _, file := range files {
+		base := filepath.Base(file)
+		name, ok := strings.CutSuffix(base, ".lua")
+		if !ok || !scriptName.MatchString(name) {
+			return nil, fmt.Errorf("script %s: want a <name>.lua file, name made of letters, digits, - and _", base)
+		}
+		if other, taken := names[name]; taken {
+			return nil, fmt.Errorf("scripts %s and %s have the same name", other, file)
+		}
+		names[name] = file
+		body, err := os.ReadFile(file)
+		if err != nil {
+			return nil, fmt.Errorf("cannot read script: %w", err)
+		}
+		script := redisScript{Name: name, Body: string(body)}
+		if match := libraryShebang.FindStringSubmatch(script.Body); match != nil {
+			script.Library = match[1]
+			if other, taken := libraries[script.Library]; taken {
+				return nil, fmt.Errorf("scripts %s and %s declare the same library %s", other, file, script.Library)
+			}
+			libraries[script.Library] = file
+		} else {
+			sum := sha1.Sum(body)
+			script.SHA = hex.EncodeToString(sum[:])
+		}
+		scripts = append(scripts, script)
+	}
+	return scripts, nil
+}
+
+// scriptConfigurationValues export the SHA of every script.
+func scriptConfigurationValues(scripts []redisScript) []*basev0.ConfigurationValue {
+	var values []*basev0.ConfigurationValue
+	for _, script := range scripts {
+		if script.Library == "" {
+			values = append(values, &basev0.ConfigurationValue{Key: "script-" + script.Name, Value: script.SHA})
+		}
+	}
+	return values
+}
+
+// loadScripts loads the scripts and libraries into redis.
+func loadScripts(conn *redisConn, scripts []redisScript) error {
+	for _, script := range scripts {
+		if script.Library != "" {
+			if _, err := conn.Do("FUNCTION", "LOAD", "REPLACE", script.Body); err != nil {
+				return fmt.Errorf("cannot load function library %s: %w", script.Library, err)
+			}
+			continue
+		}
+		reply, err := conn.Do("SCRIPT", "LOAD", script.Body)
+		if err != nil {
+			return fmt.Errorf("cannot load script %s: %w", script.Name, err)
+		}
+		if sha, _ := reply.(string); sha != script.SHA {
+			return fmt.Errorf("script %s loaded as %s, want %s", script.Name, sha, script.SHA)
+		}
+	}
+	return nil
+}
+
+// loadScripts loads the scripts of the settings into the running redis.
+func (s *Runtime) loadScripts(ctx context.Context) error {
+	if len(s.scripts) == 0 {
+		return nil
+	}
+	conn, err := s.dialRunning(ctx)
+	if err != nil {
+		return err
+	}
+	defer conn.Close()
+	if err = loadScripts(conn, s.scripts); err != nil {
+		return err
+	}
+	s.Infof("loaded %d redis scripts", len(s.scripts))
+	return nil
+}
+
+// scriptFile is a script of the scripts ConfigMap.
+type scriptFile struct {
+	File string
+	// Content is the source as a YAML double-quoted string.
+	Content string
+	// Library is set for a Functions library.
+	Library bool
+}
+
+func scriptFiles(scripts []redisScript) []scriptFile {
+	files := make([]scriptFile, 0, len(scripts))
+	for _, script := range scripts {
+		// JSON strings are valid YAML double-quoted scalars.
+		var content strings.Builder
+		encoder := json.NewEncoder(&content)
+		encoder.SetEscapeHTML(false)
+		_ = encoder.Encode(script.Body)
+		files = append(files, scriptFile{
+			File:    script.Name + ".lua",
+			Content: strings.TrimSuffix(content.String(), "\n"),
+			Library: script.Library != "",
+		})
+	}
+	return files
+}
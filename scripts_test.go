@@ -0,0 +1,84 @@
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func writeScripts(t *testing.T, files map[string]string) string {
+	t.Helper()
+	location := t.TempDir()
+	for file, content := range files {
+		path := filepath.Join(location, file)
+		if err := os.MkdirAll(filepath.Dir(path), 0o755); err != nil {
+			t.Fatal(err)
+		}
+		if err := os.WriteFile(path, []byte(content), 0o600); err != nil {
+			t.Fatal(err)
+		}
+	}
+	return location
+}
+
+func TestReadScripts(t *testing.T) {
+	location := writeScripts(t, map[string]string{
+		"lua/get.lua":     "return redis.call(\"GET\", KEYS[1])\n",
+		"lua/counter.lua": "#!lua name=counter\nredis.register_function('incr', function(keys) return redis.call('INCR', keys[1]) end)\n",
+		"lua/notes.txt":   "not lua",
+	})
+	scripts, err := readScripts(location, []string{"lua/*.lua", "lua/get.lua"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(scripts) != 2 {
+		t.Fatalf("readScripts = %+v", scripts)
+	}
+	if scripts[0].Name != "counter" || scripts[0].Library != "counter" || scripts[0].SHA != "" {
+		t.Errorf("library = %+v", scripts[0])
+	}
+	if scripts[1].Name != "get" || scripts[1].Library != "" || scripts[1].SHA != "b4e82ca290e49ece9d97fde8bca8cda04045e267" {
+		t.Errorf("script = %+v", scripts[1])
+	}
+	values := scriptConfigurationValues(scripts)
+	if len(values) != 1 || values[0].Key != "script-get" || values[0].Value != scripts[1].SHA {
+		t.Errorf("scriptConfigurationValues = %v", values)
+	}
+
+	for _, patterns := range [][]string{{"lua/missing-*.lua"}, {"lua/notes.txt"}, {"["}} {
+		if _, err = readScripts(location, patterns); err == nil {
+			t.Errorf("readScripts(%q) accepted", patterns)
+		}
+	}
+}
+
+func TestLoadScripts(t *testing.T) {
+	var commands []string
+	server := newFakeRedis(t, func(args []string) string {
+		commands = append(commands, strings.Join(args[:len(args)-1], " "))
+		if strings.ToUpper(args[0]) == "SCRIPT" {
+			return bulkReply("e0e1f9fabfc9d4800c877a703b823ac0578ff8db")
+		}
+		return bulkReply("counter")
+	})
+	conn, err := dialRedis(t.Context(), server.address, "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+	scripts := []redisScript{
+		{Name: "counter", Body: "#!lua name=counter\n", Library: "counter"},
+		{Name: "one", Body: "return 1", SHA: "e0e1f9fabfc9d4800c877a703b823ac0578ff8db"},
+	}
+	if err = loadScripts(conn, scripts); err != nil {
+		t.Fatal(err)
+	}
+	if got := strings.Join(commands, ", "); got != "FUNCTION LOAD REPLACE, SCRIPT LOAD" {
+		t.Errorf("loadScripts ran %s", got)
+	}
+	scripts[1].SHA = "0000000000000000000000000000000000000000"
+	if err = loadScripts(conn, scripts[1:]); err == nil {
+		t.Error("mismatching SHA accepted")
+	}
+}
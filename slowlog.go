@@ -0,0 +1,304 @@
+package main
+
+// slowlog.go — slow commands of the local redis, surfaced in the agent's log.
+//
+// With `slowlog.enabled`, the runtime sets slowlog-log-slower-than and
+// latency-monitor-threshold on the running redis, then polls SLOWLOG GET and
+// LATENCY LATEST and logs every new entry once:
+//
+//	slowlog:
+//	  enabled: true
+//	  threshold: 5ms
+//
+// Only the runtime's redis is tuned; deployments keep redis' defaults.
+
+import (
+	"context"
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+	"unicode"
+
+	"github.com/codefly-dev/core/wool"
+)
+
+// RuntimeSlowlogSettings configure slow command reporting.
+type RuntimeSlowlogSettings struct {
+	Enabled bool `yaml:"enabled,omitempty"`
+	// Threshold above which a command is slow. Defaults to 10ms.
+	Threshold string `yaml:"threshold,omitempty"`
+	// Interval between polls. Defaults to 5s.
+	Interval string `yaml:"interval,omitempty"`
+}
+
+const (
+	defaultSlowlogThreshold = 10 * time.Millisecond
+	defaultSlowlogInterval  = 5 * time.Second
+	// slowlogBatch is how many entries one poll reads.
+	slowlogBatch = "128"
+)
+
+// slowlogTap polls the slowlog and latency monitor of one redis.
+type slowlogTap struct {
+	redisAddress  string
+	redisPassword string
+	threshold     time.Duration
+	interval      time.Duration
+	// log reports an entry; Wool's Warn outside tests.
+	log func(msg string, fields ...*wool.LogField)
+
+	// primed is set by the first poll, which only records where the slowlog
+	// and latency monitor stand.
+	primed bool
+	// lastID is the newest slowlog entry seen.
+	lastID int64
+	// latencies is the time of the latest spike reported per event.
+	latencies map[string]int64
+
+	cancel context.CancelFunc
+	done   chan struct{}
+}
+
+func newSlowlogTap(settings RuntimeSlowlogSettings, w *wool.Wool, redisAddress, redisPassword string) (*slowlogTap, error) {
+	tap := &slowlogTap{
+		redisAddress:  redisAddress,
+		redisPassword: redisPassword,
+		threshold:     defaultSlowlogThreshold,
+		interval:      defaultSlowlogInterval,
+		log:           w.Warn,
+		lastID:        -1,
+		latencies:     map[string]int64{},
+	}
+	if settings.Threshold != "" {
+		threshold, err := time.ParseDuration(settings.Threshold)
+		if err != nil || threshold < time.Microsecond {
+			return nil, fmt.Errorf("invalid slowlog threshold %q", settings.Threshold)
+		}
+		tap.threshold = threshold
+	}
+	if settings.Interval != "" {
+		interval, err := time.ParseDuration(settings.Interval)
+		if err != nil || interval <= 0 {
+			return nil, fmt.Errorf("invalid slowlog interval %q", settings.Interval)
+		}
+		tap.interval = interval
+	}
+	return tap, nil
+}
+
+// Start configures redis and polls it until Stop.
+func (t *slowlogTap) Start(ctx context.Context) error {
+	if t.cancel != nil {
+		return nil
+	}
+	if err := t.configure(ctx); err != nil {
+		return err
+	}
+	ctx, cancel := context.WithCancel(context.Background())
+	t.cancel = cancel
+	t.done = make(chan struct{})
+	go t.run(ctx)
+	return nil
+}
+
+func (t *slowlogTap) Stop() {
+	if t.cancel == nil {
+		return
+	}
+	t.cancel()
+	<-t.done
+	t.cancel = nil
+}
+
+// directives are the thresholds: the slowlog counts microseconds, the
+// latency monitor whole milliseconds.
+func (t *slowlogTap) directives() []redisDirective {
+	latency := max(t.threshold.Milliseconds(), 1)
+	return []redisDirective{
+		{Name: "slowlog-log-slower-than", Value: strconv.FormatInt(t.threshold.Microseconds(), 10)},
+		{Name: "latency-monitor-threshold", Value: strconv.FormatInt(latency, 10)},
+	}
+}
+
+// configure sets the thresholds.
+func (t *slowlogTap) configure(ctx context.Context) error {
+	conn, err := dialRedis(ctx, t.redisAddress, t.redisPassword)
+	if err != nil {
+		return err
+	}
+	defer conn.Close()
+	args := []string{"CONFIG", "SET"}
+	for _, directive := range t.directives() {
+		args = append(args, directive.Name, directive.Value)
+	}
+	if _, err = conn.Do(args...); err != nil {
+		return fmt.Errorf("cannot enable the redis slowlog: %w", err)
+	}
+	return nil
+}
+
+func (t *slowlogTap) run(ctx context.Context) {
+	defer close(t.done)
+	ticker := time.NewTicker(t.interval)
+	defer ticker.Stop()
+	for {
+		// A failed poll is retried at the next tick: redis may be restarting.
+		_ = t.poll(ctx)
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+	}
+}
+
+// poll reports the slowlog entries and latency spikes since the last poll.
+// The first poll only records where the slowlog stands.
+func (t *slowlogTap) poll(ctx context.Context) error {
+	conn, err := dialRedis(ctx, t.redisAddress, t.redisPassword)
+	if err != nil {
+		return err
+	}
+	defer conn.Close()
+	reply, err := conn.Do("SLOWLOG", "GET", slowlogBatch)
+	if err != nil {
+		return err
+	}
+	entries, err := parseSlowlog(reply)
+	if err != nil {
+		return err
+	}
+	first := !t.primed
+	t.primed = true
+	if len(entries) > 0 && entries[0].ID < t.lastID {
+		// Entry IDs start over when redis restarts.
+		t.lastID = -1
+	}
+	// SLOWLOG GET lists the newest entry first.
+	for i := len(entries) - 1; i >= 0; i-- {
+		entry := entries[i]
+		if entry.ID <= t.lastID {
+			continue
+		}
+		t.lastID = entry.ID
+		if first {
+			continue
+		}
+		fields := []*wool.LogField{
+			wool.Field("command", entry.Command),
+			wool.Field("duration", entry.Duration),
+			wool.Field("client", entry.Client),
+		}
+		if entry.ClientName != "" {
+			fields = append(fields, wool.Field("client-name", entry.ClientName))
+		}
+		t.log("slow redis command", fields...)
+	}
+
+	reply, err = conn.Do("LATENCY", "LATEST")
+	if err != nil {
+		return err
+	}
+	spikes, err := parseLatencyLatest(reply)
+	if err != nil {
+		return err
+	}
+	for _, spike := range spikes {
+		if spike.At <= t.latencies[spike.Event] {
+			continue
+		}
+		t.latencies[spike.Event] = spike.At
+		if !first {
+			t.log("redis latency spike", wool.Field("event", spike.Event),
+				wool.Field("duration", spike.Latest), wool.Field("max", spike.Max))
+		}
+	}
+	return nil
+}
+
+// slowlogEntry is one SLOWLOG GET entry.
+type slowlogEntry struct {
+	ID         int64
+	Duration   time.Duration
+	Command    string
+	Client     string
+	ClientName string
+}
+
+func parseSlowlog(reply any) ([]slowlogEntry, error) {
+	items, ok := reply.([]any)
+	if !ok && reply != nil {
+		return nil, fmt.Errorf("unexpected SLOWLOG GET reply %T", reply)
+	}
+	entries := make([]slowlogEntry, 0, len(items))
+	for _, item := range items {
+		fields, ok := item.([]any)
+		if !ok || len(fields) < 4 {
+			return nil, fmt.Errorf("unexpected slowlog entry %#v", item)
+		}
+		id, okID := fields[0].(int64)
+		micros, okDuration := fields[2].(int64)
+		args, okArgs := fields[3].([]any)
+		if !okID || !okDuration || !okArgs {
+			return nil, fmt.Errorf("unexpected slowlog entry %#v", item)
+		}
+		command := make([]string, 0, len(args))
+		for _, arg := range args {
+			command = append(command, quoteArg(fmt.Sprint(arg)))
+		}
+		entry := slowlogEntry{ID: id, Duration: time.Duration(micros) * time.Microsecond, Command: strings.Join(command, " ")}
+		// Client address and name exist since redis 4.0.
+		if len(fields) >= 6 {
+			entry.Client, _ = fields[4].(string)
+			entry.ClientName, _ = fields[5].(string)
+		}
+		entries = append(entries, entry)
+	}
+	return entries, nil
+}
+
+// quoteArg quotes an argument that would not read back as one word.
+func quoteArg(arg string) string {
+	if arg == "" || strings.ContainsFunc(arg, func(r rune) bool { return r == '"' || unicode.IsSpace(r) || !unicode.IsPrint(r) }) {
+		return strconv.Quote(arg)
+	}
+	return arg
+}
+
+// latencySpike is one LATENCY LATEST row.
+type latencySpike struct {
+	Event string
+	// At is the unix time of the latest spike.
+	At     int64
+	Latest time.Duration
+	Max    time.Duration
+}
+
+func parseLatencyLatest(reply any) ([]latencySpike, error) {
+	items, ok := reply.([]any)
+	if !ok && reply != nil {
+		return nil, fmt.Errorf("unexpected LATENCY LATEST reply %T", reply)
+	}
+	spikes := make([]latencySpike, 0, len(items))
+	for _, item := range items {
+		fields, ok := item.([]any)
+		if !ok || len(fields) < 4 {
+			return nil, fmt.Errorf("unexpected latency row %#v", item)
+		}
+		event, okEvent := fields[0].(string)
+		at, okAt := fields[1].(int64)
+		latest, okLatest := fields[2].(int64)
+		worst, okMax := fields[3].(int64)
+		if !okEvent || !okAt || !okLatest || !okMax {
+			return nil, fmt.Errorf("unexpected latency row %#v", item)
+		}
+		spikes = append(spikes, latencySpike{
+			Event:  event,
+			At:     at,
+			Latest: time.Duration(latest) * time.Millisecond,
+			Max:    time.Duration(worst) * time.Millisecond,
+		})
+	}
+	return spikes, nil
+}
@@ -0,0 +1,105 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/codefly-dev/core/wool"
+)
+
+func TestSlowlogTapReportsNewEntries(t *testing.T) {
+	var mu sync.Mutex
+	var configured []string
+	slowlog := []string{slowlogEntryReply(3, 15000, "KEYS", "*")}
+	latency := []string{"*4\r\n" + bulkReply("command") + ":1700000000\r\n:15\r\n:15\r\n"}
+	server := newFakeRedis(t, func(args []string) string {
+		mu.Lock()
+		defer mu.Unlock()
+		switch strings.ToUpper(args[0]) {
+		case "CONFIG":
+			configured = args[2:]
+			return "+OK\r\n"
+		case "SLOWLOG":
+			return fmt.Sprintf("*%d\r\n%s", len(slowlog), strings.Join(slowlog, ""))
+		case "LATENCY":
+			return fmt.Sprintf("*%d\r\n%s", len(latency), strings.Join(latency, ""))
+		}
+		return "-ERR unknown command\r\n"
+	})
+
+	tap, err := newSlowlogTap(RuntimeSlowlogSettings{Enabled: true, Threshold: "2500us"}, NewService().Wool, server.address, "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	var reported []string
+	tap.log = func(msg string, fields ...*wool.LogField) {
+		line := msg
+		for _, field := range fields {
+			line += fmt.Sprintf(" %s=%v", field.Key, field.Value)
+		}
+		reported = append(reported, line)
+	}
+	ctx := context.Background()
+	if err = tap.configure(ctx); err != nil {
+		t.Fatal(err)
+	}
+	if got := strings.Join(configured, " "); got != "slowlog-log-slower-than 2500 latency-monitor-threshold 2" {
+		t.Errorf("CONFIG SET %s", got)
+	}
+
+	// What happened before the tap started is not reported.
+	if err = tap.poll(ctx); err != nil {
+		t.Fatal(err)
+	}
+	if len(reported) != 0 {
+		t.Fatalf("first poll reported %v", reported)
+	}
+
+	mu.Lock()
+	slowlog = append([]string{
+		slowlogEntryReply(5, 42000, "HGETALL", "session:1"),
+		slowlogEntryReply(4, 3100, "SET", "greeting", "hello world"),
+	}, slowlog...)
+	latency = append(latency, "*4\r\n"+bulkReply("fork")+":1700000100\r\n:30\r\n:40\r\n")
+	mu.Unlock()
+	if err = tap.poll(ctx); err != nil {
+		t.Fatal(err)
+	}
+	want := []string{
+		`slow redis command command=SET greeting "hello world" duration=3.1ms client=127.0.0.1:5000 client-name=worker`,
+		`slow redis command command=HGETALL session:1 duration=42ms client=127.0.0.1:5000 client-name=worker`,
+		`redis latency spike event=fork duration=30ms max=40ms`,
+	}
+	if strings.Join(reported, "\n") != strings.Join(want, "\n") {
+		t.Errorf("reported:\n%s\nwant:\n%s", strings.Join(reported, "\n"), strings.Join(want, "\n"))
+	}
+
+	reported = nil
+	if err = tap.poll(ctx); err != nil || len(reported) != 0 {
+		t.Errorf("unchanged slowlog reported %v, %v", reported, err)
+	}
+}
+
+func TestNewSlowlogTapValidatesSettings(t *testing.T) {
+	for _, settings := range []RuntimeSlowlogSettings{{Threshold: "fast"}, {Threshold: "100ns"}, {Interval: "0s"}} {
+		if _, err := newSlowlogTap(settings, NewService().Wool, "localhost:6379", ""); err == nil {
+			t.Errorf("newSlowlogTap(%+v) accepted", settings)
+		}
+	}
+	tap, err := newSlowlogTap(RuntimeSlowlogSettings{}, NewService().Wool, "localhost:6379", "")
+	if err != nil || tap.threshold != 10*time.Millisecond || tap.interval != 5*time.Second {
+		t.Errorf("defaults = %+v, %v", tap, err)
+	}
+}
+
+func slowlogEntryReply(id, micros int, args ...string) string {
+	command := fmt.Sprintf("*%d\r\n", len(args))
+	for _, arg := range args {
+		command += bulkReply(arg)
+	}
+	return fmt.Sprintf("*6\r\n:%d\r\n:1700000000\r\n:%d\r\n%s%s%s", id, micros, command, bulkReply("127.0.0.1:5000"), bulkReply("worker"))
+}
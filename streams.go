@@ -0,0 +1,148 @@
+package main
+
+// streams.go — streams and consumer groups that exist before any consumer
+// starts.
+//
+// Consumers racing to XGROUP CREATE their group on first start is a common
+// source of flaky event-driven services. The `streams` setting declares them
+// instead:
+//
+//	streams:
+//	  - name: orders
+//	    groups: [billing, shipping]
+//	    start-id: "0"
+//	    maxlen: 100000
+//
+// The runtime provisions them once redis is ready, and again after a Reset;
+// deployments run a Job doing the same against the primary. Provisioning is
+// idempotent: existing groups keep their position, existing entries stay.
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"regexp"
+	"strconv"
+	"strings"
+)
+
+// StreamSettings declare one stream.
+type StreamSettings struct {
+	Name string `yaml:"name"`
+	// Groups are the consumer groups to create.
+	Groups []string `yaml:"groups,omitempty"`
+	// StartID is the entry new groups start after: $ (the default) for new
+	// entries only, 0 for the whole stream, or an entry ID.
+	StartID string `yaml:"start-id,omitempty"`
+	// MaxLen trims the stream to about this many entries when provisioned.
+	// Producers keep it there with XADD MAXLEN ~.
+	MaxLen int64 `yaml:"maxlen,omitempty"`
+}
+
+var streamEntryID = regexp.MustCompile(`^\d+(-\d+)?$`)
+
+func (s StreamSettings) startID() string {
+	if s.StartID == "" {
+		return "$"
+	}
+	return s.StartID
+}
+
+func validateStreams(streams []StreamSettings) error {
+	names := map[string]bool{}
+	for _, stream := range streams {
+		if stream.Name == "" {
+			return fmt.Errorf("stream without a name")
+		}
+		if names[stream.Name] {
+			return fmt.Errorf("stream %s is declared twice", stream.Name)
+		}
+		names[stream.Name] = true
+		groups := map[string]bool{}
+		for _, group := range stream.Groups {
+			if group == "" || groups[group] {
+				return fmt.Errorf("stream %s: empty or duplicate group %q", stream.Name, group)
+			}
+			groups[group] = true
+		}
+		if id := stream.StartID; id != "" && id != "$" && !streamEntryID.MatchString(id) {
+			return fmt.Errorf("stream %s: invalid start-id %q (want $, 0 or an entry ID)", stream.Name, id)
+		}
+		if stream.MaxLen < 0 {
+			return fmt.Errorf("stream %s: invalid maxlen %d", stream.Name, stream.MaxLen)
+		}
+	}
+	return nil
+}
+
+// streamProvisioningLines renders the provisioning as lines of the Job's shell
+// script, which defines `cli` (redis-cli against the primary) and `group`
+// (XGROUP CREATE ... MKSTREAM, tolerating BUSYGROUP).
+func streamProvisioningLines(streams []StreamSettings) []string {
+	var lines []string
+	for _, stream := range streams {
+		name := shellQuote(stream.Name)
+		if len(stream.Groups) == 0 {
+			lines = append(lines, fmt.Sprintf(`[ "$(cli exists %s)" != 0 ] || cli xadd %s MAXLEN 0 '*' provisioned 1 >/dev/null`, name, name))
+		}
+		for _, group := range stream.Groups {
+			lines = append(lines, fmt.Sprintf("group %s %s %s", name, shellQuote(group), shellQuote(stream.startID())))
+		}
+		if stream.MaxLen > 0 {
+			lines = append(lines, fmt.Sprintf("cli xtrim %s MAXLEN '~' %d >/dev/null", name, stream.MaxLen))
+		}
+	}
+	return lines
+}
+
+// shellQuote quotes s as one sh word.
+func shellQuote(s string) string {
+	return "'" + strings.ReplaceAll(s, "'", `'"'"'`) + "'"
+}
+
+func isBusyGroup(err error) bool {
+	var redisErr redisError
+	return errors.As(err, &redisErr) && strings.HasPrefix(string(redisErr), "BUSYGROUP")
+}
+
+func provisionStreams(conn *redisConn, streams []StreamSettings) error {
+	for _, stream := range streams {
+		if len(stream.Groups) == 0 {
+			reply, err := conn.Do("EXISTS", stream.Name)
+			if err != nil {
+				return err
+			}
+			if exists, _ := reply.(int64); exists == 0 {
+				if _, err = conn.Do("XADD", stream.Name, "MAXLEN", "0", "*", "provisioned", "1"); err != nil {
+					return fmt.Errorf("cannot create stream %s: %w", stream.Name, err)
+				}
+			}
+		}
+		for _, group := range stream.Groups {
+			_, err := conn.Do("XGROUP", "CREATE", stream.Name, group, stream.startID(), "MKSTREAM")
+			if err != nil && !isBusyGroup(err) {
+				return fmt.Errorf("cannot create group %s of stream %s: %w", group, stream.Name, err)
+			}
+		}
+		if stream.MaxLen > 0 {
+			if _, err := conn.Do("XTRIM", stream.Name, "MAXLEN", "~", strconv.FormatInt(stream.MaxLen, 10)); err != nil {
+				return fmt.Errorf("cannot trim stream %s: %w", stream.Name, err)
+			}
+		}
+	}
+	return nil
+}
+
+// provisionStreams creates the streams and groups of the settings in the
+// running redis.
+func (s *Runtime) provisionStreams(ctx context.Context) error {
+	if len(s.Streams) == 0 {
+		return nil
+	}
+	conn, err := s.dialRunning(ctx)
+	if err != nil {
+		return err
+	}
+	defer conn.Close()
+	return provisionStreams(conn, s.Streams)
+}
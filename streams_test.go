@@ -0,0 +1,84 @@
+package main
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestValidateStreams(t *testing.T) {
+	valid := []StreamSettings{
+		{Name: "orders", Groups: []string{"billing", "shipping"}, StartID: "0", MaxLen: 1000},
+		{Name: "audit", StartID: "1700000000000-0"},
+	}
+	if err := validateStreams(valid); err != nil {
+		t.Fatal(err)
+	}
+	for _, streams := range [][]StreamSettings{
+		{{Groups: []string{"billing"}}},
+		{{Name: "orders"}, {Name: "orders"}},
+		{{Name: "orders", Groups: []string{"billing", "billing"}}},
+		{{Name: "orders", StartID: "latest"}},
+		{{Name: "orders", MaxLen: -1}},
+	} {
+		if err := validateStreams(streams); err == nil {
+			t.Errorf("validateStreams(%+v) accepted", streams)
+		}
+	}
+}
+
+func TestProvisionStreamsIsIdempotent(t *testing.T) {
+	var commands []string
+	server := newFakeRedis(t, func(args []string) string {
+		commands = append(commands, strings.Join(args, " "))
+		switch strings.ToUpper(args[0]) {
+		case "XGROUP":
+			switch args[3] {
+			case "billing":
+				return "-BUSYGROUP Consumer Group name already exists\r\n"
+			case "broken":
+				return "-ERR Invalid stream ID specified as stream command argument\r\n"
+			}
+			return "+OK\r\n"
+		case "EXISTS":
+			return ":0\r\n"
+		case "XADD":
+			return bulkReply("1700000000000-0")
+		case "XTRIM":
+			return ":0\r\n"
+		}
+		return "-ERR unknown command\r\n"
+	})
+	conn, err := dialRedis(t.Context(), server.address, "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+	streams := []StreamSettings{
+		{Name: "orders", Groups: []string{"billing", "shipping"}, MaxLen: 1000},
+		{Name: "audit"},
+	}
+	if err = provisionStreams(conn, streams); err != nil {
+		t.Fatal(err)
+	}
+	want := []string{
+		"XGROUP CREATE orders billing $ MKSTREAM",
+		"XGROUP CREATE orders shipping $ MKSTREAM",
+		"XTRIM orders MAXLEN ~ 1000",
+		"EXISTS audit",
+		"XADD audit MAXLEN 0 * provisioned 1",
+	}
+	if strings.Join(commands, "\n") != strings.Join(want, "\n") {
+		t.Errorf("provisionStreams ran\n%s\nwant\n%s", strings.Join(commands, "\n"), strings.Join(want, "\n"))
+	}
+
+	if err = provisionStreams(conn, []StreamSettings{{Name: "orders", Groups: []string{"broken"}}}); err == nil {
+		t.Error("XGROUP CREATE error ignored")
+	}
+}
+
+func TestStreamProvisioningLinesQuoteNames(t *testing.T) {
+	lines := streamProvisioningLines([]StreamSettings{{Name: "it's", Groups: []string{"a b"}, StartID: "0"}})
+	if len(lines) != 1 || lines[0] != `group 'it'"'"'s' 'a b' '0'` {
+		t.Errorf("streamProvisioningLines = %q", lines)
+	}
+}
@@ -0,0 +1,126 @@
+package main
+
+// topology.go — connection details for the multi-pod deployment topologies.
+//
+// A standalone deployment is reached through the single "connection" string.
+// In a replicated deployment that string addresses the <name>-primary Service:
+// a sidecar of every redis pod labels it with its replication role, and the
+// Service only selects the pod labelled primary, so plain clients always
+// write to the current primary. The headless <name> Service keeps selecting
+// every pod for their stable DNS names. Replicated deployments additionally
+// publish how to reach Sentinel, which topology-aware clients can use to
+// discover the current primary themselves; cluster deployments publish seed
+// nodes for cluster-aware clients to bootstrap their slot map from. None of
+// these values are secret, so the restricted-portable profile publishes them
+// alongside its value-free connection reference.
+
+import (
+	"fmt"
+	"net"
+	"strings"
+
+	basev0 "github.com/codefly-dev/core/generated/go/codefly/base/v0"
+	"github.com/codefly-dev/core/resources"
+)
+
+const (
+	redisPort    = "6379"
+	sentinelPort = "26379"
+)
+
+// kubectlImage runs the sidecar labelling replicated redis pods with their
+// replication role. The sidecar loops in a shell, which the shell-less
+// registry.k8s.io/kubectl image lacks. The first replicated deployment pins
+// its digest in redis.lock.yaml.
+var kubectlImage = &resources.DockerImage{
+	Name: "alpine/kubectl",
+	Tag:  "1.31.4",
+}
+
+// primaryInstance addresses the <name>-primary Service of a replicated
+// deployment, next to the redis Service instance addresses.
+func primaryInstance(instance *basev0.NetworkInstance) (*basev0.NetworkInstance, error) {
+	name, domain, err := splitServiceAddress(instance)
+	if err != nil {
+		return nil, err
+	}
+	_, port, _ := net.SplitHostPort(instance.GetAddress())
+	host := name + "-primary"
+	if domain != "" {
+		host += "." + domain
+	}
+	return &basev0.NetworkInstance{
+		Hostname: host,
+		Host:     host,
+		Port:     instance.GetPort(),
+		Address:  net.JoinHostPort(host, port),
+		Access:   instance.Access,
+	}, nil
+}
+
+// topologyConfigurationValues are the extra "redis" configuration values the
+// resolved topology exports to dependents.
+func topologyConfigurationValues(instance *basev0.NetworkInstance, settings *DeploymentSettings) ([]*basev0.ConfigurationValue, error) {
+	if !settings.Replicated() && !settings.Clustered() {
+		return nil, nil
+	}
+	name, domain, err := splitServiceAddress(instance)
+	if err != nil {
+		return nil, err
+	}
+	switch {
+	case settings.Replicated():
+		sentinel := name + "-sentinel"
+		sentinels := podEndpoints(sentinel, sentinel, domain, settings.Sentinel.Replicas, sentinelPort)
+		return []*basev0.ConfigurationValue{
+			{Key: "sentinels", Value: strings.Join(sentinels, ",")},
+			{Key: "master-name", Value: settings.Sentinel.MasterName},
+		}, nil
+	case settings.Clustered():
+		seeds := podEndpoints(name, name+"-nodes", domain, settings.RedisReplicas(), redisPort)
+		return []*basev0.ConfigurationValue{
+			{Key: "seed-nodes", Value: strings.Join(seeds, ",")},
+		}, nil
+	}
+	return nil, nil
+}
+
+// splitServiceAddress splits the redis Service address `<name>.<domain>:port`
+// into the Service name and the domain its sibling Services share.
+func splitServiceAddress(instance *basev0.NetworkInstance) (string, string, error) {
+	host, _, err := net.SplitHostPort(instance.GetAddress())
+	if err != nil {
+		return "", "", fmt.Errorf("cannot parse redis address %q: %w", instance.GetAddress(), err)
+	}
+	name, domain, _ := strings.Cut(host, ".")
+	return name, domain, nil
+}
+
+// podEndpoints lists the stable per-pod DNS names of a StatefulSet governed by
+// a headless Service: `<statefulset>-<ordinal>.<service>.<domain>:<port>`.
+func podEndpoints(statefulSet, service, domain string, replicas int, port string) []string {
+	endpoints := make([]string, 0, replicas)
+	for ordinal := 0; ordinal < replicas; ordinal++ {
+		pod := fmt.Sprintf("%s-%d.%s", statefulSet, ordinal, service)
+		if domain != "" {
+			pod += "." + domain
+		}
+		endpoints = append(endpoints, net.JoinHostPort(pod, port))
+	}
+	return endpoints
+}
+
+// withConfigurationValues appends values to the "redis" information of conf.
+func withConfigurationValues(conf *basev0.Configuration, values ...*basev0.ConfigurationValue) *basev0.Configuration {
+	if len(values) == 0 {
+		return conf
+	}
+	for _, info := range conf.GetInfos() {
+		if info.GetName() == "redis" {
+			info.ConfigurationValues = append(info.ConfigurationValues, values...)
+			return conf
+		}
+	}
+	conf.Infos = append(conf.Infos, &basev0.ConfigurationInformation{Name: "redis", ConfigurationValues: values})
+	return conf
+}
@@ -0,0 +1,66 @@
+package main
+
+// vulnerabilities.go — image vulnerability scans the agent evaluates itself.
+//
+// Audit hands trivy's verdict straight to the CLI. Applying the audit policy,
+// to the service's images or to an upgrade candidate, needs the individual
+// findings, so the agent runs the same scanner with JSON output.
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"os/exec"
+	"strings"
+)
+
+// vulnerability is one finding of a scan.
+type vulnerability struct {
+	ID               string
+	Package          string
+	InstalledVersion string
+	FixedVersion     string
+	Severity         string
+}
+
+// trivyReport is the subset of `trivy image --format json` the agent reads.
+type trivyReport struct {
+	Results []struct {
+		Target          string `json:"Target"`
+		Vulnerabilities []struct {
+			VulnerabilityID  string `json:"VulnerabilityID"`
+			PkgName          string `json:"PkgName"`
+			InstalledVersion string `json:"InstalledVersion"`
+			FixedVersion     string `json:"FixedVersion"`
+			Severity         string `json:"Severity"`
+		} `json:"Vulnerabilities"`
+	} `json:"Results"`
+}
+
+// scanImage lists the vulnerabilities trivy finds in ref.
+func scanImage(ctx context.Context, ref string) ([]vulnerability, error) {
+	var stdout, stderr bytes.Buffer
+	cmd := exec.CommandContext(ctx, "trivy", "image", "--quiet", "--format", "json", "--scanners", "vuln", ref)
+	cmd.Stdout, cmd.Stderr = &stdout, &stderr
+	if err := cmd.Run(); err != nil {
+		return nil, fmt.Errorf("trivy scan of %s failed: %w: %s", ref, err, strings.TrimSpace(stderr.String()))
+	}
+	var report trivyReport
+	if err := json.Unmarshal(stdout.Bytes(), &report); err != nil {
+		return nil, fmt.Errorf("cannot parse trivy report for %s: %w", ref, err)
+	}
+	var vulnerabilities []vulnerability
+	for _, result := range report.Results {
+		for _, v := range result.Vulnerabilities {
+			vulnerabilities = append(vulnerabilities, vulnerability{
+				ID:               v.VulnerabilityID,
+				Package:          v.PkgName,
+				InstalledVersion: v.InstalledVersion,
+				FixedVersion:     v.FixedVersion,
+				Severity:         strings.ToUpper(v.Severity),
+			})
+		}
+	}
+	return vulnerabilities, nil
+}